@@ -4,6 +4,7 @@ go 1.25.6
 
 require (
 	github.com/hashicorp/yamux v0.1.2
+	github.com/klauspost/compress v1.18.0
 	github.com/quic-go/quic-go v0.59.0
 	google.golang.org/grpc v1.78.0
 )
@@ -0,0 +1,262 @@
+// Package admission 提供监听端的连接准入控制
+// 在 TLS 握手等高开销操作之前，按来源地址、速率和并发数拒绝连接
+package admission
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/ratelimit"
+	"github.com/funcx27/qymux/pkg/utils"
+)
+
+var (
+	// ErrDenied 来源地址不在允许列表内或命中拒绝列表
+	ErrDenied = errors.New("admission: source address denied")
+
+	// ErrRateLimited 超出新建连接速率限制
+	ErrRateLimited = errors.New("admission: connection rate limit exceeded")
+
+	// ErrTooManyHandshakes 进行中的握手数已达上限
+	ErrTooManyHandshakes = errors.New("admission: too many pending handshakes")
+
+	// ErrTooManySessions 并发会话数已达上限
+	ErrTooManySessions = errors.New("admission: too many sessions")
+)
+
+const (
+	// ipIdleTimeout 来源 IP 的限速器在空闲多久后被回收
+	ipIdleTimeout = 10 * time.Minute
+
+	// sweepInterval 回收空闲限速器的间隔
+	sweepInterval = time.Minute
+)
+
+// Config 定义准入控制配置，零值字段表示不限制
+type Config struct {
+	// AllowCIDRs 允许的来源网段，为空时允许所有来源
+	AllowCIDRs []string
+
+	// DenyCIDRs 拒绝的来源网段，优先于 AllowCIDRs
+	DenyCIDRs []string
+
+	// PerIPRate 每个来源 IP 每秒允许的新建连接数
+	PerIPRate float64
+
+	// PerIPBurst 每个来源 IP 的突发连接数
+	PerIPBurst int
+
+	// GlobalRate 全局每秒允许的新建连接数
+	GlobalRate float64
+
+	// GlobalBurst 全局突发连接数
+	GlobalBurst int
+
+	// MaxSessions 最大并发会话数（含握手中的连接）
+	MaxSessions int
+
+	// MaxPendingHandshakes 最大进行中的握手数
+	MaxPendingHandshakes int
+}
+
+// Stats 记录准入控制的计数
+type Stats struct {
+	Accepted          uint64 // 通过准入的连接数
+	Denied            uint64 // 因来源地址被拒绝的连接数
+	RateLimited       uint64 // 因速率限制被拒绝的连接数
+	TooManyHandshakes uint64 // 因握手并发上限被拒绝的连接数
+	TooManySessions   uint64 // 因会话并发上限被拒绝的连接数
+	PendingHandshakes int64  // 当前进行中的握手数
+	ActiveSessions    int64  // 当前占用名额的会话数
+}
+
+// Controller 执行准入控制，可在多个监听器之间共享
+// nil Controller 放行所有连接
+type Controller struct {
+	config Config
+	allow  []*net.IPNet
+	deny   []*net.IPNet
+	global *ratelimit.Limiter
+
+	mu        sync.Mutex
+	perIP     map[string]*ipEntry
+	lastSweep time.Time
+
+	pending atomic.Int64
+	active  atomic.Int64
+
+	accepted          atomic.Uint64
+	denied            atomic.Uint64
+	rateLimited       atomic.Uint64
+	tooManyHandshakes atomic.Uint64
+	tooManySessions   atomic.Uint64
+}
+
+// ipEntry 单个来源 IP 的限速状态
+type ipEntry struct {
+	limiter  *ratelimit.Limiter
+	lastSeen time.Time
+}
+
+// NewController 创建准入控制器
+func NewController(config *Config) (*Controller, error) {
+	if config == nil {
+		config = &Config{}
+	}
+
+	allow, err := utils.ParseCIDRs(config.AllowCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("admission: allow list: %w", err)
+	}
+	deny, err := utils.ParseCIDRs(config.DenyCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("admission: deny list: %w", err)
+	}
+
+	c := &Controller{
+		config: *config,
+		allow:  allow,
+		deny:   deny,
+		perIP:  make(map[string]*ipEntry),
+	}
+	if config.GlobalRate > 0 {
+		c.global = ratelimit.NewLimiter(config.GlobalRate, config.GlobalBurst)
+	}
+	return c, nil
+}
+
+// Admit 检查来自 addr 的新连接是否允许进入
+// 通过时返回的 Ticket 占用一个握手名额和一个会话名额，调用方必须在结束时释放
+func (c *Controller) Admit(addr net.Addr) (*Ticket, error) {
+	if c == nil {
+		return &Ticket{}, nil
+	}
+
+	ip := utils.AddrIP(addr)
+	if utils.ContainsIP(c.deny, ip) || (len(c.allow) > 0 && !utils.ContainsIP(c.allow, ip)) {
+		c.denied.Add(1)
+		return nil, ErrDenied
+	}
+
+	if !c.allowIP(ip) || (c.global != nil && !c.global.Allow()) {
+		c.rateLimited.Add(1)
+		return nil, ErrRateLimited
+	}
+
+	if !acquire(&c.pending, c.config.MaxPendingHandshakes) {
+		c.tooManyHandshakes.Add(1)
+		return nil, ErrTooManyHandshakes
+	}
+	if !acquire(&c.active, c.config.MaxSessions) {
+		c.pending.Add(-1)
+		c.tooManySessions.Add(1)
+		return nil, ErrTooManySessions
+	}
+
+	c.accepted.Add(1)
+	return &Ticket{controller: c, handshaking: true, holding: true}, nil
+}
+
+// Stats 返回当前计数的快照
+func (c *Controller) Stats() Stats {
+	if c == nil {
+		return Stats{}
+	}
+	return Stats{
+		Accepted:          c.accepted.Load(),
+		Denied:            c.denied.Load(),
+		RateLimited:       c.rateLimited.Load(),
+		TooManyHandshakes: c.tooManyHandshakes.Load(),
+		TooManySessions:   c.tooManySessions.Load(),
+		PendingHandshakes: c.pending.Load(),
+		ActiveSessions:    c.active.Load(),
+	}
+}
+
+// allowIP 检查单个来源 IP 的速率限制
+func (c *Controller) allowIP(ip net.IP) bool {
+	if c.config.PerIPRate <= 0 || ip == nil {
+		return true
+	}
+
+	now := time.Now()
+	key := ip.String()
+
+	c.mu.Lock()
+	if now.Sub(c.lastSweep) > sweepInterval {
+		for k, e := range c.perIP {
+			if now.Sub(e.lastSeen) > ipIdleTimeout {
+				delete(c.perIP, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	entry, ok := c.perIP[key]
+	if !ok {
+		entry = &ipEntry{limiter: ratelimit.NewLimiter(c.config.PerIPRate, c.config.PerIPBurst)}
+		c.perIP[key] = entry
+	}
+	entry.lastSeen = now
+	c.mu.Unlock()
+
+	return entry.limiter.AllowN(now, 1)
+}
+
+// acquire 在不超过 limit 的前提下将计数加一，limit <= 0 表示不限制
+func acquire(counter *atomic.Int64, limit int) bool {
+	if limit <= 0 {
+		counter.Add(1)
+		return true
+	}
+	for {
+		n := counter.Load()
+		if n >= int64(limit) {
+			return false
+		}
+		if counter.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// Ticket 表示一个已通过准入的连接所占用的名额
+type Ticket struct {
+	controller  *Controller
+	mu          sync.Mutex
+	handshaking bool
+	holding     bool
+}
+
+// HandshakeDone 握手完成后释放握手名额，会话名额继续保留
+func (t *Ticket) HandshakeDone() {
+	if t == nil || t.controller == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.handshaking {
+		t.handshaking = false
+		t.controller.pending.Add(-1)
+	}
+}
+
+// Release 释放全部名额，可重复调用
+// 握手失败或会话结束时调用
+func (t *Ticket) Release() {
+	if t == nil || t.controller == nil {
+		return
+	}
+	t.HandshakeDone()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.holding {
+		t.holding = false
+		t.controller.active.Add(-1)
+	}
+}
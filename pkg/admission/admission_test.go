@@ -0,0 +1,105 @@
+package admission
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func addr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 12345}
+}
+
+func TestControllerACL(t *testing.T) {
+	c, err := NewController(&Config{
+		AllowCIDRs: []string{"10.0.0.0/8"},
+		DenyCIDRs:  []string{"10.0.0.66"},
+	})
+	if err != nil {
+		t.Fatalf("NewController() error = %v", err)
+	}
+
+	tests := []struct {
+		ip      string
+		wantErr error
+	}{
+		{"10.1.2.3", nil},
+		{"10.0.0.66", ErrDenied},
+		{"192.168.1.1", ErrDenied},
+	}
+	for _, tt := range tests {
+		ticket, err := c.Admit(addr(tt.ip))
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("Admit(%s) error = %v, want %v", tt.ip, err, tt.wantErr)
+		}
+		ticket.Release()
+	}
+
+	if stats := c.Stats(); stats.Denied != 2 || stats.Accepted != 1 {
+		t.Errorf("Stats() = %+v, want Denied=2 Accepted=1", stats)
+	}
+}
+
+func TestControllerPerIPRate(t *testing.T) {
+	c, _ := NewController(&Config{PerIPRate: 1, PerIPBurst: 2})
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Admit(addr("10.0.0.1")); err != nil {
+			t.Fatalf("Admit() #%d error = %v", i, err)
+		}
+	}
+	if _, err := c.Admit(addr("10.0.0.1")); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Admit() error = %v, want ErrRateLimited", err)
+	}
+
+	// 其他来源不受影响
+	if _, err := c.Admit(addr("10.0.0.2")); err != nil {
+		t.Errorf("Admit() from other source error = %v", err)
+	}
+}
+
+func TestControllerLimits(t *testing.T) {
+	c, _ := NewController(&Config{MaxSessions: 2, MaxPendingHandshakes: 1})
+
+	first, err := c.Admit(addr("10.0.0.1"))
+	if err != nil {
+		t.Fatalf("Admit() error = %v", err)
+	}
+	if _, err := c.Admit(addr("10.0.0.1")); !errors.Is(err, ErrTooManyHandshakes) {
+		t.Errorf("Admit() error = %v, want ErrTooManyHandshakes", err)
+	}
+
+	first.HandshakeDone()
+	second, err := c.Admit(addr("10.0.0.1"))
+	if err != nil {
+		t.Fatalf("Admit() after handshake done error = %v", err)
+	}
+	second.HandshakeDone()
+
+	if _, err := c.Admit(addr("10.0.0.1")); !errors.Is(err, ErrTooManySessions) {
+		t.Errorf("Admit() error = %v, want ErrTooManySessions", err)
+	}
+
+	// 重复释放不应重复归还名额
+	first.Release()
+	first.Release()
+	if stats := c.Stats(); stats.ActiveSessions != 1 || stats.PendingHandshakes != 0 {
+		t.Errorf("Stats() = %+v, want ActiveSessions=1 PendingHandshakes=0", stats)
+	}
+}
+
+func TestNilController(t *testing.T) {
+	var c *Controller
+	ticket, err := c.Admit(addr("10.0.0.1"))
+	if err != nil {
+		t.Fatalf("nil Controller Admit() error = %v", err)
+	}
+	ticket.HandshakeDone()
+	ticket.Release()
+}
+
+func TestNewControllerInvalidCIDR(t *testing.T) {
+	if _, err := NewController(&Config{DenyCIDRs: []string{"bad"}}); err == nil {
+		t.Error("NewController() with invalid CIDR should fail")
+	}
+}
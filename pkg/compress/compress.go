@@ -0,0 +1,290 @@
+// Package compress 为会话上的流提供可协商的压缩
+//
+// 流打开时发起端发送压缩头部，声明本端写入使用的算法和可以解压的算法；接收端据此选择自己写入方向的算法，
+// 并在第一次写入时回复。两个方向独立压缩，协商不需要额外的往返。每次 Write 的数据单独压缩成帧，
+// 不在本层缓冲，因此读写截止时间、半关闭等语义与底层流一致。
+//
+// 内置 zstd、snappy、gzip 和 deflate，其他算法可以实现 Codec 并在两端以相同的名称 Register
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// 内置算法名称
+const (
+	Zstd    = "zstd"
+	Snappy  = "snappy"
+	Gzip    = "gzip"
+	Deflate = "deflate"
+)
+
+var (
+	// ErrUnknownCodec 本端没有注册该算法
+	ErrUnknownCodec = errors.New("compress: unknown codec")
+
+	// ErrUnsupportedCodec 对端写入使用的算法本端不接受
+	ErrUnsupportedCodec = errors.New("compress: unsupported codec")
+
+	// ErrInvalidFrame 压缩头部或数据帧格式错误
+	ErrInvalidFrame = errors.New("compress: invalid frame")
+)
+
+// Codec 压缩算法，每个帧独立压缩，实现需要可以被并发调用
+type Codec interface {
+	// Name 返回算法名称，两端以名称协商，最长 255 字节
+	Name() string
+
+	// Compress 压缩 src 并追加到 dst
+	Compress(dst, src []byte) ([]byte, error)
+
+	// Decompress 解压 src 并追加到 dst，size 为原始长度，解压结果超过 size 时应返回错误
+	Decompress(dst, src []byte, size int) ([]byte, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = make(map[string]Codec)
+)
+
+func init() {
+	Register(&zstdCodec{})
+	Register(snappyCodec{})
+	Register(newFlateCodec(Gzip, flate.DefaultCompression))
+	Register(newFlateCodec(Deflate, flate.DefaultCompression))
+}
+
+// Register 注册压缩算法，同名的算法被替换；名称为空或超过 255 字节时 panic
+func Register(c Codec) {
+	if n := len(c.Name()); n == 0 || n > 255 {
+		panic(fmt.Sprintf("compress: invalid codec name %q", c.Name()))
+	}
+
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.Name()] = c
+}
+
+// Codecs 返回已注册的算法名称
+func Codecs() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookup 按名称查找已注册的算法
+func lookup(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCodec, name)
+	}
+	return c, nil
+}
+
+// flateCodec 基于标准库 compress/flate 的 deflate 与 gzip
+type flateCodec struct {
+	name    string
+	level   int
+	writers sync.Pool
+	readers sync.Pool
+}
+
+// newFlateCodec 创建 deflate 算法，name 为 Gzip 时使用 gzip 格式
+func newFlateCodec(name string, level int) *flateCodec {
+	return &flateCodec{name: name, level: level}
+}
+
+// Name 实现 Codec
+func (c *flateCodec) Name() string {
+	return c.name
+}
+
+// Compress 实现 Codec
+func (c *flateCodec) Compress(dst, src []byte) ([]byte, error) {
+	buf := &appendWriter{b: dst}
+	var w io.WriteCloser
+	if pooled := c.writers.Get(); pooled != nil {
+		w = pooled.(io.WriteCloser)
+		w.(interface{ Reset(io.Writer) }).Reset(buf)
+	} else {
+		var err error
+		if c.name == Gzip {
+			w, err = gzip.NewWriterLevel(buf, c.level)
+		} else {
+			w, err = flate.NewWriter(buf, c.level)
+		}
+		if err != nil {
+			return dst, err
+		}
+	}
+	defer c.writers.Put(w)
+
+	if _, err := w.Write(src); err != nil {
+		return dst, err
+	}
+	if err := w.Close(); err != nil {
+		return dst, err
+	}
+	return buf.b, nil
+}
+
+// Decompress 实现 Codec
+func (c *flateCodec) Decompress(dst, src []byte, size int) ([]byte, error) {
+	r, err := c.reader(bytes.NewReader(src))
+	if err != nil {
+		return dst, err
+	}
+	defer c.readers.Put(r)
+
+	dst = slices.Grow(dst, size)
+	out := dst[len(dst) : len(dst)+size]
+	if _, err := io.ReadFull(r, out); err != nil {
+		return dst, fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+	}
+	// 解压结果应恰好为 size 字节
+	var extra [1]byte
+	if n, _ := r.Read(extra[:]); n > 0 {
+		return dst, fmt.Errorf("%w: decompressed data exceeds %d bytes", ErrInvalidFrame, size)
+	}
+	return dst[:len(dst)+size], nil
+}
+
+// reader 返回读取 src 的解压器，复用池中的实例
+func (c *flateCodec) reader(src io.Reader) (io.ReadCloser, error) {
+	pooled := c.readers.Get()
+	if c.name == Gzip {
+		if pooled != nil {
+			zr := pooled.(*gzip.Reader)
+			if err := zr.Reset(src); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+			}
+			return zr, nil
+		}
+		zr, err := gzip.NewReader(src)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+		}
+		return zr, nil
+	}
+
+	if pooled != nil {
+		fr := pooled.(io.ReadCloser)
+		fr.(flate.Resetter).Reset(src, nil)
+		return fr, nil
+	}
+	return flate.NewReader(src), nil
+}
+
+// appendWriter 追加写入到切片
+type appendWriter struct {
+	b []byte
+}
+
+// Write 实现 io.Writer
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+	return len(p), nil
+}
+
+// zstdCodec 基于 klauspost/compress/zstd，编码器与解码器在第一次使用时创建
+type zstdCodec struct {
+	once    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	err     error
+}
+
+// init 创建编码器与解码器，解码结果不超过单个帧的最大原始长度
+func (c *zstdCodec) init() error {
+	c.once.Do(func() {
+		c.encoder, c.err = zstd.NewWriter(nil, zstd.WithEncoderCRC(false))
+		if c.err != nil {
+			return
+		}
+		c.decoder, c.err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxFrameSize))
+	})
+	return c.err
+}
+
+// Name 实现 Codec
+func (c *zstdCodec) Name() string {
+	return Zstd
+}
+
+// Compress 实现 Codec
+func (c *zstdCodec) Compress(dst, src []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return dst, err
+	}
+	return c.encoder.EncodeAll(src, dst), nil
+}
+
+// Decompress 实现 Codec
+func (c *zstdCodec) Decompress(dst, src []byte, size int) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return dst, err
+	}
+	out, err := c.decoder.DecodeAll(src, slices.Grow(dst, size))
+	if err != nil {
+		return dst, fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+	}
+	if len(out)-len(dst) != size {
+		return dst, fmt.Errorf("%w: decompressed %d bytes, want %d", ErrInvalidFrame, len(out)-len(dst), size)
+	}
+	return out, nil
+}
+
+// snappyCodec snappy 块格式，基于 klauspost/compress/s2 的兼容编码
+type snappyCodec struct{}
+
+// Name 实现 Codec
+func (snappyCodec) Name() string {
+	return Snappy
+}
+
+// Compress 实现 Codec
+func (snappyCodec) Compress(dst, src []byte) ([]byte, error) {
+	n := s2.MaxEncodedLen(len(src))
+	if n < 0 {
+		return dst, s2.ErrTooLarge
+	}
+	dst = slices.Grow(dst, n)
+	encoded := s2.EncodeSnappy(dst[len(dst):len(dst)+n], src)
+	return dst[:len(dst)+len(encoded)], nil
+}
+
+// Decompress 实现 Codec
+func (snappyCodec) Decompress(dst, src []byte, size int) ([]byte, error) {
+	n, err := s2.DecodedLen(src)
+	if err != nil {
+		return dst, fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+	}
+	if n != size {
+		return dst, fmt.Errorf("%w: decompressed %d bytes, want %d", ErrInvalidFrame, n, size)
+	}
+	dst = slices.Grow(dst, size)
+	if _, err := s2.Decode(dst[len(dst):len(dst)+size], src); err != nil {
+		return dst, fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+	}
+	return dst[:len(dst)+size], nil
+}
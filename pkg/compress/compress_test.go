@@ -0,0 +1,210 @@
+package compress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/funcx27/qymux/pkg/grpctunnel"
+	"github.com/funcx27/qymux/pkg/tcp"
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// newSessionPair 建立一对 TCP 会话，分别按 clientOpts 和 serverOpts 包装压缩
+func newSessionPair(t *testing.T, clientOpts, serverOpts *Options) (client, server *Session) {
+	t.Helper()
+
+	ln, err := tcp.Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	c, err := tcp.NewDialer(nil).Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	s, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	if client, err = NewSession(c, clientOpts); err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	if server, err = NewSession(s, serverOpts); err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	return client, server
+}
+
+// openPair 打开一个流并在对端接受
+func openPair(t *testing.T, client, server *Session) (*Conn, *Conn) {
+	t.Helper()
+
+	opened, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	t.Cleanup(func() { opened.Close() })
+	accepted, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("AcceptStream() error = %v", err)
+	}
+	t.Cleanup(func() { accepted.Close() })
+	return opened.(*Conn), accepted.(*Conn)
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	src := []byte(strings.Repeat("2026-10-18 INFO request completed status=200\n", 100))
+	for _, name := range []string{Zstd, Snappy, Gzip, Deflate} {
+		codec, err := lookup(name)
+		if err != nil {
+			t.Fatalf("lookup(%q) error = %v", name, err)
+		}
+		compressed, err := codec.Compress([]byte("prefix"), src)
+		if err != nil || !bytes.HasPrefix(compressed, []byte("prefix")) || len(compressed) >= len(src)/4 {
+			t.Fatalf("%s Compress() = %d bytes, %v", name, len(compressed), err)
+		}
+		out, err := codec.Decompress(nil, compressed[6:], len(src))
+		if err != nil || !bytes.Equal(out, src) {
+			t.Errorf("%s Decompress() = %d bytes, %v", name, len(out), err)
+		}
+		if _, err := codec.Decompress(nil, compressed[6:], len(src)-1); !errors.Is(err, ErrInvalidFrame) {
+			t.Errorf("%s Decompress() with short size error = %v, want ErrInvalidFrame", name, err)
+		}
+	}
+	if _, err := lookup("lz4"); !errors.Is(err, ErrUnknownCodec) {
+		t.Errorf("lookup(lz4) error = %v, want ErrUnknownCodec", err)
+	}
+}
+
+func TestSession(t *testing.T) {
+	client, server := newSessionPair(t, &Options{Codec: Gzip}, &Options{Codec: Deflate})
+	opened, accepted := openPair(t, client, server)
+
+	logs := []byte(strings.Repeat("2026-10-18T10:00:00Z INFO agent heartbeat ok\n", 5000))
+	go func() {
+		opened.Write(logs)
+		opened.Write([]byte("tail")) // 短于 MinSize 的写入不压缩
+		opened.CloseWrite()
+	}()
+
+	got, err := io.ReadAll(accepted)
+	if err != nil || !bytes.Equal(got, append(logs, "tail"...)) {
+		t.Fatalf("ReadAll() = %d bytes, %v; want %d bytes", len(got), err, len(logs)+4)
+	}
+	if write, read := accepted.Negotiated(); write != Deflate || read != Gzip {
+		t.Errorf("accepted Negotiated() = %q, %q", write, read)
+	}
+	if wire := opened.Stats().BytesWritten; wire == 0 || wire > uint64(len(logs)/10) {
+		t.Errorf("compressed %d bytes to %d on the wire", len(logs), wire)
+	}
+
+	// 反方向
+	go func() {
+		accepted.Write(logs)
+		accepted.CloseWrite()
+	}()
+	if got, err := io.ReadAll(opened); err != nil || !bytes.Equal(got, logs) {
+		t.Errorf("ReadAll() reply = %d bytes, %v", len(got), err)
+	}
+	if write, read := opened.Negotiated(); write != Gzip || read != Deflate {
+		t.Errorf("opened Negotiated() = %q, %q", write, read)
+	}
+}
+
+func TestConnReadDeadline(t *testing.T) {
+	client, server := newSessionPair(t, &Options{Codec: Gzip}, nil)
+	opened, accepted := openPair(t, client, server)
+
+	// 头部已读取但帧只到达一部分时超时，之后可以继续读取
+	payload := []byte(strings.Repeat("resumable ", 100))
+	frame := opened.appendFrame(nil, payload)
+	opened.Unwrap().Write(frame[:3])
+
+	buf := make([]byte, 2048)
+	accepted.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := accepted.Read(buf); !isTimeout(err) {
+		t.Fatalf("Read() error = %v, want deadline exceeded", err)
+	}
+
+	opened.Unwrap().Write(frame[3:])
+	accepted.SetReadDeadline(time.Time{})
+	n, err := io.ReadFull(accepted, buf[:len(payload)])
+	if err != nil || !bytes.Equal(buf[:n], payload) {
+		t.Errorf("Read() after timeout = %q, %v", buf[:n], err)
+	}
+}
+
+func TestUnsupportedCodec(t *testing.T) {
+	client, server := newSessionPair(t, &Options{Codec: Gzip}, &Options{Accept: []string{Deflate}})
+	opened, accepted := openPair(t, client, server)
+
+	opened.Write([]byte("hello"))
+	if _, err := accepted.Read(make([]byte, 8)); !errors.Is(err, ErrUnsupportedCodec) {
+		t.Errorf("Read() error = %v, want ErrUnsupportedCodec", err)
+	}
+	var streamErr *transport.StreamError
+	opened.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := opened.Read(make([]byte, 8)); !errors.As(err, &streamErr) || streamErr.Code != transport.CodeRefused {
+		t.Errorf("peer Read() error = %v, want reset with CodeRefused", err)
+	}
+
+	// 发起端不接受接收端的算法时，接收端写入不压缩
+	client, server = newSessionPair(t, &Options{Accept: []string{Gzip}}, &Options{Codec: Deflate})
+	opened, accepted = openPair(t, client, server)
+	go accepted.Write([]byte(strings.Repeat("x", 1024)))
+	if _, err := io.ReadFull(opened, make([]byte, 1024)); err != nil {
+		t.Fatalf("ReadFull() error = %v", err)
+	}
+	if write, read := accepted.Negotiated(); write != "" || read != "" {
+		t.Errorf("Negotiated() = %q, %q; want no compression", write, read)
+	}
+
+	if _, err := NewSession(client, &Options{Codec: "lz4"}); !errors.Is(err, ErrUnknownCodec) {
+		t.Errorf("NewSession(lz4) error = %v, want ErrUnknownCodec", err)
+	}
+}
+
+func TestGRPCOverSession(t *testing.T) {
+	for _, name := range []string{Zstd, Snappy, Gzip} {
+		t.Run(name, func(t *testing.T) {
+			client, server := newSessionPair(t, &Options{Codec: name}, &Options{Codec: name})
+
+			// 服务名足够长，请求与响应都超过 MinSize 而被压缩
+			service := strings.Repeat("logs.", 200)
+			hs := health.NewServer()
+			hs.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+			srv := grpc.NewServer()
+			healthpb.RegisterHealthServer(srv, hs)
+			tunnel := grpctunnel.NewServer(srv, nil)
+			tunnel.Serve(server)
+			t.Cleanup(tunnel.Stop)
+
+			conn, err := grpctunnel.NewClient(grpctunnel.Target("edge1"), grpctunnel.Single(client), nil)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			t.Cleanup(func() { conn.Close() })
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+			if err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+				t.Errorf("Check() over %s session = %v, %v; want SERVING", name, resp, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,456 @@
+package compress
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// offerMagic 压缩头部的魔数，用于尽早识别未启用压缩的对端
+var offerMagic = [3]byte{'Q', 'Y', 'Z'}
+
+// headerVersion 当前头部格式版本
+const headerVersion = 1
+
+const (
+	// DefaultMinSize 默认的最小压缩长度，更短的写入压缩后通常不会变小
+	DefaultMinSize = 256
+
+	// maxFrameSize 单个帧的最大原始长度，更长的写入拆分为多个帧
+	maxFrameSize = 32 * 1024
+
+	// frameHeaderSize 帧头长度：type (1) | rawLen (2) | dataLen (2)
+	frameHeaderSize = 5
+
+	// 帧类型
+	frameRaw        = 0x0
+	frameCompressed = 0x1
+)
+
+// Options 定义流压缩的可选配置，两端分别配置
+type Options struct {
+	// Codec 本端写入方向使用的算法，为空时不压缩
+	// 发起端的算法对端不接受时，对端以 transport.CodeRefused 重置流；接收端的算法发起端不接受时不压缩
+	Codec string
+
+	// Accept 本端可以解压的算法，为 nil 时接受所有已注册的算法
+	Accept []string
+
+	// MinSize 小于该长度的写入不压缩，为 0 时使用 DefaultMinSize
+	MinSize int
+}
+
+// Conn 是压缩的流，每次 Write 的数据单独压缩成帧并立即写入底层流
+// 读写截止时间直接作用于底层流：读超时后可以继续读取；写超时时如果帧已部分写入，之后的写入都返回该错误
+type Conn struct {
+	net.Conn
+	client  bool
+	codec   Codec           // 本端配置的写入算法
+	accept  map[string]bool // 为 nil 时接受所有已注册的算法
+	minSize int
+
+	handshaken atomic.Bool // 已读取对端的压缩头部
+	readCodec  Codec       // 对端写入的算法，握手后只读
+	writeCodec Codec       // 本端写入的算法，握手后只读
+
+	rmu  sync.Mutex
+	rbuf []byte // 已读取但尚未解析的字节，读超时后保留
+	obuf []byte
+	out  []byte // 解压后尚未返回的数据
+	rerr error
+
+	wmu     sync.Mutex
+	wbuf    []byte
+	pending []byte // 接收端的回复头部，随第一个帧写入
+	werr    error
+}
+
+// Client 在发起端包装流并立即发送压缩头部
+func Client(conn net.Conn, opts *Options) (*Conn, error) {
+	c, err := newConn(conn, opts, true)
+	if err != nil {
+		return nil, err
+	}
+	c.writeCodec = c.codec
+
+	offer := c.appendOffer(make([]byte, 0, 64))
+	if _, err := conn.Write(offer); err != nil {
+		return nil, fmt.Errorf("write compression header failed: %w", err)
+	}
+	return c, nil
+}
+
+// Server 在接收端包装流，第一次读写时读取发起端的压缩头部
+func Server(conn net.Conn, opts *Options) (*Conn, error) {
+	return newConn(conn, opts, false)
+}
+
+// newConn 校验配置并创建 Conn
+func newConn(conn net.Conn, opts *Options, client bool) (*Conn, error) {
+	c := &Conn{Conn: conn, client: client, minSize: DefaultMinSize}
+	if opts == nil {
+		return c, nil
+	}
+
+	if opts.Codec != "" {
+		codec, err := lookup(opts.Codec)
+		if err != nil {
+			return nil, err
+		}
+		c.codec = codec
+	}
+	if opts.Accept != nil {
+		c.accept = make(map[string]bool, len(opts.Accept))
+		for _, name := range opts.Accept {
+			if len(name) == 0 || len(name) > 255 {
+				return nil, fmt.Errorf("%w: %q", ErrUnknownCodec, name)
+			}
+			c.accept[name] = true
+		}
+	}
+	if opts.MinSize > 0 {
+		c.minSize = opts.MinSize
+	}
+	return c, nil
+}
+
+// Negotiated 返回两个方向实际使用的算法名称，不压缩的方向为空
+// 接收端在握手前两者都为空，发起端在握手前 read 为空
+func (c *Conn) Negotiated() (write, read string) {
+	done := c.handshaken.Load()
+	if (c.client || done) && c.writeCodec != nil {
+		write = c.writeCodec.Name()
+	}
+	if done && c.readCodec != nil {
+		read = c.readCodec.Name()
+	}
+	return write, read
+}
+
+// Read 读取并解压对端写入的数据
+func (c *Conn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	for len(c.out) == 0 {
+		if c.rerr != nil {
+			return 0, c.rerr
+		}
+		err := c.readHandshake()
+		if err == nil {
+			err = c.readFrame()
+		}
+		if err != nil {
+			if !isTimeout(err) {
+				c.rerr = err
+			}
+			return 0, err
+		}
+	}
+
+	n := copy(b, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+// Write 压缩 b 并写入底层流，返回时数据已写入底层流
+func (c *Conn) Write(b []byte) (int, error) {
+	if !c.client && !c.handshaken.Load() {
+		// 接收端写入的算法取决于发起端接受的算法
+		c.rmu.Lock()
+		err := c.readHandshake()
+		c.rmu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.werr != nil {
+		return 0, c.werr
+	}
+
+	written := 0
+	for len(b) > 0 {
+		chunk := b[:min(len(b), maxFrameSize)]
+		buf := append(c.wbuf[:0], c.pending...)
+		buf = c.appendFrame(buf, chunk)
+		c.wbuf = buf
+
+		n, err := c.Conn.Write(buf)
+		if err != nil {
+			// 帧已部分写入时流无法继续使用
+			if n > 0 || !isTimeout(err) {
+				c.werr = err
+			}
+			return written, err
+		}
+		c.pending = nil
+		written += len(chunk)
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
+
+// CloseWrite 关闭底层流的写方向，帧之间没有需要结束的压缩状态
+func (c *Conn) CloseWrite() error {
+	return transport.CloseWrite(c.Conn)
+}
+
+// CloseRead 关闭底层流的读方向
+func (c *Conn) CloseRead() error {
+	return transport.CloseRead(c.Conn)
+}
+
+// ID 返回底层流的 ID，底层连接不是 transport.Stream 时返回 0
+func (c *Conn) ID() uint64 {
+	if s, ok := c.Conn.(transport.Stream); ok {
+		return s.ID()
+	}
+	return 0
+}
+
+// Reset 重置底层流
+func (c *Conn) Reset(code transport.ErrorCode) error {
+	return transport.ResetStream(c.Conn, code)
+}
+
+// Stats 返回底层流的统计信息，读写字节数为压缩后的长度
+func (c *Conn) Stats() transport.StreamStats {
+	if s, ok := c.Conn.(transport.Stream); ok {
+		return s.Stats()
+	}
+	return transport.StreamStats{}
+}
+
+// Unwrap 返回被压缩的底层连接
+func (c *Conn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// appendOffer 追加发起端的压缩头部：
+//
+//	magic "QYZ" | version (1) | codecLen (1) | codec | count (1) | { nameLen (1) | name }
+func (c *Conn) appendOffer(b []byte) []byte {
+	accept := Codecs()
+	if c.accept != nil {
+		accept = accept[:0]
+		for name := range c.accept {
+			accept = append(accept, name)
+		}
+		slices.Sort(accept)
+	}
+	accept = accept[:min(len(accept), 255)]
+
+	b = append(b, offerMagic[:]...)
+	b = append(b, headerVersion)
+	b = appendName(b, c.writeCodec)
+	b = append(b, byte(len(accept)))
+	for _, name := range accept {
+		b = append(b, byte(len(name)))
+		b = append(b, name...)
+	}
+	return b
+}
+
+// readHandshake 读取对端的压缩头部，调用时持有 c.rmu；超时以外的错误之后的读写都会返回
+func (c *Conn) readHandshake() error {
+	if c.handshaken.Load() {
+		return nil
+	}
+	if c.rerr != nil {
+		return c.rerr
+	}
+	err := c.handshake()
+	if err != nil && !isTimeout(err) {
+		c.rerr = err
+	}
+	return err
+}
+
+// handshake 接收端读取发起端的头部并确定本端写入的算法，发起端读取接收端的回复：
+//
+//	version (1) | codecLen (1) | codec
+func (c *Conn) handshake() error {
+	off := 0
+	if !c.client {
+		if err := c.fill(len(offerMagic)); err != nil {
+			return err
+		}
+		if [3]byte(c.rbuf[:3]) != offerMagic {
+			return fmt.Errorf("%w: bad magic", ErrInvalidFrame)
+		}
+		off = len(offerMagic)
+	}
+	if err := c.fill(off + 1); err != nil {
+		return err
+	}
+	if c.rbuf[off] != headerVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidFrame, c.rbuf[off])
+	}
+	peerCodec, off, err := c.readName(off + 1)
+	if err != nil {
+		return err
+	}
+
+	var peerAccept []string
+	if !c.client {
+		if err := c.fill(off + 1); err != nil {
+			return err
+		}
+		count := int(c.rbuf[off])
+		off++
+		for i := 0; i < count; i++ {
+			var name string
+			if name, off, err = c.readName(off); err != nil {
+				return err
+			}
+			peerAccept = append(peerAccept, name)
+		}
+	}
+	c.rbuf = c.rbuf[:0]
+
+	if peerCodec != "" {
+		if c.accept != nil && !c.accept[peerCodec] {
+			err = fmt.Errorf("%w: %q", ErrUnsupportedCodec, peerCodec)
+		} else {
+			c.readCodec, err = lookup(peerCodec)
+		}
+		if err != nil {
+			if !c.client {
+				c.Reset(transport.CodeRefused)
+			}
+			return err
+		}
+	}
+
+	if !c.client {
+		if c.codec != nil && slices.Contains(peerAccept, c.codec.Name()) {
+			c.writeCodec = c.codec
+		}
+		c.pending = appendName([]byte{headerVersion}, c.writeCodec)
+	}
+	c.handshaken.Store(true)
+	return nil
+}
+
+// readName 读取 off 处一个字节长度前缀的名称，返回名称和之后的偏移
+func (c *Conn) readName(off int) (string, int, error) {
+	if err := c.fill(off + 1); err != nil {
+		return "", off, err
+	}
+	end := off + 1 + int(c.rbuf[off])
+	if err := c.fill(end); err != nil {
+		return "", off, err
+	}
+	return string(c.rbuf[off+1 : end]), end, nil
+}
+
+// readFrame 读取一个帧并解压到 c.out，调用时持有 c.rmu 且 c.out 已读完
+func (c *Conn) readFrame() error {
+	if err := c.fill(frameHeaderSize); err != nil {
+		return err
+	}
+	typ := c.rbuf[0]
+	rawLen := int(binary.BigEndian.Uint16(c.rbuf[1:3]))
+	dataLen := int(binary.BigEndian.Uint16(c.rbuf[3:5]))
+	switch {
+	case rawLen == 0 || rawLen > maxFrameSize:
+		return fmt.Errorf("%w: frame length %d", ErrInvalidFrame, rawLen)
+	case typ == frameRaw && dataLen != rawLen:
+		return fmt.Errorf("%w: raw frame length mismatch", ErrInvalidFrame)
+	case typ == frameCompressed && c.readCodec == nil:
+		return fmt.Errorf("%w: unexpected compressed frame", ErrInvalidFrame)
+	case typ != frameRaw && typ != frameCompressed:
+		return fmt.Errorf("%w: frame type %#x", ErrInvalidFrame, typ)
+	}
+
+	if err := c.fill(frameHeaderSize + dataLen); err != nil {
+		return err
+	}
+	data := c.rbuf[frameHeaderSize:]
+	c.rbuf = c.rbuf[:0]
+
+	if typ == frameRaw {
+		// c.out 读完之前不会再写入 c.rbuf
+		c.out = data
+		return nil
+	}
+	out, err := c.readCodec.Decompress(c.obuf[:0], data, rawLen)
+	if err != nil {
+		return err
+	}
+	if len(out) != rawLen {
+		return fmt.Errorf("%w: decompressed %d bytes, want %d", ErrInvalidFrame, len(out), rawLen)
+	}
+	c.obuf, c.out = out, out
+	return nil
+}
+
+// fill 从底层流读取直到 c.rbuf 至少有 n 个字节，出错时已读取的字节保留
+// 在帧或头部的边界上遇到 EOF 时返回 io.EOF，否则返回 io.ErrUnexpectedEOF
+func (c *Conn) fill(n int) error {
+	if cap(c.rbuf) < n {
+		c.rbuf = slices.Grow(c.rbuf, n-len(c.rbuf))
+	}
+	for len(c.rbuf) < n {
+		m, err := c.Conn.Read(c.rbuf[len(c.rbuf):n])
+		c.rbuf = c.rbuf[:len(c.rbuf)+m]
+		if err != nil && len(c.rbuf) < n {
+			if errors.Is(err, io.EOF) && len(c.rbuf) > 0 {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// appendFrame 追加 chunk 对应的帧，压缩后没有变小时使用原始数据
+func (c *Conn) appendFrame(b, chunk []byte) []byte {
+	start := len(b)
+	b = append(b, frameCompressed)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(chunk)))
+	b = append(b, 0, 0)
+
+	if c.writeCodec != nil && len(chunk) >= c.minSize {
+		out, err := c.writeCodec.Compress(b, chunk)
+		if dataLen := len(out) - len(b); err == nil && dataLen < len(chunk) {
+			binary.BigEndian.PutUint16(out[start+3:], uint16(dataLen))
+			return out
+		}
+	}
+
+	b[start] = frameRaw
+	binary.BigEndian.PutUint16(b[start+3:], uint16(len(chunk)))
+	return append(b, chunk...)
+}
+
+// appendName 追加一个字节长度前缀的算法名称，codec 为 nil 时长度为 0
+func appendName(b []byte, codec Codec) []byte {
+	if codec == nil {
+		return append(b, 0)
+	}
+	name := codec.Name()
+	b = append(b, byte(len(name)))
+	return append(b, name...)
+}
+
+// isTimeout 判断是否为截止时间到期的错误，此时流仍可以继续使用
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
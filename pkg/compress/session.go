@@ -0,0 +1,67 @@
+package compress
+
+import (
+	"net"
+
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// Session 压缩 MuxSession 上的所有流，对端也需要使用 Session 包装
+// 包装后的会话可以直接用于 gRPC、HTTP、端口转发等适配器；与带宽整形同时使用时，
+// 在限速会话外层包装压缩，限速按压缩后的字节数计算
+type Session struct {
+	transport.MuxSession
+	opts Options
+}
+
+// NewSession 创建压缩会话，opts.Codec 未注册时返回 ErrUnknownCodec
+func NewSession(sess transport.MuxSession, opts *Options) (*Session, error) {
+	s := &Session{MuxSession: sess}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.Codec != "" {
+		if _, err := lookup(s.opts.Codec); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Accept 接受来自对端的虚拟流并解压
+func (s *Session) Accept() (net.Conn, error) {
+	return s.AcceptStream()
+}
+
+// AcceptStream 接受来自对端的虚拟流并解压，压缩头部在第一次读写时读取
+func (s *Session) AcceptStream() (transport.Stream, error) {
+	stream, err := s.MuxSession.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	c, err := Server(stream, &s.opts)
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// OpenStream 发起一个新的虚拟流并发送压缩头部
+func (s *Session) OpenStream() (transport.Stream, error) {
+	stream, err := s.MuxSession.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	c, err := Client(stream, &s.opts)
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Unwrap 返回被压缩的底层会话
+func (s *Session) Unwrap() transport.MuxSession {
+	return s.MuxSession
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"github.com/funcx27/qymux/pkg/quic"
+	"github.com/funcx27/qymux/pkg/shaper"
 	"github.com/funcx27/qymux/pkg/tcp"
 	"github.com/funcx27/qymux/pkg/transport"
 	"github.com/funcx27/qymux/pkg/utils"
@@ -33,8 +34,8 @@ func NewDialer(config *transport.Config) *Dialer {
 	}
 
 	tlsConfig := extractTLSConfig(config.TLSConfig)
-	d.quicDialer = quic.NewDialer(tlsConfig)
-	d.tcpDialer = tcp.NewDialer(tlsConfig)
+	d.quicDialer = quic.NewDialerWithOptions(tlsConfig, config.Session)
+	d.tcpDialer = tcp.NewDialerWithOptions(tlsConfig, config.Session)
 
 	return d
 }
@@ -49,6 +50,15 @@ func extractTLSConfig(config interface{}) *tls.Config {
 
 // Dial 根据配置建立多路复用会话
 func (d *Dialer) Dial(target string) (transport.MuxSession, error) {
+	session, err := d.dial(target)
+	if err != nil {
+		return nil, err
+	}
+	return shapeSession(session, d.config.Session), nil
+}
+
+// dial 根据传输模式建立会话
+func (d *Dialer) dial(target string) (transport.MuxSession, error) {
 	switch d.config.Mode {
 	case transport.ModeQUIC:
 		return d.dialQUIC(target)
@@ -123,14 +133,14 @@ func NewListener(addr string, config *transport.Config) (*Listener, error) {
 	// 根据配置创建对应的监听器
 	switch config.Mode {
 	case transport.ModeQUIC:
-		ln, err := quic.Listen(addr, tlsConfig)
+		ln, err := quic.ListenWithOptions(addr, tlsConfig, config.Listener, config.Session)
 		if err != nil {
 			return nil, err
 		}
 		l.quicListener = ln
 
 	case transport.ModeTCP:
-		ln, err := tcp.Listen(addr, tlsConfig)
+		ln, err := tcp.ListenWithOptions(addr, tlsConfig, config.Listener, config.Session)
 		if err != nil {
 			return nil, err
 		}
@@ -139,14 +149,14 @@ func NewListener(addr string, config *transport.Config) (*Listener, error) {
 	default: // ModeAuto 或其他情况，同时支持两种模式
 		// 对于监听器，需要同时监听 UDP (QUIC) 和 TCP
 		// 这里我们创建两个监听器
-		quicLn, err := quic.Listen(addr, tlsConfig)
+		quicLn, err := quic.ListenWithOptions(addr, tlsConfig, config.Listener, config.Session)
 		if err != nil {
 			log.Printf("[Qymux] QUIC 监听失败: %v，仅使用 TCP", err)
 		} else {
 			l.quicListener = quicLn
 		}
 
-		tcpLn, err := tcp.Listen(addr, tlsConfig)
+		tcpLn, err := tcp.ListenWithOptions(addr, tlsConfig, config.Listener, config.Session)
 		if err != nil {
 			if l.quicListener != nil {
 				l.quicListener.Close()
@@ -171,6 +181,24 @@ func NewListener(addr string, config *transport.Config) (*Listener, error) {
 
 // Accept 接受新连接
 func (l *Listener) Accept() (transport.MuxSession, error) {
+	session, err := l.accept()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[Qymux] 接受来自 %s 的 %s 会话", session.RemoteAddr(), session.Protocol())
+	return shapeSession(session, l.config.Session), nil
+}
+
+// shapeSession 按会话配置中的带宽限制包装会话，未配置时原样返回
+func shapeSession(session transport.MuxSession, opts *transport.SessionOptions) transport.MuxSession {
+	if opts == nil || opts.Bandwidth == nil {
+		return session
+	}
+	return shaper.NewSession(session, *opts.Bandwidth)
+}
+
+// accept 根据监听模式接受新连接
+func (l *Listener) accept() (transport.MuxSession, error) {
 	if l.quicListener != nil && l.tcpListener != nil {
 		return l.acceptDualMode()
 	}
@@ -0,0 +1,261 @@
+package forward
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// udpConnBacklog 经由 SessionDialer 拨号的 UDP 连接最多缓存的未读包数，超出时丢弃
+const udpConnBacklog = 64
+
+// SessionDialer 请求对端拨号目标，返回的连接可用于任何接受 DialContext 钩子的库，
+// 例如 http.Transport、数据库驱动、ssh.Client 和 net.Resolver。对端需要运行 Server
+type SessionDialer struct {
+	sess    transport.MuxSession
+	timeout time.Duration
+	demux   *DatagramMux
+}
+
+// SessionDialerOptions 定义 SessionDialer 的可选配置
+type SessionDialerOptions struct {
+	// Timeout 等待对端拨号的超时时间，0 表示默认值（略长于对端的 DefaultDialTimeout）
+	Timeout time.Duration
+
+	// Datagrams 会话的数据报分发器，设置后 udp 连接的包优先以数据报传输，为 nil 时在流上传输
+	Datagrams *DatagramMux
+}
+
+// NewSessionDialer 创建经由 sess 拨号的 SessionDialer
+// timeout 为等待对端拨号的超时时间，0 表示默认值（略长于对端的 DefaultDialTimeout）
+func NewSessionDialer(sess transport.MuxSession, timeout time.Duration) *SessionDialer {
+	return NewSessionDialerWithOptions(sess, &SessionDialerOptions{Timeout: timeout})
+}
+
+// NewSessionDialerWithOptions 使用可选配置创建经由 sess 拨号的 SessionDialer
+func NewSessionDialerWithOptions(sess transport.MuxSession, opts *SessionDialerOptions) *SessionDialer {
+	d := &SessionDialer{sess: sess, timeout: DefaultDialTimeout + time.Second}
+	if opts != nil {
+		if opts.Timeout > 0 {
+			d.timeout = opts.Timeout
+		}
+		d.demux = opts.Datagrams
+	}
+	return d
+}
+
+// Dial 使用后台 context 拨号
+func (d *SessionDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext 请求对端拨号 network/addr，支持 tcp 和 udp 系列网络，与 net.Dialer.DialContext 签名相同
+// 失败时返回 *net.OpError，对端的拨号失败包装为 *DialError，可以用 errors.As 取出，
+// 也可以用 errors.Is 判断 syscall.ECONNREFUSED 等本地拨号的错误。
+// udp 连接的每次 Read 返回一个包，读取不及时的包被丢弃
+func (d *SessionDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	remote := dialAddr{network: network, addr: addr}
+	var conn net.Conn
+	var err error
+	switch network {
+	case "udp", "udp4", "udp6":
+		conn, err = dialUDPConn(ctx, d.sess, d.demux, remote)
+	default:
+		var stream transport.Stream
+		stream, err = DialContext(ctx, d.sess, network, addr)
+		if err == nil {
+			conn = &sessionConn{Stream: stream, remote: remote}
+		}
+	}
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: remote, Err: err}
+	}
+	return conn, nil
+}
+
+// dialAddr 是拨号目标的地址，由对端解析
+type dialAddr struct {
+	network string
+	addr    string
+}
+
+// Network 返回网络类型
+func (a dialAddr) Network() string {
+	return a.network
+}
+
+// String 返回拨号时的地址
+func (a dialAddr) String() string {
+	return a.addr
+}
+
+// sessionConn 是经由对端拨号的 TCP 连接，RemoteAddr 返回拨号目标
+type sessionConn struct {
+	transport.Stream
+	remote net.Addr
+}
+
+// RemoteAddr 返回拨号目标
+func (c *sessionConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// udpConn 是经由对端拨号的 UDP 连接
+type udpConn struct {
+	tunnel  *UDPTunnel
+	remote  net.Addr
+	packets chan []byte
+
+	readDeadline deadline
+}
+
+// dialUDPConn 请求对端拨号 UDP 目标，demux 可用时以数据报传输包
+func dialUDPConn(ctx context.Context, sess transport.MuxSession, demux *DatagramMux, remote dialAddr) (*udpConn, error) {
+	c := &udpConn{
+		remote:       remote,
+		packets:      make(chan []byte, udpConnBacklog),
+		readDeadline: newDeadline(),
+	}
+	tunnel, err := dialUDP(ctx, sess, demux, remote.addr, c.deliver)
+	if err != nil {
+		return nil, err
+	}
+	c.tunnel = tunnel
+	return c, nil
+}
+
+// deliver 缓存目标返回的包，缓存已满时丢弃
+func (c *udpConn) deliver(packet []byte) {
+	select {
+	case c.packets <- append([]byte(nil), packet...):
+	default:
+	}
+}
+
+// Read 读取一个包，b 不足以容纳时多余的部分被丢弃
+func (c *udpConn) Read(b []byte) (int, error) {
+	select {
+	case packet := <-c.packets:
+		return copy(b, packet), nil
+	default:
+	}
+
+	select {
+	case packet := <-c.packets:
+		return copy(b, packet), nil
+	case <-c.tunnel.Done():
+		return 0, net.ErrClosed
+	case <-c.readDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+// Write 发送一个包
+func (c *udpConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.tunnel.Done():
+		return 0, net.ErrClosed
+	default:
+	}
+	if err := c.tunnel.Send(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close 关闭隧道
+func (c *udpConn) Close() error {
+	return c.tunnel.Close()
+}
+
+// LocalAddr 返回承载隧道的流的本地地址
+func (c *udpConn) LocalAddr() net.Addr {
+	return c.tunnel.stream.LocalAddr()
+}
+
+// RemoteAddr 返回拨号目标
+func (c *udpConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// SetDeadline 设置读截止时间，写入不等待对端，写截止时间不生效
+func (c *udpConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline 设置读截止时间
+func (c *udpConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline 写截止时间不生效
+func (c *udpConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// deadline 是可以随时修改的截止时间，到期时关闭 wait 返回的 channel
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+// newDeadline 创建没有截止时间的 deadline
+func newDeadline() deadline {
+	return deadline{cancel: make(chan struct{})}
+}
+
+// set 设置截止时间，零值表示不设置
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // 等待定时器关闭 cancel
+	}
+	d.timer = nil
+
+	closed := isClosed(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() { close(cancel) })
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// wait 返回截止时间到达时关闭的 channel
+func (d *deadline) wait() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+// isClosed 判断 channel 是否已关闭
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
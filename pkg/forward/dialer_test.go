@@ -0,0 +1,108 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSessionDialer(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello " + r.Host))
+	}))
+	defer target.Close()
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	refused := closed.Addr().String()
+	closed.Close()
+
+	client, server := newSessionPair(t)
+	targets, err := NewPolicy(nil, []string{"10.0.0.0/8:*"})
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+	go NewServer(&ServerOptions{Targets: targets}).Serve(server)
+	d := NewSessionDialer(client, 0)
+
+	// http.Transport 经由对端拨号
+	c := &http.Client{Transport: &http.Transport{DialContext: d.DialContext}}
+	resp, err := c.Get(target.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hello "+target.Listener.Addr().String() {
+		t.Errorf("body = %q", body)
+	}
+
+	conn, err := d.Dial("tcp", target.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	if conn.RemoteAddr().String() != target.Listener.Addr().String() {
+		t.Errorf("RemoteAddr() = %v, want %s", conn.RemoteAddr(), target.Listener.Addr())
+	}
+	conn.Close()
+
+	tests := []struct {
+		addr   string
+		code   ReplyCode
+		target error
+	}{
+		{refused, ReplyRefused, syscall.ECONNREFUSED},
+		{"10.0.0.1:80", ReplyDenied, ErrDenied},
+	}
+	for _, tt := range tests {
+		_, err := d.DialContext(context.Background(), "tcp", tt.addr)
+		var opErr *net.OpError
+		var dialErr *DialError
+		if !errors.As(err, &opErr) || opErr.Op != "dial" || opErr.Addr.String() != tt.addr {
+			t.Errorf("DialContext(%s) error = %v, want *net.OpError", tt.addr, err)
+		}
+		if !errors.As(err, &dialErr) || dialErr.Code != tt.code || !errors.Is(err, tt.target) {
+			t.Errorf("DialContext(%s) error = %v, want code %s", tt.addr, err, tt.code)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.DialContext(ctx, "tcp", target.Listener.Addr().String()); !errors.Is(err, context.Canceled) {
+		t.Errorf("DialContext() with canceled context error = %v", err)
+	}
+}
+
+func TestSessionDialerUDP(t *testing.T) {
+	echo := startUDPEcho(t)
+	client, server := newSessionPair(t)
+	go NewServer(allowAllDatagrams(t, server)).Serve(server)
+
+	demux := NewDatagramMux(client)
+	defer demux.Close()
+	conn, err := NewSessionDialerWithOptions(client, &SessionDialerOptions{Datagrams: demux}).Dial("udp", echo)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	udpRoundTrip(t, conn, []byte("ping"))
+
+	// 没有数据时读取在截止时间返回
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 16)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read() error = %v, want os.ErrDeadlineExceeded", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	udpRoundTrip(t, conn, []byte("pong"))
+
+	conn.Close()
+	if _, err := conn.Read(make([]byte, 16)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Read() after Close error = %v, want net.ErrClosed", err)
+	}
+}
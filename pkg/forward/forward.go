@@ -0,0 +1,258 @@
+// Package forward 在会话上提供 SSH 风格的 TCP/UDP 端口转发
+//
+// 打开转发流的一端发送 Request，由对端的 Server 按地址策略拨号或监听，并返回结果；
+// 成功后流上双向转发原始字节。本地转发（-L）在本端监听，连接经由对端拨号到目标；
+// 远程转发（-R）请求对端监听，对端收到的连接经由本端拨号到目标。
+//
+// 转发使用整个会话，需要与 HTTP、gRPC 等适配器共享会话时，可以使用 service.Mux 为转发分配一个服务
+package forward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// DefaultDialTimeout 对端拨号目标和等待响应的默认超时时间
+const DefaultDialTimeout = 10 * time.Second
+
+// Dial 请求对端拨号 network/addr，成功后返回承载连接的流
+// 对端拒绝或拨号失败时返回 *DialError
+func Dial(sess transport.MuxSession, network, addr string) (transport.Stream, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultDialTimeout+time.Second)
+	defer cancel()
+	return DialContext(ctx, sess, network, addr)
+}
+
+// DialContext 与 Dial 相同，ctx 控制等待对端响应的时间，连接建立后 ctx 不再生效
+func DialContext(ctx context.Context, sess transport.MuxSession, network, addr string) (transport.Stream, error) {
+	stream, _, err := request(ctx, sess, &Request{Op: OpConnect, Network: network, Addr: addr})
+	return stream, err
+}
+
+// request 打开流并发送请求，等待对端响应，返回流和成功响应中的消息
+func request(ctx context.Context, sess transport.MuxSession, req *Request) (transport.Stream, string, error) {
+	stream, err := sess.OpenStream()
+	if err != nil {
+		return nil, "", fmt.Errorf("open stream failed: %w", err)
+	}
+
+	// 等待响应期间 ctx 结束时重置流，中断读取
+	stop := context.AfterFunc(ctx, func() {
+		stream.Reset(transport.CodeCancelled)
+	})
+	fail := func(err error) error {
+		stop()
+		stream.Close()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	if err := WriteRequest(stream, req); err != nil {
+		return nil, "", fail(fmt.Errorf("write forward request failed: %w", err))
+	}
+	code, message, err := readReply(stream)
+	if err != nil {
+		return nil, "", fail(fmt.Errorf("read forward reply failed: %w", err))
+	}
+	if code != ReplyOK {
+		return nil, "", fail(&DialError{Op: req.Op, Network: req.Network, Addr: req.Addr, Code: code, Message: message})
+	}
+	if !stop() {
+		// ctx 恰好在响应到达后结束，流已被重置
+		stream.Close()
+		return nil, "", ctx.Err()
+	}
+	return stream, message, nil
+}
+
+// Stats 记录单个转发的计数
+type Stats struct {
+	Connections     uint64 // 已接收的连接数
+	Active          int64  // 当前正在转发的连接数
+	DialErrors      uint64 // 拨号目标失败的连接数
+	BytesToTarget   uint64 // 从连接发起方发往目标的字节数
+	BytesFromTarget uint64 // 从目标返回连接发起方的字节数
+}
+
+// Forward 是一个正在运行的端口转发
+type Forward struct {
+	addr   string // 监听地址，远程转发时为对端实际监听的地址
+	target string
+
+	closer    io.Closer
+	closeOnce sync.Once
+	onClose   func()
+	done      chan struct{}
+
+	connections     atomic.Uint64
+	active          atomic.Int64
+	dialErrors      atomic.Uint64
+	bytesToTarget   atomic.Uint64
+	bytesFromTarget atomic.Uint64
+}
+
+// Local 在本地 listenAddr 上监听（-L），每个连接请求对端拨号 target 并转发
+// 对端需要运行 Server，target 受对端 ServerOptions.Targets 的限制
+func Local(sess transport.MuxSession, listenAddr, target string) (*Forward, error) {
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	f := newForward(ln.Addr().String(), target, ln)
+	log.Printf("[Qymux-Forward] 本地转发 %s -> %s (%s)", f.addr, target, sess.Protocol())
+
+	go func() {
+		defer f.Close()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					log.Printf("[Qymux-Forward] Accept connection failed: %v", err)
+				}
+				return
+			}
+			go f.handleLocal(sess, conn)
+		}
+	}()
+
+	if done := transport.SessionDone(sess); done != nil {
+		go func() {
+			select {
+			case <-done:
+				f.Close()
+			case <-f.done:
+			}
+		}()
+	}
+	return f, nil
+}
+
+// newForward 创建转发，closer 在 Close 时关闭
+func newForward(addr, target string, closer io.Closer) *Forward {
+	return &Forward{
+		addr:   addr,
+		target: target,
+		closer: closer,
+		done:   make(chan struct{}),
+	}
+}
+
+// handleLocal 请求对端拨号目标并转发本地连接
+func (f *Forward) handleLocal(sess transport.MuxSession, conn net.Conn) {
+	f.connections.Add(1)
+	stream, err := Dial(sess, "tcp", f.target)
+	if err != nil {
+		f.dialErrors.Add(1)
+		log.Printf("[Qymux-Forward] %s -> %s: %v", conn.RemoteAddr(), f.target, err)
+		conn.Close()
+		return
+	}
+	f.pipe(conn, stream)
+}
+
+// pipe 转发连接发起方 src 与目标 dst 之间的数据，并记录计数
+func (f *Forward) pipe(src, dst net.Conn) {
+	f.active.Add(1)
+	defer f.active.Add(-1)
+	pipe(src, dst, &f.bytesToTarget, &f.bytesFromTarget)
+}
+
+// Addr 返回监听地址，远程转发时为对端实际监听的地址
+func (f *Forward) Addr() string {
+	return f.addr
+}
+
+// Target 返回转发目标
+func (f *Forward) Target() string {
+	return f.target
+}
+
+// Stats 返回转发的计数
+func (f *Forward) Stats() Stats {
+	return Stats{
+		Connections:     f.connections.Load(),
+		Active:          f.active.Load(),
+		DialErrors:      f.dialErrors.Load(),
+		BytesToTarget:   f.bytesToTarget.Load(),
+		BytesFromTarget: f.bytesFromTarget.Load(),
+	}
+}
+
+// Done 返回转发停止时关闭的 channel
+func (f *Forward) Done() <-chan struct{} {
+	return f.done
+}
+
+// Close 停止监听，已建立的连接不受影响
+func (f *Forward) Close() error {
+	var err error
+	f.closeOnce.Do(func() {
+		err = f.closer.Close()
+		if f.onClose != nil {
+			f.onClose()
+		}
+		close(f.done)
+	})
+	return err
+}
+
+// Pipe 双向转发 a 与 b 之间的数据直到两个方向都结束，然后关闭两端
+// 一个方向读到 EOF 时半关闭另一端的写方向，出错时重置两端的流
+func Pipe(a, b net.Conn) {
+	pipe(a, b, nil, nil)
+}
+
+// pipe 双向转发 a 与 b 之间的数据，一个方向读到 EOF 时半关闭另一端的写方向，
+// 出错时重置两端；结束后关闭两端。aToB、bToA 为可选的字节计数
+func pipe(a, b net.Conn, aToB, bToA *atomic.Uint64) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go copyHalf(&wg, b, a, aToB)
+	go copyHalf(&wg, a, b, bToA)
+	wg.Wait()
+
+	a.Close()
+	b.Close()
+}
+
+// copyHalf 从 src 复制到 dst
+func copyHalf(wg *sync.WaitGroup, dst, src net.Conn, n *atomic.Uint64) {
+	defer wg.Done()
+
+	_, err := io.Copy(&countingWriter{w: dst, n: n}, src)
+	if err != nil {
+		transport.ResetStream(src, transport.CodeCancelled)
+		transport.ResetStream(dst, transport.CodeCancelled)
+		return
+	}
+	if transport.CloseWrite(dst) != nil {
+		dst.Close()
+	}
+}
+
+// countingWriter 统计写入的字节数
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Uint64
+}
+
+// Write 写入并计数
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if w.n != nil {
+		w.n.Add(uint64(n))
+	}
+	return n, err
+}
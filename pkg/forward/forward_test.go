@@ -0,0 +1,285 @@
+package forward
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/tcp"
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// newSessionPair 在本地回环上建立一对 TCP 会话
+func newSessionPair(t *testing.T) (client, server transport.MuxSession) {
+	t.Helper()
+
+	ln, err := tcp.Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	client, err = tcp.NewDialer(nil).Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	server, err = ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return client, server
+}
+
+// startEcho 启动回显服务
+func startEcho(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// roundTrip 连接 addr，发送 msg 并读取回显，然后半关闭等待对端关闭
+func roundTrip(t *testing.T, addr, msg string) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+	got, err := io.ReadAll(conn)
+	if err != nil || string(got) != msg {
+		t.Fatalf("echo = %q, %v, want %q", got, err, msg)
+	}
+}
+
+// waitStats 等待转发的计数满足条件
+func waitStats(t *testing.T, f *Forward, ok func(Stats) bool) Stats {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := f.Stats()
+		if ok(stats) || time.Now().After(deadline) {
+			return stats
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	p, err := NewPolicy(
+		[]string{"*.svc.local:443", "db.internal:5432", "10.0.0.0/8:8000-9000", "[fd00::/8]:*"},
+		[]string{"10.0.0.1:*", "admin.svc.local:*"},
+	)
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+
+	tests := []struct {
+		addr string
+		ok   bool
+	}{
+		{"api.svc.local:443", true},
+		{"API.svc.local:443", true},
+		{"api.svc.local:80", true}, // 端口不匹配，但存在 IP 规则，交给解析后的 IP 判断
+		{"admin.svc.local:443", false},
+		{"db.internal:5432", true},
+		{"10.1.2.3:8080", true},
+		{"10.1.2.3:22", false},
+		{"10.0.0.1:8080", false},
+		{"[fd00::1]:22", true},
+		{"192.168.1.1:8080", false},
+		{"other.host:8080", true}, // 主机名交给解析后的 IP 判断
+	}
+	for _, tt := range tests {
+		if err := p.Check(tt.addr); (err == nil) != tt.ok {
+			t.Errorf("Check(%q) = %v, want ok=%v", tt.addr, err, tt.ok)
+		}
+	}
+
+	resolved := []struct {
+		addr string
+		ip   string
+		ok   bool
+	}{
+		{"other.host:8080", "10.1.2.3", true},
+		{"other.host:8080", "192.168.1.1", false},
+		{"db.internal:5432", "192.168.1.1", true},
+		{"db.internal:5432", "10.0.0.1", false},
+	}
+	for _, tt := range resolved {
+		if err := p.CheckResolved(tt.addr, net.ParseIP(tt.ip)); (err == nil) != tt.ok {
+			t.Errorf("CheckResolved(%q, %s) = %v, want ok=%v", tt.addr, tt.ip, err, tt.ok)
+		}
+	}
+
+	if _, err := NewPolicy([]string{"host:99999"}, nil); err == nil {
+		t.Error("NewPolicy() with invalid port error = nil")
+	}
+}
+
+// allowAll 返回允许拨号所有目标的服务端配置
+func allowAll() *ServerOptions {
+	targets, _ := NewPolicy(nil, nil)
+	return &ServerOptions{Targets: targets}
+}
+
+// allowAllDatagrams 返回允许拨号所有目标、以 sess 的分发器接收数据报的服务端配置
+func allowAllDatagrams(t *testing.T, sess transport.MuxSession) *ServerOptions {
+	demux := NewDatagramMux(sess)
+	t.Cleanup(func() { demux.Close() })
+
+	opts := allowAll()
+	opts.Datagrams = func(transport.MuxSession) *DatagramMux { return demux }
+	return opts
+}
+
+func TestLocalForward(t *testing.T) {
+	echo := startEcho(t)
+	client, server := newSessionPair(t)
+	go NewServer(allowAll()).Serve(server)
+
+	f, err := Local(client, "127.0.0.1:0", echo)
+	if err != nil {
+		t.Fatalf("Local() error = %v", err)
+	}
+	defer f.Close()
+
+	roundTrip(t, f.Addr(), "hello")
+	roundTrip(t, f.Addr(), "world!")
+
+	stats := waitStats(t, f, func(s Stats) bool { return s.Active == 0 && s.BytesFromTarget == 11 })
+	if stats.Connections != 2 || stats.BytesToTarget != 11 || stats.BytesFromTarget != 11 || stats.Active != 0 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestDialError(t *testing.T) {
+	echo := startEcho(t)
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	refused := closed.Addr().String()
+	closed.Close()
+
+	targets, err := NewPolicy([]string{"127.0.0.1:*"}, []string{echo})
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+	client, server := newSessionPair(t)
+	go NewServer(&ServerOptions{Targets: targets}).Serve(server)
+
+	tests := []struct {
+		network, addr string
+		code          ReplyCode
+	}{
+		{"tcp", echo, ReplyDenied},
+		{"tcp", "10.0.0.1:80", ReplyDenied},
+		{"tcp", refused, ReplyRefused},
+		{"unix", "/tmp/x", ReplyUnsupported},
+	}
+	for _, tt := range tests {
+		_, err := Dial(client, tt.network, tt.addr)
+		var dialErr *DialError
+		if !errors.As(err, &dialErr) || dialErr.Code != tt.code {
+			t.Errorf("Dial(%s, %s) error = %v, want code %s", tt.network, tt.addr, err, tt.code)
+		}
+	}
+
+	// 策略外的 Local 转发：连接被关闭并计入 DialErrors
+	f, err := Local(client, "127.0.0.1:0", echo)
+	if err != nil {
+		t.Fatalf("Local() error = %v", err)
+	}
+	defer f.Close()
+	conn, err := net.Dial("tcp", f.Addr())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Read() on denied forward error = nil")
+	}
+	conn.Close()
+	if stats := waitStats(t, f, func(s Stats) bool { return s.DialErrors == 1 }); stats.DialErrors != 1 {
+		t.Errorf("Stats() = %+v, want 1 dial error", stats)
+	}
+
+	// 未配置 Targets 时拒绝所有目标
+	client, server = newSessionPair(t)
+	go NewServer(nil).Serve(server)
+	var dialErr *DialError
+	if _, err := Dial(client, "tcp", echo); !errors.As(err, &dialErr) || dialErr.Code != ReplyDenied {
+		t.Errorf("Dial() without Targets error = %v, want code %s", err, ReplyDenied)
+	}
+}
+
+func TestRemoteForward(t *testing.T) {
+	echo := startEcho(t)
+	client, server := newSessionPair(t)
+
+	listen, err := NewPolicy([]string{"127.0.0.1:*"}, nil)
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+	go NewServer(&ServerOptions{Listen: listen}).Serve(server)
+
+	local := NewServer(nil)
+	go local.Serve(client)
+
+	if _, err := local.Remote(client, "0.0.0.0:0", echo); err == nil {
+		t.Error("Remote() on denied address error = nil")
+	}
+
+	f, err := local.Remote(client, "127.0.0.1:0", echo)
+	if err != nil {
+		t.Fatalf("Remote() error = %v", err)
+	}
+	roundTrip(t, f.Addr(), "remote")
+
+	stats := waitStats(t, f, func(s Stats) bool { return s.Active == 0 && s.BytesFromTarget == 6 })
+	if stats.Connections != 1 || stats.BytesToTarget != 6 || stats.BytesFromTarget != 6 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+
+	// 关闭后对端停止监听
+	f.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", f.Addr())
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("remote listener still open after Close()")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,226 @@
+package forward
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ErrDenied 目标地址不被策略允许
+var ErrDenied = errors.New("forward: address denied by policy")
+
+// Policy 是按 "主机:端口" 匹配的地址访问策略，nil Policy 允许所有地址
+//
+// 规则的主机部分可以是：
+//   - "*"：任意主机
+//   - 主机名，例如 "db.internal"；"*.example.com" 匹配其任意子域名
+//   - IP 或 CIDR，例如 "10.0.0.1"、"10.0.0.0/8"、"[fd00::/8]"
+//
+// 端口部分可以是 "*"、单个端口或 "8000-9000" 形式的范围。
+//
+// 拒绝规则优先于允许规则，没有允许规则时允许所有未被拒绝的地址。
+// 目标为主机名时，解析后的 IP 同样需要通过 IP/CIDR 规则的检查，防止通过 DNS 绕过策略：
+// 被拒绝网段中的 IP 总是被拒绝；主机名未命中允许规则时，IP 需要命中允许规则
+type Policy struct {
+	allow []*rule
+	deny  []*rule
+}
+
+// rule 是解析后的策略规则
+type rule struct {
+	host   string     // 小写主机名、"*" 或 "*.suffix"，ipNet 不为 nil 时为空
+	ipNet  *net.IPNet // IP 或 CIDR 规则
+	portLo int
+	portHi int
+}
+
+// NewPolicy 创建地址访问策略
+func NewPolicy(allow, deny []string) (*Policy, error) {
+	p := &Policy{}
+	var err error
+	if p.allow, err = parseRules(allow); err != nil {
+		return nil, err
+	}
+	if p.deny, err = parseRules(deny); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// parseRules 解析规则列表
+func parseRules(specs []string) ([]*rule, error) {
+	rules := make([]*rule, 0, len(specs))
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		r, err := parseRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// parseRule 解析单条 "主机:端口" 规则
+func parseRule(spec string) (*rule, error) {
+	host, port, err := net.SplitHostPort(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy rule %q: %w", spec, err)
+	}
+
+	r := &rule{portLo: 0, portHi: 65535}
+	if port != "*" {
+		lo, hi, isRange := strings.Cut(port, "-")
+		if r.portLo, err = strconv.Atoi(lo); err != nil {
+			return nil, fmt.Errorf("invalid port in policy rule %q", spec)
+		}
+		r.portHi = r.portLo
+		if isRange {
+			if r.portHi, err = strconv.Atoi(hi); err != nil {
+				return nil, fmt.Errorf("invalid port in policy rule %q", spec)
+			}
+		}
+		if r.portLo < 0 || r.portHi > 65535 || r.portLo > r.portHi {
+			return nil, fmt.Errorf("invalid port range in policy rule %q", spec)
+		}
+	}
+
+	switch {
+	case strings.Contains(host, "/"):
+		if _, r.ipNet, err = net.ParseCIDR(host); err != nil {
+			return nil, fmt.Errorf("invalid CIDR in policy rule %q: %w", spec, err)
+		}
+	case net.ParseIP(host) != nil:
+		ip := net.ParseIP(host)
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		r.ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	case host == "":
+		return nil, fmt.Errorf("empty host in policy rule %q", spec)
+	default:
+		r.host = strings.ToLower(strings.TrimSuffix(host, "."))
+	}
+	return r, nil
+}
+
+// matchPort 判断端口是否在规则范围内
+func (r *rule) matchPort(port int) bool {
+	return port >= r.portLo && port <= r.portHi
+}
+
+// matchName 判断主机名是否匹配规则，IP 规则只匹配 IP
+func (r *rule) matchName(host string, port int) bool {
+	if !r.matchPort(port) {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return r.matchIP(ip, port)
+	}
+	switch {
+	case r.host == "*":
+		return true
+	case strings.HasPrefix(r.host, "*."):
+		return strings.HasSuffix(host, r.host[1:])
+	default:
+		return r.host != "" && r.host == host
+	}
+}
+
+// matchIP 判断 IP 是否匹配规则，主机名规则中只有 "*" 匹配 IP
+func (r *rule) matchIP(ip net.IP, port int) bool {
+	if !r.matchPort(port) {
+		return false
+	}
+	if r.ipNet != nil {
+		return r.ipNet.Contains(ip)
+	}
+	return r.host == "*"
+}
+
+// Check 检查地址 "host:port" 是否被允许，不允许时返回包装了 ErrDenied 的错误
+// 对于主机名，结果为初步判断，解析后的 IP 还需通过 CheckResolved 的检查
+func (p *Policy) Check(addr string) error {
+	if p == nil {
+		return nil
+	}
+	host, port, err := splitAddr(addr)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range p.deny {
+		if r.matchName(host, port) {
+			return fmt.Errorf("%w: %s", ErrDenied, addr)
+		}
+	}
+	if len(p.allow) == 0 {
+		return nil
+	}
+	for _, r := range p.allow {
+		if r.matchName(host, port) {
+			return nil
+		}
+	}
+
+	// 主机名未命中允许规则时，交给解析后的 IP 判断
+	if net.ParseIP(host) == nil && p.hasIPRules(p.allow) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrDenied, addr)
+}
+
+// CheckResolved 检查地址 addr 解析得到的 IP 是否被允许
+func (p *Policy) CheckResolved(addr string, ip net.IP) error {
+	if p == nil {
+		return nil
+	}
+	host, port, err := splitAddr(addr)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range p.deny {
+		if r.matchIP(ip, port) {
+			return fmt.Errorf("%w: %s (%s)", ErrDenied, addr, ip)
+		}
+	}
+	if len(p.allow) == 0 {
+		return nil
+	}
+	for _, r := range p.allow {
+		if r.matchName(host, port) || r.matchIP(ip, port) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s (%s)", ErrDenied, addr, ip)
+}
+
+// hasIPRules 判断规则中是否有 IP/CIDR 规则
+func (p *Policy) hasIPRules(rules []*rule) bool {
+	for _, r := range rules {
+		if r.ipNet != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// splitAddr 拆分 "host:port"，主机名转换为小写
+func splitAddr(addr string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	port, err := net.LookupPort("tcp", portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	return strings.ToLower(strings.TrimSuffix(host, ".")), port, nil
+}
@@ -0,0 +1,232 @@
+package forward
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// protocolVersion 当前请求格式版本
+const protocolVersion = 1
+
+// Op 请求类型
+type Op byte
+
+const (
+	// OpConnect 请求对端拨号 Addr，成功后流上双向转发原始字节
+	OpConnect Op = 0x1
+
+	// OpListen 请求对端在 Addr 上监听（远程转发），流保持打开作为控制流，关闭后对端停止监听
+	OpListen Op = 0x2
+
+	// OpForwarded 对端监听到的连接，Tag 为 OpListen 请求中的标识，Addr 为连接的来源地址
+	OpForwarded Op = 0x3
+)
+
+// ReplyCode 请求的处理结果
+type ReplyCode byte
+
+const (
+	// ReplyOK 请求成功
+	ReplyOK ReplyCode = 0x0
+
+	// ReplyDenied 目标地址不被策略允许
+	ReplyDenied ReplyCode = 0x1
+
+	// ReplyRefused 目标拒绝连接
+	ReplyRefused ReplyCode = 0x2
+
+	// ReplyUnreachable 目标不可达或无法解析
+	ReplyUnreachable ReplyCode = 0x3
+
+	// ReplyTimeout 拨号超时
+	ReplyTimeout ReplyCode = 0x4
+
+	// ReplyFailed 其他错误
+	ReplyFailed ReplyCode = 0x5
+
+	// ReplyUnsupported 不支持的请求类型或网络
+	ReplyUnsupported ReplyCode = 0x6
+)
+
+// String 返回结果码的名称
+func (c ReplyCode) String() string {
+	switch c {
+	case ReplyOK:
+		return "ok"
+	case ReplyDenied:
+		return "denied"
+	case ReplyRefused:
+		return "connection refused"
+	case ReplyUnreachable:
+		return "unreachable"
+	case ReplyTimeout:
+		return "timeout"
+	case ReplyFailed:
+		return "failed"
+	case ReplyUnsupported:
+		return "unsupported"
+	default:
+		return fmt.Sprintf("reply(0x%x)", byte(c))
+	}
+}
+
+// ErrInvalidRequest 请求或响应格式错误
+var ErrInvalidRequest = errors.New("forward: invalid request")
+
+// DialError 对端处理请求失败时返回的错误
+type DialError struct {
+	Op      Op
+	Network string
+	Addr    string
+	Code    ReplyCode
+	Message string // 对端的错误描述
+}
+
+// Error 实现 error 接口
+func (e *DialError) Error() string {
+	msg := fmt.Sprintf("forward: remote %s %s: %s", e.Network, e.Addr, e.Code)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Timeout 实现 net.Error
+func (e *DialError) Timeout() bool {
+	return e.Code == ReplyTimeout
+}
+
+// Temporary 实现 net.Error
+func (e *DialError) Temporary() bool {
+	return e.Code == ReplyTimeout || e.Code == ReplyRefused
+}
+
+// Is 使 errors.Is 可以按本地拨号的错误判断对端的拨号失败，
+// 例如 errors.Is(err, syscall.ECONNREFUSED)、errors.Is(err, os.ErrDeadlineExceeded)
+func (e *DialError) Is(target error) bool {
+	switch e.Code {
+	case ReplyDenied:
+		return target == ErrDenied
+	case ReplyRefused:
+		return target == syscall.ECONNREFUSED
+	case ReplyUnreachable:
+		return target == syscall.EHOSTUNREACH
+	case ReplyTimeout:
+		return target == os.ErrDeadlineExceeded
+	}
+	return false
+}
+
+// Request 流打开后发送的转发请求
+//
+// 编码格式：
+//
+//	version (1) | op (1) | networkLen (1) | network | addrLen (2) | addr | tagLen (1) | tag
+type Request struct {
+	Op      Op
+	Network string
+	Addr    string
+	Tag     string
+}
+
+// WriteRequest 将请求编码写入 w
+func WriteRequest(w io.Writer, req *Request) error {
+	if len(req.Network) > 255 || len(req.Addr) > 65535 || len(req.Tag) > 255 {
+		return fmt.Errorf("%w: field too long", ErrInvalidRequest)
+	}
+
+	buf := make([]byte, 0, 6+len(req.Network)+len(req.Addr)+len(req.Tag))
+	buf = append(buf, protocolVersion, byte(req.Op), byte(len(req.Network)))
+	buf = append(buf, req.Network...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(req.Addr)))
+	buf = append(buf, req.Addr...)
+	buf = append(buf, byte(len(req.Tag)))
+	buf = append(buf, req.Tag...)
+
+	// 一次性写入，避免请求被拆分到多个帧
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadRequest 从 r 中读取并解码请求，只读取请求本身的字节
+func ReadRequest(r io.Reader) (*Request, error) {
+	var fixed [3]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, err
+	}
+	if fixed[0] != protocolVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidRequest, fixed[0])
+	}
+
+	req := &Request{Op: Op(fixed[1])}
+	network, err := readBytes(r, int(fixed[2]))
+	if err != nil {
+		return nil, err
+	}
+	req.Network = string(network)
+
+	var al [2]byte
+	if _, err := io.ReadFull(r, al[:]); err != nil {
+		return nil, err
+	}
+	addr, err := readBytes(r, int(binary.BigEndian.Uint16(al[:])))
+	if err != nil {
+		return nil, err
+	}
+	req.Addr = string(addr)
+
+	var tl [1]byte
+	if _, err := io.ReadFull(r, tl[:]); err != nil {
+		return nil, err
+	}
+	tag, err := readBytes(r, int(tl[0]))
+	if err != nil {
+		return nil, err
+	}
+	req.Tag = string(tag)
+
+	return req, nil
+}
+
+// writeReply 写入响应
+//
+// 编码格式：
+//
+//	code (1) | messageLen (2) | message
+func writeReply(w io.Writer, code ReplyCode, message string) error {
+	if len(message) > 65535 {
+		message = message[:65535]
+	}
+	buf := make([]byte, 0, 3+len(message))
+	buf = append(buf, byte(code))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(message)))
+	buf = append(buf, message...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readReply 读取响应
+func readReply(r io.Reader) (ReplyCode, string, error) {
+	var fixed [3]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return 0, "", err
+	}
+	message, err := readBytes(r, int(binary.BigEndian.Uint16(fixed[1:])))
+	if err != nil {
+		return 0, "", err
+	}
+	return ReplyCode(fixed[0]), string(message), nil
+}
+
+// readBytes 读取恰好 n 个字节
+func readBytes(r io.Reader, n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
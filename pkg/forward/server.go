@@ -0,0 +1,312 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// requestTimeout 读取转发请求的超时时间
+const requestTimeout = 10 * time.Second
+
+// ServerOptions 定义转发服务端的可选配置
+type ServerOptions struct {
+	// Targets 允许对端请求拨号的目标地址，为 nil 时拒绝所有连接请求
+	// 需要允许所有目标时显式传入 NewPolicy(nil, nil)
+	Targets *Policy
+
+	// Listen 允许对端请求监听的地址（远程转发），为 nil 时拒绝所有监听请求
+	Listen *Policy
+
+	// DialTimeout 拨号目标的超时时间，0 表示默认值 (10s)
+	DialTimeout time.Duration
+
+	// Datagrams 返回会话的数据报分发器，Serve 开始时调用，分发器由调用方创建和关闭
+	// 为 nil 或返回 nil 时 UDP 包在流上传输，Serve 不接收会话的数据报；
+	// 同一会话上的 LocalUDP、SessionDialer 应与 Serve 共享同一个分发器
+	Datagrams func(sess transport.MuxSession) *DatagramMux
+}
+
+// Server 处理对端发来的转发请求：按 Targets 策略拨号目标，按 Listen 策略为对端监听端口，
+// 同时负责本端发起的远程转发（Remote）中对端转回的连接
+type Server struct {
+	targets     *Policy
+	listen      *Policy
+	dialTimeout time.Duration
+	datagrams   func(transport.MuxSession) *DatagramMux
+
+	mu      sync.Mutex
+	remotes map[string]*Forward // 本端发起的远程转发，按请求标识索引
+	nextTag atomic.Uint64
+}
+
+// NewServer 创建转发服务端
+func NewServer(opts *ServerOptions) *Server {
+	s := &Server{
+		dialTimeout: DefaultDialTimeout,
+		remotes:     make(map[string]*Forward),
+	}
+	if opts != nil {
+		s.targets = opts.Targets
+		s.listen = opts.Listen
+		s.datagrams = opts.Datagrams
+		if opts.DialTimeout > 0 {
+			s.dialTimeout = opts.DialTimeout
+		}
+	}
+	return s
+}
+
+// Serve 接收会话上的转发流并处理，会话关闭时返回
+// 只有 ServerOptions.Datagrams 为会话返回分发器时，对端请求的 UDP 转发才以数据报传输
+func (s *Server) Serve(sess transport.MuxSession) error {
+	var demux *DatagramMux
+	if s.datagrams != nil {
+		demux = s.datagrams(sess)
+	}
+
+	for {
+		stream, err := sess.AcceptStream()
+		if err != nil {
+			return err
+		}
+		go s.serveStream(sess, demux, stream)
+	}
+}
+
+// serveStream 读取请求并按类型处理
+func (s *Server) serveStream(sess transport.MuxSession, demux *DatagramMux, stream transport.Stream) {
+	stream.SetReadDeadline(time.Now().Add(requestTimeout))
+	req, err := ReadRequest(stream)
+	stream.SetReadDeadline(time.Time{})
+	if err != nil {
+		log.Printf("[Qymux-Forward] Read forward request failed: %v", err)
+		stream.Close()
+		return
+	}
+
+	switch req.Op {
+	case OpConnect:
+		s.handleConnect(demux, stream, req)
+	case OpListen:
+		s.handleListen(sess, stream, req)
+	case OpForwarded:
+		s.handleForwarded(stream, req)
+	default:
+		reject(stream, ReplyUnsupported, fmt.Sprintf("unsupported op 0x%x", byte(req.Op)))
+	}
+}
+
+// handleConnect 按策略拨号目标并转发
+func (s *Server) handleConnect(demux *DatagramMux, stream transport.Stream, req *Request) {
+	udp := false
+	switch req.Network {
+	case "tcp", "tcp4", "tcp6":
+	case "udp", "udp4", "udp6":
+		udp = true
+	default:
+		reject(stream, ReplyUnsupported, "unsupported network "+req.Network)
+		return
+	}
+	if s.targets == nil {
+		reject(stream, ReplyDenied, "forwarding disabled")
+		return
+	}
+	if err := s.targets.Check(req.Addr); err != nil {
+		log.Printf("[Qymux-Forward] Connect %s/%s: %v", req.Network, req.Addr, err)
+		reject(stream, replyCode(err), err.Error())
+		return
+	}
+	if udp {
+		s.handleUDP(demux, stream, req)
+		return
+	}
+
+	conn, err := s.dial(req.Network, req.Addr, s.targets)
+	if err != nil {
+		log.Printf("[Qymux-Forward] Connect %s: %v", req.Addr, err)
+		reject(stream, replyCode(err), err.Error())
+		return
+	}
+	if err := writeReply(stream, ReplyOK, ""); err != nil {
+		conn.Close()
+		stream.Close()
+		return
+	}
+	pipe(stream, conn, nil, nil)
+}
+
+// dial 拨号目标，policy 不为 nil 时在连接前检查解析后的 IP
+func (s *Server) dial(network, addr string, policy *Policy) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: s.dialTimeout}
+	if policy != nil {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			return policy.CheckResolved(addr, net.ParseIP(host))
+		}
+	}
+	return dialer.DialContext(context.Background(), network, addr)
+}
+
+// handleListen 按策略为对端监听端口，控制流关闭时停止监听
+func (s *Server) handleListen(sess transport.MuxSession, stream transport.Stream, req *Request) {
+	if req.Network != "tcp" && req.Network != "tcp4" && req.Network != "tcp6" {
+		reject(stream, ReplyUnsupported, "unsupported network "+req.Network)
+		return
+	}
+	if s.listen == nil {
+		reject(stream, ReplyDenied, "remote forwarding disabled")
+		return
+	}
+	if err := s.listen.Check(req.Addr); err != nil {
+		log.Printf("[Qymux-Forward] Listen %s: %v", req.Addr, err)
+		reject(stream, ReplyDenied, err.Error())
+		return
+	}
+
+	ln, err := net.Listen(req.Network, req.Addr)
+	if err != nil {
+		log.Printf("[Qymux-Forward] Listen %s: %v", req.Addr, err)
+		reject(stream, ReplyFailed, err.Error())
+		return
+	}
+	if err := writeReply(stream, ReplyOK, ln.Addr().String()); err != nil {
+		ln.Close()
+		stream.Close()
+		return
+	}
+	log.Printf("[Qymux-Forward] 远程转发监听 %s (%s)", ln.Addr(), sess.Protocol())
+
+	// 控制流上没有后续数据，读取返回即表示对端停止转发或会话关闭
+	go func() {
+		var buf [1]byte
+		stream.Read(buf[:])
+		ln.Close()
+		stream.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("[Qymux-Forward] Accept connection failed: %v", err)
+			}
+			stream.Close()
+			return
+		}
+		go func() {
+			forwarded, _, err := request(context.Background(), sess, &Request{
+				Op:      OpForwarded,
+				Network: req.Network,
+				Addr:    conn.RemoteAddr().String(),
+				Tag:     req.Tag,
+			})
+			if err != nil {
+				log.Printf("[Qymux-Forward] Forward connection from %s failed: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			pipe(conn, forwarded, nil, nil)
+		}()
+	}
+}
+
+// handleForwarded 将对端转回的连接拨号到本端远程转发的目标
+func (s *Server) handleForwarded(stream transport.Stream, req *Request) {
+	s.mu.Lock()
+	f, ok := s.remotes[req.Tag]
+	s.mu.Unlock()
+	if !ok {
+		reject(stream, ReplyDenied, "unknown remote forward")
+		return
+	}
+
+	f.connections.Add(1)
+	conn, err := s.dial("tcp", f.target, nil)
+	if err != nil {
+		f.dialErrors.Add(1)
+		log.Printf("[Qymux-Forward] %s -> %s: %v", req.Addr, f.target, err)
+		reject(stream, replyCode(err), err.Error())
+		return
+	}
+	if err := writeReply(stream, ReplyOK, ""); err != nil {
+		conn.Close()
+		stream.Close()
+		return
+	}
+	f.pipe(stream, conn)
+}
+
+// Remote 请求对端在 remoteAddr 上监听（-R），对端收到的连接由本端拨号到 target 并转发
+// 本端需要在同一个会话上运行 Serve 以接收对端转回的连接；对端的 ServerOptions.Listen 需要允许 remoteAddr
+// 返回的 Forward 的 Addr 为对端实际监听的地址
+func (s *Server) Remote(sess transport.MuxSession, remoteAddr, target string) (*Forward, error) {
+	tag := strconv.FormatUint(s.nextTag.Add(1), 10)
+	f := newForward("", target, nil)
+
+	s.mu.Lock()
+	s.remotes[tag] = f
+	s.mu.Unlock()
+	unregister := func() {
+		s.mu.Lock()
+		delete(s.remotes, tag)
+		s.mu.Unlock()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultDialTimeout)
+	defer cancel()
+	stream, addr, err := request(ctx, sess, &Request{Op: OpListen, Network: "tcp", Addr: remoteAddr, Tag: tag})
+	if err != nil {
+		unregister()
+		return nil, err
+	}
+
+	f.addr = addr
+	f.closer = stream
+	f.onClose = unregister
+	log.Printf("[Qymux-Forward] 远程转发 %s -> %s (%s)", addr, target, sess.Protocol())
+
+	// 对端停止监听或会话关闭时，控制流读取返回
+	go func() {
+		var buf [1]byte
+		stream.Read(buf[:])
+		f.Close()
+	}()
+	return f, nil
+}
+
+// reject 返回失败响应并关闭流
+func reject(stream transport.Stream, code ReplyCode, message string) {
+	writeReply(stream, code, message)
+	stream.Close()
+}
+
+// replyCode 将拨号错误映射为响应码
+func replyCode(err error) ReplyCode {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, ErrDenied):
+		return ReplyDenied
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ReplyRefused
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ReplyTimeout
+	case errors.As(err, &dnsErr), errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return ReplyUnreachable
+	default:
+		return ReplyFailed
+	}
+}
@@ -0,0 +1,495 @@
+package forward
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+const (
+	// DefaultUDPIdleTimeout UDP 流在两个方向都没有数据后被回收的默认时间
+	DefaultUDPIdleTimeout = time.Minute
+
+	// maxUDPPacketSize 单个 UDP 包的最大长度
+	maxUDPPacketSize = 65535
+
+	// udpFlowBacklog 每个流在建立期间或发送过慢时最多缓存的包数，超出时丢弃
+	udpFlowBacklog = 64
+
+	// udpModeDatagram 请求的 Tag 与成功响应的消息，表示使用会话的数据报传输包
+	// 请求中为本端可以接收数据报，响应中为对端也可以接收；响应的消息为空时在流上传输
+	udpModeDatagram = "datagram"
+)
+
+// UDPOptions 定义 UDP 转发的可选配置
+type UDPOptions struct {
+	// IdleTimeout 来源地址在两个方向都没有数据后回收其流的时间，0 表示默认值 (1m)
+	IdleTimeout time.Duration
+
+	// Datagrams 会话的数据报分发器，设置后包优先以数据报传输，为 nil 时在流上传输
+	// 同一会话上的所有转发应共享一个分发器
+	Datagrams *DatagramMux
+}
+
+// LocalUDP 在本地 listenAddr 上监听 UDP（-L），每个来源地址对应一个经由对端转发到 target 的流
+// 包以长度前缀帧在流上发送；通过 UDPOptions.Datagrams 指定分发器且会话支持数据报时，包优先以数据报发送，
+// 超过数据报大小时仍在流上发送。Stats 中的 Connections 和 Active 为流的数量
+func LocalUDP(sess transport.MuxSession, listenAddr, target string) (*Forward, error) {
+	return LocalUDPWithOptions(sess, listenAddr, target, nil)
+}
+
+// LocalUDPWithOptions 使用可选配置在本地监听 UDP
+func LocalUDPWithOptions(sess transport.MuxSession, listenAddr, target string, opts *UDPOptions) (*Forward, error) {
+	pc, err := net.ListenPacket("udp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &udpForward{
+		sess:        sess,
+		pc:          pc,
+		target:      target,
+		idleTimeout: DefaultUDPIdleTimeout,
+		flows:       make(map[string]*udpFlow),
+	}
+	if opts != nil {
+		if opts.IdleTimeout > 0 {
+			u.idleTimeout = opts.IdleTimeout
+		}
+		u.demux = opts.Datagrams
+	}
+	u.Forward = newForward(pc.LocalAddr().String(), target, pc)
+	u.onClose = u.closeFlows
+
+	mode := "stream"
+	if u.demux.enabled() {
+		mode = "datagram"
+	}
+	log.Printf("[Qymux-Forward] 本地 UDP 转发 %s -> %s (%s, %s)", u.addr, target, sess.Protocol(), mode)
+
+	go u.readLoop()
+	if done := transport.SessionDone(sess); done != nil {
+		go func() {
+			select {
+			case <-done:
+				u.Close()
+			case <-u.done:
+			}
+		}()
+	}
+	return u.Forward, nil
+}
+
+// udpForward 是本地 UDP 转发
+type udpForward struct {
+	*Forward
+	sess        transport.MuxSession
+	demux       *DatagramMux
+	pc          net.PacketConn
+	target      string
+	idleTimeout time.Duration
+
+	mu    sync.Mutex
+	flows map[string]*udpFlow // 按来源地址索引
+}
+
+// readLoop 读取本地的包并分发到来源地址对应的流
+func (u *udpForward) readLoop() {
+	defer u.Close()
+
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, addr, err := u.pc.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("[Qymux-Forward] Read UDP packet failed: %v", err)
+			}
+			return
+		}
+
+		u.mu.Lock()
+		flow, ok := u.flows[addr.String()]
+		if !ok {
+			flow = &udpFlow{
+				u:      u,
+				client: addr,
+				out:    make(chan []byte, udpFlowBacklog),
+				done:   make(chan struct{}),
+			}
+			flow.idle = time.AfterFunc(u.idleTimeout, flow.close)
+			u.flows[addr.String()] = flow
+			u.connections.Add(1)
+			go flow.run()
+		}
+		u.mu.Unlock()
+
+		select {
+		case flow.out <- append([]byte(nil), buf[:n]...):
+		default:
+			// 流尚未建立或发送过慢，按 UDP 语义丢弃
+		}
+	}
+}
+
+// closeFlows 关闭所有流
+func (u *udpForward) closeFlows() {
+	u.mu.Lock()
+	flows := make([]*udpFlow, 0, len(u.flows))
+	for _, flow := range u.flows {
+		flows = append(flows, flow)
+	}
+	u.mu.Unlock()
+
+	for _, flow := range flows {
+		flow.close()
+	}
+}
+
+// udpFlow 是一个来源地址到目标的流
+type udpFlow struct {
+	u      *udpForward
+	client net.Addr
+	out    chan []byte
+	idle   *time.Timer
+
+	mu        sync.Mutex
+	tunnel    *UDPTunnel
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// run 请求对端拨号目标，然后发送缓存的包
+func (f *udpFlow) run() {
+	u := f.u
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultDialTimeout+time.Second)
+	tunnel, err := dialUDP(ctx, u.sess, u.demux, u.target, f.deliver)
+	cancel()
+	if err != nil {
+		u.dialErrors.Add(1)
+		log.Printf("[Qymux-Forward] %s -> %s: %v", f.client, u.target, err)
+		f.close()
+		return
+	}
+
+	f.mu.Lock()
+	select {
+	case <-f.done:
+		f.mu.Unlock()
+		tunnel.Close()
+		return
+	default:
+		f.tunnel = tunnel
+	}
+	f.mu.Unlock()
+
+	u.active.Add(1)
+	defer u.active.Add(-1)
+
+	for {
+		select {
+		case packet := <-f.out:
+			f.idle.Reset(u.idleTimeout)
+			if err := tunnel.Send(packet); err != nil {
+				f.close()
+				return
+			}
+			u.bytesToTarget.Add(uint64(len(packet)))
+		case <-tunnel.Done():
+			f.close()
+			return
+		case <-f.done:
+			return
+		}
+	}
+}
+
+// deliver 将目标返回的包发送给来源地址
+func (f *udpFlow) deliver(packet []byte) {
+	f.idle.Reset(f.u.idleTimeout)
+	if _, err := f.u.pc.WriteTo(packet, f.client); err == nil {
+		f.u.bytesFromTarget.Add(uint64(len(packet)))
+	}
+}
+
+// close 关闭流并注销，可重复调用
+func (f *udpFlow) close() {
+	f.closeOnce.Do(func() {
+		f.mu.Lock()
+		close(f.done)
+		tunnel := f.tunnel
+		f.mu.Unlock()
+
+		f.idle.Stop()
+		if tunnel != nil {
+			tunnel.Close()
+		}
+
+		f.u.mu.Lock()
+		if f.u.flows[f.client.String()] == f {
+			delete(f.u.flows, f.client.String())
+		}
+		f.u.mu.Unlock()
+	})
+}
+
+// handleUDP 按策略拨号 UDP 目标，在流与目标之间转发包，流关闭时结束
+func (s *Server) handleUDP(demux *DatagramMux, stream transport.Stream, req *Request) {
+	conn, err := s.dial(req.Network, req.Addr, s.targets)
+	if err != nil {
+		log.Printf("[Qymux-Forward] Connect %s/%s: %v", req.Network, req.Addr, err)
+		reject(stream, replyCode(err), err.Error())
+		return
+	}
+	defer conn.Close()
+
+	// 在返回响应之前注册数据报，避免丢失对端随后发送的包；
+	// 响应的消息为本端接受的传输方式，对端据此决定是否发送数据报
+	tunnel := newUDPTunnel(stream, demux, req.Tag == udpModeDatagram, func(packet []byte) {
+		conn.Write(packet)
+	})
+	defer tunnel.Close()
+	mode := ""
+	if tunnel.dg != nil {
+		mode = udpModeDatagram
+	}
+	if err := writeReply(stream, ReplyOK, mode); err != nil {
+		return
+	}
+
+	go func() {
+		buf := make([]byte, maxUDPPacketSize)
+		for {
+			n, err := conn.Read(buf)
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				// 目标端口暂时不可达（ICMP），不影响后续的包
+				continue
+			}
+			if err != nil || tunnel.Send(buf[:n]) != nil {
+				tunnel.Close()
+				return
+			}
+		}
+	}()
+
+	tunnel.receive()
+}
+
+// DialUDP 请求对端拨号 UDP 目标 addr，成功后返回承载包的隧道，目标返回的包交给 deliver
+// deliver 在接收 goroutine 中依次调用，不应阻塞；对端拒绝或拨号失败时返回 *DialError。
+// 包以长度前缀帧在流上传输，需要使用会话的数据报时通过 DatagramMux.DialUDP 拨号
+func DialUDP(ctx context.Context, sess transport.MuxSession, addr string, deliver func(packet []byte)) (*UDPTunnel, error) {
+	return dialUDP(ctx, sess, nil, addr, deliver)
+}
+
+// dialUDP 请求对端拨号 UDP 目标，demux 可用时请求以数据报传输包
+// 只有对端在响应中确认时才使用数据报，对端无法接收数据报时包在流上传输
+func dialUDP(ctx context.Context, sess transport.MuxSession, demux *DatagramMux, addr string, deliver func(packet []byte)) (*UDPTunnel, error) {
+	tag := ""
+	if demux.enabled() {
+		tag = udpModeDatagram
+	}
+	stream, mode, err := request(ctx, sess, &Request{Op: OpConnect, Network: "udp", Addr: addr, Tag: tag})
+	if err != nil {
+		return nil, err
+	}
+
+	t := newUDPTunnel(stream, demux, tag == udpModeDatagram && mode == udpModeDatagram, deliver)
+	go func() {
+		t.receive()
+		t.Close()
+	}()
+	return t, nil
+}
+
+// UDPTunnel 在流上承载一个 UDP 目标的包
+// 启用数据报时包优先以 "流 ID (uvarint) | 数据" 的数据报发送，其余情况以 "长度 (2) | 数据" 的帧写入流
+type UDPTunnel struct {
+	stream  transport.Stream
+	deliver func([]byte)
+	dg      transport.DatagramSession
+	demux   *DatagramMux
+	prefix  []byte
+	writeMu sync.Mutex
+	once    sync.Once
+	done    chan struct{}
+}
+
+// newUDPTunnel 创建 UDP 隧道，对端的包交给 deliver
+// datagram 为 true 且 demux 可用时使用数据报，并立即开始接收发往该流的数据报
+func newUDPTunnel(stream transport.Stream, demux *DatagramMux, datagram bool, deliver func([]byte)) *UDPTunnel {
+	t := &UDPTunnel{stream: stream, deliver: deliver, done: make(chan struct{})}
+	if datagram && demux.enabled() && demux.add(stream.ID(), deliver) {
+		t.dg = demux.dg
+		t.demux = demux
+		t.prefix = binary.AppendUvarint(nil, stream.ID())
+	}
+	return t
+}
+
+// Send 发送一个包，包可能在传输中丢失
+func (t *UDPTunnel) Send(packet []byte) error {
+	if t.dg != nil && t.demux.enabled() && t.dg.SendDatagram(append(t.prefix[:len(t.prefix):len(t.prefix)], packet...)) == nil {
+		return nil
+	}
+
+	// 超过数据报大小的包在流上发送
+	buf := make([]byte, 2+len(packet))
+	binary.BigEndian.PutUint16(buf, uint16(len(packet)))
+	copy(buf[2:], packet)
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	_, err := t.stream.Write(buf)
+	return err
+}
+
+// receive 接收流上的包并交给 deliver，流关闭或出错时返回
+func (t *UDPTunnel) receive() {
+	var size [2]byte
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		if _, err := io.ReadFull(t.stream, size[:]); err != nil {
+			return
+		}
+		packet := buf[:binary.BigEndian.Uint16(size[:])]
+		if _, err := io.ReadFull(t.stream, packet); err != nil {
+			return
+		}
+		t.deliver(packet)
+	}
+}
+
+// Done 返回隧道关闭时关闭的 channel，对端关闭流或会话断开时隧道随之关闭
+func (t *UDPTunnel) Done() <-chan struct{} {
+	return t.done
+}
+
+// Close 注销数据报并关闭流，可重复调用
+func (t *UDPTunnel) Close() error {
+	var err error
+	t.once.Do(func() {
+		if t.demux != nil {
+			t.demux.remove(t.stream.ID())
+		}
+		err = t.stream.Close()
+		close(t.done)
+	})
+	return err
+}
+
+// DatagramMux 按流 ID 将会话收到的数据报分发给 UDP 隧道，每个会话由使用方创建一个，
+// 并通过 UDPOptions、SessionDialerOptions 和 ServerOptions 共享给该会话上的所有转发。
+// 创建后它独占调用会话的 ReceiveDatagram，应用不应再直接接收该会话的数据报；
+// 会话关闭（transport.SessionDone）或调用 Close 后停止接收，之后建立的隧道在流上传输包
+type DatagramMux struct {
+	sess   transport.MuxSession
+	dg     transport.DatagramSession // 会话不支持数据报时为 nil
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	handlers map[uint64]func([]byte)
+	closed   bool
+}
+
+// NewDatagramMux 创建会话的数据报分发器，会话支持数据报时立即开始接收
+func NewDatagramMux(sess transport.MuxSession) *DatagramMux {
+	m := &DatagramMux{sess: sess, handlers: make(map[uint64]func([]byte))}
+	dg, ok := transport.Datagrams(sess)
+	if !ok {
+		return m
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.dg = dg
+	m.cancel = cancel
+	go m.receiveLoop(ctx)
+	if done := transport.SessionDone(sess); done != nil {
+		go func() {
+			select {
+			case <-done:
+				m.Close()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return m
+}
+
+// DialUDP 与 DialUDP 函数相同，会话支持数据报时包优先以数据报传输
+func (m *DatagramMux) DialUDP(ctx context.Context, addr string, deliver func(packet []byte)) (*UDPTunnel, error) {
+	return dialUDP(ctx, m.sess, m, addr, deliver)
+}
+
+// Close 停止接收数据报并注销所有隧道，已建立的隧道之后只能在流上发送，可重复调用
+func (m *DatagramMux) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	clear(m.handlers)
+	if m.cancel != nil {
+		m.cancel()
+	}
+	return nil
+}
+
+// enabled 返回是否可以使用数据报
+func (m *DatagramMux) enabled() bool {
+	if m == nil || m.dg == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !m.closed
+}
+
+// receiveLoop 接收数据报并分发，会话关闭或 Close 后退出
+func (m *DatagramMux) receiveLoop(ctx context.Context) {
+	defer m.Close()
+
+	for {
+		b, err := m.dg.ReceiveDatagram(ctx)
+		if err != nil {
+			return
+		}
+		id, n := binary.Uvarint(b)
+		if n <= 0 {
+			continue
+		}
+
+		m.mu.Lock()
+		deliver := m.handlers[id]
+		m.mu.Unlock()
+		if deliver != nil {
+			deliver(b[n:])
+		}
+	}
+}
+
+// add 注册流 ID 的处理函数，分发器已关闭时返回 false
+func (m *DatagramMux) add(id uint64, deliver func([]byte)) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return false
+	}
+	m.handlers[id] = deliver
+	return true
+}
+
+// remove 注销流 ID
+func (m *DatagramMux) remove(id uint64) {
+	m.mu.Lock()
+	delete(m.handlers, id)
+	m.mu.Unlock()
+}
@@ -0,0 +1,241 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/quic"
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// newQUICSessionPair 在本地回环上建立一对 QUIC 会话
+func newQUICSessionPair(t *testing.T) (client, server transport.MuxSession) {
+	t.Helper()
+
+	ln, err := quic.Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	client, err = quic.NewDialer(nil).Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	server, err = ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return client, server
+}
+
+// startUDPEcho 启动 UDP 回显服务
+func startUDPEcho(t *testing.T) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		buf := make([]byte, maxUDPPacketSize)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], addr)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+// udpRoundTrip 在 conn 上发送 packet 并等待回显，UDP 可能丢包，因此会重试
+func udpRoundTrip(t *testing.T, conn net.Conn, packet []byte) {
+	t.Helper()
+
+	buf := make([]byte, maxUDPPacketSize)
+	for i := 0; i < 20; i++ {
+		conn.Write(packet)
+		conn.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+		n, err := conn.Read(buf)
+		if err == nil {
+			if !bytes.Equal(buf[:n], packet) {
+				t.Fatalf("echo = %d bytes, want %d bytes", n, len(packet))
+			}
+			return
+		}
+	}
+	t.Fatalf("no echo for %d byte packet", len(packet))
+}
+
+func TestLocalUDP(t *testing.T) {
+	pairs := map[string]func(*testing.T) (transport.MuxSession, transport.MuxSession){
+		"tcp":  newSessionPair,
+		"quic": newQUICSessionPair,
+	}
+	for name, newPair := range pairs {
+		t.Run(name, func(t *testing.T) {
+			echo := startUDPEcho(t)
+			client, server := newPair(t)
+			go NewServer(allowAllDatagrams(t, server)).Serve(server)
+
+			demux := NewDatagramMux(client)
+			defer demux.Close()
+			f, err := LocalUDPWithOptions(client, "127.0.0.1:0", echo, &UDPOptions{
+				IdleTimeout: 300 * time.Millisecond,
+				Datagrams:   demux,
+			})
+			if err != nil {
+				t.Fatalf("LocalUDPWithOptions() error = %v", err)
+			}
+			defer f.Close()
+
+			conn, err := net.Dial("udp", f.Addr())
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			defer conn.Close()
+
+			udpRoundTrip(t, conn, []byte("ping"))
+			// QUIC 上超过数据报大小的包在流上发送
+			udpRoundTrip(t, conn, bytes.Repeat([]byte("x"), 8000))
+
+			other, err := net.Dial("udp", f.Addr())
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			defer other.Close()
+			udpRoundTrip(t, other, []byte("pong"))
+
+			if stats := f.Stats(); stats.Connections != 2 || stats.Active != 2 {
+				t.Errorf("Stats() = %+v, want 2 active flows", stats)
+			}
+
+			// 空闲超时后流被回收，新的包建立新的流
+			if stats := waitStats(t, f, func(s Stats) bool { return s.Active == 0 }); stats.Active != 0 {
+				t.Fatalf("Stats() = %+v, want idle flows expired", stats)
+			}
+			udpRoundTrip(t, conn, []byte("again"))
+			if stats := f.Stats(); stats.Connections != 3 {
+				t.Errorf("Stats() = %+v, want 3 flows", stats)
+			}
+		})
+	}
+}
+
+func TestUDPDatagramMode(t *testing.T) {
+	tests := []struct {
+		name           string
+		client, server bool // 两端是否有分发器
+		datagram       bool
+	}{
+		{"both", true, true, true},
+		{"client only", true, false, false},
+		{"server only", false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			echo := startUDPEcho(t)
+			client, server := newQUICSessionPair(t)
+			opts := allowAll()
+			if tt.server {
+				opts = allowAllDatagrams(t, server)
+			}
+			go NewServer(opts).Serve(server)
+
+			var demux *DatagramMux
+			if tt.client {
+				demux = NewDatagramMux(client)
+				defer demux.Close()
+			}
+			received := make(chan []byte, 16)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			tunnel, err := dialUDP(ctx, client, demux, echo, func(packet []byte) {
+				received <- append([]byte(nil), packet...)
+			})
+			if err != nil {
+				t.Fatalf("dialUDP() error = %v", err)
+			}
+			defer tunnel.Close()
+
+			// 只有两端都能接收数据报时才使用数据报，否则包在流上传输而不是被丢弃
+			if got := tunnel.dg != nil; got != tt.datagram {
+				t.Errorf("tunnel uses datagrams = %v, want %v", got, tt.datagram)
+			}
+			for i := 0; ; i++ {
+				if err := tunnel.Send([]byte("ping")); err != nil {
+					t.Fatalf("Send() error = %v", err)
+				}
+				select {
+				case packet := <-received:
+					if string(packet) != "ping" {
+						t.Fatalf("echo = %q, want ping", packet)
+					}
+					return
+				case <-time.After(250 * time.Millisecond):
+					if i == 20 {
+						t.Fatal("no echo")
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDatagramMuxSessionDone(t *testing.T) {
+	client, _ := newQUICSessionPair(t)
+	demux := NewDatagramMux(client)
+	if !demux.enabled() || !demux.add(1, func([]byte) {}) {
+		t.Fatal("DatagramMux on QUIC session should be enabled")
+	}
+
+	// 会话关闭后分发器停止接收并注销所有隧道
+	client.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for demux.enabled() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if demux.enabled() || len(demux.handlers) != 0 {
+		t.Errorf("DatagramMux after session close: enabled = %v, %d handlers", demux.enabled(), len(demux.handlers))
+	}
+	if demux.add(2, func([]byte) {}) {
+		t.Error("add() after session close = true, want false")
+	}
+}
+
+func TestLocalUDPDenied(t *testing.T) {
+	echo := startUDPEcho(t)
+	targets, err := NewPolicy([]string{"10.0.0.0/8:53"}, nil)
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+	client, server := newQUICSessionPair(t)
+	go NewServer(&ServerOptions{Targets: targets}).Serve(server)
+
+	f, err := LocalUDP(client, "127.0.0.1:0", echo)
+	if err != nil {
+		t.Fatalf("LocalUDP() error = %v", err)
+	}
+	defer f.Close()
+
+	conn, err := net.Dial("udp", f.Addr())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+
+	if stats := waitStats(t, f, func(s Stats) bool { return s.DialErrors == 1 }); stats.DialErrors != 1 {
+		t.Errorf("Stats() = %+v, want 1 dial error", stats)
+	}
+}
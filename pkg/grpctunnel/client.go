@@ -0,0 +1,140 @@
+// Package grpctunnel 在会话上承载 gRPC
+//
+// 客户端使用 qymux:///<name> 形式的目标：自定义的 qymux 解析器将目标解析为会话名称，
+// 连接时按名称查找会话并在其上打开流，因此同一个 SessionLookup（例如会话注册表）可以为多个 Agent 创建客户端。
+// SessionLookup 为 *Pool 时，目标解析为组中所有会话的地址并随会话的加入和关闭更新，RPC 由 round_robin 分配。
+// Server 管理 Agent 端服务器在会话上的生命周期，Proxy 将本地的 gRPC 请求透明转发到 Agent
+package grpctunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// Scheme 隧道目标的 URI scheme
+const Scheme = "qymux"
+
+// ErrSessionNotFound 目标名称没有对应的会话
+var ErrSessionNotFound = errors.New("grpctunnel: session not found")
+
+// roundRobinConfig 在目标的多个地址之间轮询分配 RPC 的服务配置
+const roundRobinConfig = `{"loadBalancingConfig": [{"round_robin": {}}]}`
+
+// DefaultKeepalive 默认的客户端保活参数，快速检测隧道断开
+var DefaultKeepalive = keepalive.ClientParameters{
+	Time:                30 * time.Second, // 每30秒发送一次 ping（避免 too_many_pings）
+	Timeout:             2 * time.Second,  // ping 超时2秒认为连接断开
+	PermitWithoutStream: true,             // 没有活跃 stream 也发送 ping
+}
+
+// SessionLookup 按名称查找会话，*registry.Registry 实现了该接口
+type SessionLookup interface {
+	Get(name string) (transport.MuxSession, bool)
+}
+
+// LookupFunc 将函数适配为 SessionLookup
+type LookupFunc func(name string) (transport.MuxSession, bool)
+
+// Get 调用 f
+func (f LookupFunc) Get(name string) (transport.MuxSession, bool) {
+	return f(name)
+}
+
+// Single 返回所有名称都指向 sess 的 SessionLookup
+func Single(sess transport.MuxSession) SessionLookup {
+	return LookupFunc(func(string) (transport.MuxSession, bool) {
+		return sess, true
+	})
+}
+
+// Options 定义隧道 gRPC 客户端的可选配置
+type Options struct {
+	// Keepalive 客户端保活参数，为 nil 时使用 DefaultKeepalive
+	// 服务端的 keepalive.EnforcementPolicy 需要允许该间隔，否则连接会因 too_many_pings 被关闭
+	Keepalive *keepalive.ClientParameters
+
+	// DialOptions 追加的 gRPC 选项，在默认选项之后应用，可以覆盖传输凭证等默认值
+	DialOptions []grpc.DialOption
+}
+
+// Target 返回名称对应的目标字符串 qymux:///<name>
+func Target(name string) string {
+	return Scheme + ":///" + url.PathEscape(name)
+}
+
+// NewClient 创建经由会话连接 target 的 gRPC 客户端，target 的格式为 qymux:///<name>
+// 与 grpc.NewClient 相同，连接在第一次 RPC 时建立；名称对应的会话不存在时 RPC 返回 Unavailable，
+// 会话上线后 gRPC 按退避策略重连
+func NewClient(target string, lookup SessionLookup, opts *Options) (*grpc.ClientConn, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	kacp := DefaultKeepalive
+	if opts.Keepalive != nil {
+		kacp = *opts.Keepalive
+	}
+
+	dialOpts := []grpc.DialOption{
+		grpc.WithResolvers(resolverBuilder{lookup: lookup}),
+		grpc.WithDefaultServiceConfig(roundRobinConfig),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return dial(lookup, addr)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()), // 隧道已加密，gRPC 层使用 Insecure
+		grpc.WithKeepaliveParams(kacp),
+	}
+	return grpc.NewClient(target, append(dialOpts, opts.DialOptions...)...)
+}
+
+// dial 在地址对应的会话上打开流
+func dial(lookup SessionLookup, addr string) (net.Conn, error) {
+	sess, ok := lookup.Get(addr)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrSessionNotFound, addr)
+	}
+	// 每次建立 gRPC 连接时，在多路复用 Session 上打开一个新流
+	return sess.OpenStream()
+}
+
+// resolverBuilder 解析 qymux:///<name>：lookup 为 *Pool 时跟踪组中的会话，否则地址即会话名称
+type resolverBuilder struct {
+	lookup SessionLookup
+}
+
+// Build 实现 resolver.Builder
+func (b resolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	name := target.Endpoint()
+	if name == "" {
+		return nil, fmt.Errorf("grpctunnel: missing session name in target %q", target.URL.String())
+	}
+	if pool, ok := b.lookup.(*Pool); ok {
+		return newPoolResolver(pool, name, cc), nil
+	}
+	cc.UpdateState(resolver.State{Addresses: []resolver.Address{{Addr: name}}})
+	return staticResolver{}, nil
+}
+
+// Scheme 实现 resolver.Builder
+func (resolverBuilder) Scheme() string {
+	return Scheme
+}
+
+// staticResolver 地址不会变化的解析器
+type staticResolver struct{}
+
+// ResolveNow 实现 resolver.Resolver
+func (staticResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+// Close 实现 resolver.Resolver
+func (staticResolver) Close() {}
@@ -0,0 +1,117 @@
+package grpctunnel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+
+	"github.com/funcx27/qymux/pkg/registry"
+	"github.com/funcx27/qymux/pkg/tcp"
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// newSessionPair 在本地回环上建立一对 TCP 会话
+func newSessionPair(t *testing.T) (client, server transport.MuxSession) {
+	t.Helper()
+
+	ln, err := tcp.Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	client, err = tcp.NewDialer(nil).Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	server, err = ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return client, server
+}
+
+// startHealthServer 在会话上运行只有健康检查服务的 gRPC 服务器
+func startHealthServer(t *testing.T, sess transport.MuxSession) *health.Server {
+	t.Helper()
+
+	hs := health.NewServer()
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(sess)
+	t.Cleanup(srv.Stop)
+	return hs
+}
+
+// check 调用健康检查
+func check(conn *grpc.ClientConn, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err
+}
+
+func TestNewClient(t *testing.T) {
+	agent, sess := newSessionPair(t)
+	startHealthServer(t, agent)
+
+	reg := registry.New()
+	reg.Register("edge1", sess)
+
+	conn, err := NewClient(Target("edge1"), reg, &Options{
+		Keepalive: &keepalive.ClientParameters{Time: time.Minute, Timeout: 5 * time.Second},
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer conn.Close()
+	if err := check(conn, 5*time.Second); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if conn.CanonicalTarget() != "qymux:///edge1" {
+		t.Errorf("CanonicalTarget() = %q", conn.CanonicalTarget())
+	}
+
+	// 目标名称没有对应的会话
+	offline, err := NewClient(Target("edge2"), reg, nil)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer offline.Close()
+	if err := check(offline, 500*time.Millisecond); status.Code(err) != codes.Unavailable && status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Check() on offline agent error = %v, want Unavailable", err)
+	}
+
+	// 目标缺少会话名称时解析失败
+	empty, err := NewClient("qymux:///", reg, nil)
+	if err == nil {
+		defer empty.Close()
+		if err := check(empty, 200*time.Millisecond); err == nil {
+			t.Error("Check() with empty target name error = nil")
+		}
+	}
+}
+
+func TestSingle(t *testing.T) {
+	agent, sess := newSessionPair(t)
+	startHealthServer(t, agent)
+
+	conn, err := NewClient(Target("anything"), Single(sess), nil)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer conn.Close()
+	if err := check(conn, 5*time.Second); err != nil {
+		t.Errorf("Check() error = %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package grpctunnel
+
+import (
+	"strconv"
+	"sync"
+
+	"google.golang.org/grpc/resolver"
+
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// Pool 是按名称分组、动态变化的会话集合，实现 SessionLookup
+// 同名的多个会话（例如 Agent 连接到多个 Server，或同一服务的多个 Agent）在 gRPC 中是同一目标的多个地址，
+// 由 round_robin 负载均衡在其间分配 RPC；会话加入或关闭时解析结果随之更新
+type Pool struct {
+	mu       sync.Mutex
+	groups   map[string][]string                   // 名称 -> 地址，按加入顺序
+	sessions map[string]transport.MuxSession       // 地址 -> 会话
+	watchers map[string]map[*poolResolver]struct{} // 名称 -> 解析器
+	nextID   uint64
+}
+
+// NewPool 创建会话池
+func NewPool() *Pool {
+	return &Pool{
+		groups:   make(map[string][]string),
+		sessions: make(map[string]transport.MuxSession),
+		watchers: make(map[string]map[*poolResolver]struct{}),
+	}
+}
+
+// Add 将会话加入名称对应的组，会话关闭时自动移除（需要会话支持 transport.SessionDone）
+func (p *Pool) Add(name string, sess transport.MuxSession) {
+	p.mu.Lock()
+	p.nextID++
+	addr := name + "#" + strconv.FormatUint(p.nextID, 10)
+	p.groups[name] = append(p.groups[name], addr)
+	p.sessions[addr] = sess
+	p.notifyLocked(name)
+	p.mu.Unlock()
+
+	if done := transport.SessionDone(sess); done != nil {
+		go func() {
+			<-done
+			p.Remove(name, sess)
+		}()
+	}
+}
+
+// Remove 将会话从组中移除，会话不在组中时返回 false
+func (p *Pool) Remove(name string, sess transport.MuxSession) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	addrs := p.groups[name]
+	for i, addr := range addrs {
+		if p.sessions[addr] != sess {
+			continue
+		}
+		delete(p.sessions, addr)
+		if len(addrs) == 1 {
+			delete(p.groups, name)
+		} else {
+			p.groups[name] = append(addrs[:i:i], addrs[i+1:]...)
+		}
+		p.notifyLocked(name)
+		return true
+	}
+	return false
+}
+
+// Sessions 返回组中的会话
+func (p *Pool) Sessions(name string) []transport.MuxSession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sessions := make([]transport.MuxSession, 0, len(p.groups[name]))
+	for _, addr := range p.groups[name] {
+		sessions = append(sessions, p.sessions[addr])
+	}
+	return sessions
+}
+
+// Get 实现 SessionLookup：key 为解析出的地址时返回对应的会话，为名称时返回组中最早加入的会话
+func (p *Pool) Get(key string) (transport.MuxSession, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if sess, ok := p.sessions[key]; ok {
+		return sess, true
+	}
+	if addrs := p.groups[key]; len(addrs) > 0 {
+		return p.sessions[addrs[0]], true
+	}
+	return nil, false
+}
+
+// addresses 返回组中会话的地址
+func (p *Pool) addresses(name string) []resolver.Address {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	addrs := make([]resolver.Address, 0, len(p.groups[name]))
+	for _, addr := range p.groups[name] {
+		addrs = append(addrs, resolver.Address{Addr: addr})
+	}
+	return addrs
+}
+
+// watch 注册解析器，组变化时通知它
+func (p *Pool) watch(name string, r *poolResolver) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.watchers[name] == nil {
+		p.watchers[name] = make(map[*poolResolver]struct{})
+	}
+	p.watchers[name][r] = struct{}{}
+}
+
+// unwatch 注销解析器
+func (p *Pool) unwatch(name string, r *poolResolver) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.watchers[name], r)
+	if len(p.watchers[name]) == 0 {
+		delete(p.watchers, name)
+	}
+}
+
+// notifyLocked 通知组的解析器重新解析，调用时持有 p.mu
+func (p *Pool) notifyLocked(name string) {
+	for r := range p.watchers[name] {
+		r.resolveNow()
+	}
+}
+
+// poolResolver 跟踪 Pool 中一个组的解析器
+// 通知合并到容量为 1 的 channel，由单个 goroutine 读取最新的组并更新，保证更新按顺序生效
+type poolResolver struct {
+	pool   *Pool
+	name   string
+	cc     resolver.ClientConn
+	notify chan struct{}
+	done   chan struct{}
+}
+
+// newPoolResolver 创建解析器并立即解析一次
+func newPoolResolver(pool *Pool, name string, cc resolver.ClientConn) *poolResolver {
+	r := &poolResolver{
+		pool:   pool,
+		name:   name,
+		cc:     cc,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	pool.watch(name, r)
+	r.resolveNow()
+	go r.run()
+	return r
+}
+
+// run 处理通知直到解析器关闭
+func (r *poolResolver) run() {
+	for {
+		select {
+		case <-r.notify:
+			r.update()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// update 将组中的地址更新到 ClientConn，组为空时报告错误，RPC 返回 Unavailable 直到有会话加入
+func (r *poolResolver) update() {
+	addrs := r.pool.addresses(r.name)
+	if len(addrs) == 0 {
+		// 只报告错误时负载均衡会保留之前的地址，先清空地址
+		r.cc.UpdateState(resolver.State{})
+		r.cc.ReportError(ErrSessionNotFound)
+		return
+	}
+	r.cc.UpdateState(resolver.State{Addresses: addrs})
+}
+
+// resolveNow 请求重新解析
+func (r *poolResolver) resolveNow() {
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// ResolveNow 实现 resolver.Resolver
+func (r *poolResolver) ResolveNow(resolver.ResolveNowOptions) {
+	r.resolveNow()
+}
+
+// Close 实现 resolver.Resolver
+func (r *poolResolver) Close() {
+	r.pool.unwatch(r.name, r)
+	close(r.done)
+}
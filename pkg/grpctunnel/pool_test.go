@@ -0,0 +1,173 @@
+package grpctunnel
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// countingAgent 建立会话并运行统计调用次数的健康检查服务，返回 Server 端的会话
+func countingAgent(t *testing.T, calls *atomic.Int64) transport.MuxSession {
+	t.Helper()
+
+	agent, sess := newSessionPair(t)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		calls.Add(1)
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(agent)
+	t.Cleanup(srv.Stop)
+	return sess
+}
+
+func TestPool(t *testing.T) {
+	var calls1, calls2, calls3 atomic.Int64
+	sess1 := countingAgent(t, &calls1)
+	sess2 := countingAgent(t, &calls2)
+
+	pool := NewPool()
+	pool.Add("svc", sess1)
+	pool.Add("svc", sess2)
+	if got := pool.Sessions("svc"); len(got) != 2 {
+		t.Fatalf("Sessions() = %d sessions, want 2", len(got))
+	}
+	if sess, ok := pool.Get("svc"); !ok || sess != sess1 {
+		t.Errorf("Get(name) = %v, %v; want first session", sess, ok)
+	}
+
+	conn, err := NewClient(Target("svc"), pool, nil)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer conn.Close()
+
+	// 两个会话都就绪后，round_robin 在其间轮询
+	deadline := time.Now().Add(5 * time.Second)
+	for calls1.Load() == 0 || calls2.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("calls = %d/%d, want both sessions used", calls1.Load(), calls2.Load())
+		}
+		if err := check(conn, 5*time.Second); err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+	}
+
+	// 会话关闭后移除，RPC 只发往剩余的会话
+	sess1.Close()
+	waitFor(t, func() bool { return len(pool.Sessions("svc")) == 1 })
+	// 解析结果生效前已选中的连接可能仍指向关闭的会话，等待 RPC 恢复成功
+	waitFor(t, func() bool { return check(conn, 5*time.Second) == nil })
+	before := calls2.Load()
+	for i := 0; i < 10; i++ {
+		if err := check(conn, 5*time.Second); err != nil {
+			t.Fatalf("Check() after close error = %v", err)
+		}
+	}
+	if calls2.Load()-before != 10 {
+		t.Errorf("calls on remaining session = %d, want 10", calls2.Load()-before)
+	}
+
+	// 新加入的会话被使用
+	sess3 := countingAgent(t, &calls3)
+	pool.Add("svc", sess3)
+	deadline = time.Now().Add(5 * time.Second)
+	for calls3.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("added session not used")
+		}
+		check(conn, 5*time.Second)
+	}
+
+	// 组变空后解析器推送空的地址列表并报告错误，负载均衡不再保留之前的地址
+	rec := newStateRecorder()
+	r := newPoolResolver(pool, "svc", rec)
+	defer r.Close()
+	if state := rec.next(t); len(state.Addresses) != 2 {
+		t.Fatalf("resolver state = %d addresses, want 2", len(state.Addresses))
+	}
+	if !pool.Remove("svc", sess3) || pool.Remove("svc", sess3) {
+		t.Error("Remove() should succeed once")
+	}
+	if state := rec.next(t); len(state.Addresses) != 1 {
+		t.Fatalf("resolver state after Remove() = %d addresses, want 1", len(state.Addresses))
+	}
+	sess2.Close()
+	if state := rec.next(t); len(state.Addresses) != 0 {
+		t.Fatalf("resolver state with empty pool = %d addresses, want 0", len(state.Addresses))
+	}
+	if err := rec.nextError(t); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("resolver error = %v, want ErrSessionNotFound", err)
+	}
+	if n := len(pool.Sessions("svc")); n != 0 {
+		t.Errorf("Sessions() = %d sessions, want 0", n)
+	}
+}
+
+// stateRecorder 记录解析器推送的状态和错误
+type stateRecorder struct {
+	resolver.ClientConn
+	states chan resolver.State
+	errs   chan error
+}
+
+// newStateRecorder 创建 stateRecorder
+func newStateRecorder() *stateRecorder {
+	return &stateRecorder{states: make(chan resolver.State, 8), errs: make(chan error, 8)}
+}
+
+// UpdateState 实现 resolver.ClientConn
+func (r *stateRecorder) UpdateState(state resolver.State) error {
+	r.states <- state
+	return nil
+}
+
+// ReportError 实现 resolver.ClientConn
+func (r *stateRecorder) ReportError(err error) {
+	r.errs <- err
+}
+
+// next 返回解析器推送的下一个状态
+func (r *stateRecorder) next(t *testing.T) resolver.State {
+	t.Helper()
+	select {
+	case state := <-r.states:
+		return state
+	case <-time.After(5 * time.Second):
+		t.Fatal("no resolver state")
+		return resolver.State{}
+	}
+}
+
+// nextError 返回解析器报告的下一个错误
+func (r *stateRecorder) nextError(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-r.errs:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("no resolver error")
+		return nil
+	}
+}
+
+// waitFor 等待条件满足
+func waitFor(t *testing.T, ok func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
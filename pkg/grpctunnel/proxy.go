@@ -0,0 +1,277 @@
+package grpctunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/mem"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// DefaultAgentMetadata 未指定固定目标时选择 Agent 的请求元数据键
+const DefaultAgentMetadata = "x-qymux-agent"
+
+// ProxyOptions 定义 gRPC 透明代理的可选配置
+type ProxyOptions struct {
+	// Target 固定的转发目标 qymux:///<name>，设置后忽略请求元数据
+	Target string
+
+	// AgentMetadata 选择 Agent 的请求元数据键，默认 DefaultAgentMetadata；该键不会转发给 Agent
+	AgentMetadata string
+
+	// Client 连接 Agent 的 gRPC 客户端配置
+	Client *Options
+}
+
+// Proxy 是 Server 端的 gRPC 透明代理
+// 本地没有注册的方法（包括反射和健康检查）以原始字节转发到所选 Agent 的会话，不需要服务的 proto 定义，
+// 因此 grpcurl 等工具可以直接调试只能经由隧道访问的 Agent
+type Proxy struct {
+	lookup        SessionLookup
+	target        string
+	agentMetadata string
+	clientOpts    *Options
+	server        *grpc.Server
+
+	mu     sync.Mutex
+	conns  map[string]*grpc.ClientConn // 目标 -> 客户端
+	closed bool
+	done   chan struct{} // Close 时关闭
+}
+
+// NewProxy 创建 gRPC 透明代理，按 opts.Target 或请求元数据从 lookup 中选择 Agent 会话
+func NewProxy(lookup SessionLookup, opts *ProxyOptions) *Proxy {
+	p := &Proxy{
+		lookup:        lookup,
+		agentMetadata: DefaultAgentMetadata,
+		conns:         make(map[string]*grpc.ClientConn),
+		done:          make(chan struct{}),
+	}
+	if opts != nil {
+		p.target = opts.Target
+		p.clientOpts = opts.Client
+		if opts.AgentMetadata != "" {
+			p.agentMetadata = opts.AgentMetadata
+		}
+	}
+	p.server = grpc.NewServer(p.ServerOptions()...)
+	return p
+}
+
+// ServerOptions 返回将未知方法转发到 Agent 的 gRPC 服务器选项
+// 可用于创建同时提供本地服务的服务器：已注册的服务在本地处理，其余方法转发
+func (p *Proxy) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ForceServerCodecV2(rawCodec{}),
+		grpc.UnknownServiceHandler(p.handle),
+	}
+}
+
+// Serve 接受 ln 上的连接并代理请求，ln 关闭或 Close 后返回
+func (p *Proxy) Serve(ln net.Listener) error {
+	log.Printf("[Qymux-GRPC] gRPC 代理监听 %s", ln.Addr())
+	return p.server.Serve(ln)
+}
+
+// Close 停止 Serve 并关闭到 Agent 的客户端连接
+func (p *Proxy) Close() error {
+	p.server.Stop()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.done)
+	}
+	for target, conn := range p.conns {
+		conn.Close()
+		delete(p.conns, target)
+	}
+	return nil
+}
+
+// handle 将一个 RPC 转发到 Agent，双向复制消息直到 Agent 结束调用
+func (p *Proxy) handle(_ any, ss grpc.ServerStream) error {
+	method, ok := grpc.MethodFromServerStream(ss)
+	if !ok {
+		return status.Error(codes.Internal, "grpctunnel: missing method")
+	}
+
+	md, _ := metadata.FromIncomingContext(ss.Context())
+	md = md.Copy()
+	conn, err := p.conn(md)
+	if err != nil {
+		return err
+	}
+	delete(md, p.agentMetadata)
+
+	ctx, cancel := context.WithCancel(metadata.NewOutgoingContext(ss.Context(), md))
+	defer cancel()
+	cs, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, method, grpc.ForceCodecV2(rawCodec{}))
+	if err != nil {
+		return err
+	}
+
+	// 客户端 -> Agent；客户端异常结束时取消到 Agent 的调用
+	go func() {
+		for {
+			f := &frame{}
+			if err := ss.RecvMsg(f); err != nil {
+				if errors.Is(err, io.EOF) {
+					cs.CloseSend()
+				} else {
+					cancel()
+				}
+				return
+			}
+			if err := cs.SendMsg(f); err != nil {
+				// Agent 已结束调用，结果由 RecvMsg 返回
+				return
+			}
+		}
+	}()
+
+	// Agent 的响应头部到达后立即转发，客户端不必等到第一条消息；
+	// 客户端 -> Agent 的转发已经开始，Agent 等待请求消息后才发送头部时不会死锁
+	if header, err := cs.Header(); err == nil && header != nil {
+		if err := ss.SendHeader(header); err != nil {
+			return err
+		}
+	}
+
+	// Agent -> 客户端
+	for {
+		f := &frame{}
+		err := cs.RecvMsg(f)
+		if err != nil {
+			ss.SetTrailer(cs.Trailer())
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := ss.SendMsg(f); err != nil {
+			return err
+		}
+	}
+}
+
+// conn 返回请求对应 Agent 的客户端，同一目标复用连接
+// 按元数据选择的 Agent 不在 lookup 中时关闭并删除缓存的客户端
+func (p *Proxy) conn(md metadata.MD) (*grpc.ClientConn, error) {
+	target := p.target
+	var name string
+	var sess transport.MuxSession
+	if target == "" {
+		values := md.Get(p.agentMetadata)
+		if len(values) == 0 || values[0] == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "grpctunnel: missing %s metadata", p.agentMetadata)
+		}
+		name = values[0]
+		target = Target(name)
+
+		var ok bool
+		if sess, ok = p.lookup.Get(name); !ok {
+			p.evict(target, nil)
+			return nil, status.Errorf(codes.Unavailable, "%v: %q", ErrSessionNotFound, name)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, status.Error(codes.Unavailable, "grpctunnel: proxy closed")
+	}
+	if conn, ok := p.conns[target]; ok {
+		return conn, nil
+	}
+	conn, err := NewClient(target, p.lookup, p.clientOpts)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	p.conns[target] = conn
+	if sess != nil {
+		go p.evictOnDone(name, target, conn, sess)
+	}
+	return conn, nil
+}
+
+// evictOnDone 在 Agent 的会话关闭后检查 lookup，Agent 已经下线时关闭并删除客户端；
+// Agent 以新的会话重新接入时客户端继续使用，改为跟踪新的会话
+func (p *Proxy) evictOnDone(name, target string, conn *grpc.ClientConn, sess transport.MuxSession) {
+	for {
+		done := transport.SessionDone(sess)
+		if done == nil {
+			return
+		}
+		select {
+		case <-done:
+		case <-p.done:
+			return
+		}
+
+		// lookup 可能尚未移除已关闭的会话，这种情况同样视为下线
+		current, ok := p.lookup.Get(name)
+		if !ok || current == sess {
+			p.evict(target, conn)
+			return
+		}
+		sess = current
+	}
+}
+
+// evict 关闭并删除目标的客户端，conn 不为 nil 时只删除该客户端
+func (p *Proxy) evict(target string, conn *grpc.ClientConn) {
+	p.mu.Lock()
+	cached, ok := p.conns[target]
+	if !ok || conn != nil && cached != conn {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.conns, target)
+	p.mu.Unlock()
+
+	cached.Close()
+}
+
+// frame 代理转发的原始消息
+type frame struct {
+	payload []byte
+}
+
+// rawCodec 对 frame 不做编解码，其余消息交给 proto 编解码器，已注册的本地服务不受影响
+// 名称与 proto 编解码器相同，转发时的 content-type 保持 application/grpc+proto
+type rawCodec struct{}
+
+// Marshal 实现 encoding.CodecV2
+func (rawCodec) Marshal(v any) (mem.BufferSlice, error) {
+	if f, ok := v.(*frame); ok {
+		return mem.BufferSlice{mem.SliceBuffer(f.payload)}, nil
+	}
+	return encoding.GetCodecV2(proto.Name).Marshal(v)
+}
+
+// Unmarshal 实现 encoding.CodecV2
+func (rawCodec) Unmarshal(data mem.BufferSlice, v any) error {
+	if f, ok := v.(*frame); ok {
+		f.payload = data.Materialize()
+		return nil
+	}
+	return encoding.GetCodecV2(proto.Name).Unmarshal(data, v)
+}
+
+// Name 实现 encoding.CodecV2
+func (rawCodec) Name() string {
+	return proto.Name
+}
@@ -0,0 +1,184 @@
+package grpctunnel
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/funcx27/qymux/pkg/registry"
+)
+
+// startProxy 启动 gRPC 代理并返回连接代理的客户端
+func startProxy(t *testing.T, p *Proxy) *grpc.ClientConn {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	go p.Serve(ln)
+	t.Cleanup(func() { p.Close() })
+
+	conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// withAgent 返回携带 Agent 元数据的 context
+func withAgent(agent string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	return metadata.AppendToOutgoingContext(ctx, DefaultAgentMetadata, agent), cancel
+}
+
+func TestProxy(t *testing.T) {
+	agent, sess := newSessionPair(t)
+	srv := grpc.NewServer()
+	tunnel := NewServer(srv, &ServerOptions{Health: health.NewServer(), Services: []string{"billing"}})
+	reflection.Register(srv)
+	tunnel.Serve(agent)
+	t.Cleanup(tunnel.Stop)
+
+	reg := registry.New()
+	reg.Register("edge1", sess)
+	conn := startProxy(t, NewProxy(reg, nil))
+	hc := healthpb.NewHealthClient(conn)
+
+	ctx, cancel := withAgent("edge1")
+	defer cancel()
+	resp, err := hc.Check(ctx, &healthpb.HealthCheckRequest{Service: "billing"})
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("Check() via proxy = %v, %v; want SERVING", resp, err)
+	}
+
+	// Agent 返回的错误状态原样转发
+	if _, err := hc.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Check(unknown) error = %v, want NotFound", err)
+	}
+
+	// 反射（双向流）
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		t.Fatalf("ServerReflectionInfo() error = %v", err)
+	}
+	stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	ref, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("reflection Recv() error = %v", err)
+	}
+	services := map[string]bool{}
+	for _, s := range ref.GetListServicesResponse().GetService() {
+		services[s.Name] = true
+	}
+	if !services[healthpb.Health_ServiceDesc.ServiceName] {
+		t.Errorf("ListServices() = %v, want health service", services)
+	}
+	stream.CloseSend()
+
+	// 缺少或未知的 Agent
+	if _, err := hc.Check(context.Background(), &healthpb.HealthCheckRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Check() without agent error = %v, want InvalidArgument", err)
+	}
+	ctx2, cancel2 := withAgent("edge2")
+	defer cancel2()
+	if _, err := hc.Check(ctx2, &healthpb.HealthCheckRequest{}); status.Code(err) != codes.Unavailable {
+		t.Errorf("Check() on offline agent error = %v, want Unavailable", err)
+	}
+}
+
+func TestProxyTarget(t *testing.T) {
+	agent, sess := newSessionPair(t)
+	startHealthServer(t, agent)
+
+	conn := startProxy(t, NewProxy(Single(sess), &ProxyOptions{Target: Target("edge1")}))
+	if err := check(conn, 5*time.Second); err != nil {
+		t.Errorf("Check() via fixed target error = %v", err)
+	}
+}
+
+func TestProxyHeader(t *testing.T) {
+	agent, sess := newSessionPair(t)
+	// Agent 只发送头部，不发送任何消息
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+		if err := stream.SendHeader(metadata.Pairs("x-agent", "edge1")); err != nil {
+			return err
+		}
+		<-stream.Context().Done()
+		return nil
+	}))
+	tunnel := NewServer(srv, nil)
+	tunnel.Serve(agent)
+	t.Cleanup(tunnel.Stop)
+
+	reg := registry.New()
+	reg.Register("edge1", sess)
+	conn := startProxy(t, NewProxy(reg, nil))
+
+	ctx, cancel := withAgent("edge1")
+	defer cancel()
+	cs, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, "/test.Echo/Chat", grpc.ForceCodecV2(rawCodec{}))
+	if err != nil {
+		t.Fatalf("NewStream() error = %v", err)
+	}
+	header, err := cs.Header()
+	if err != nil {
+		t.Fatalf("Header() error = %v", err)
+	}
+	if got := header.Get("x-agent"); len(got) != 1 || got[0] != "edge1" {
+		t.Errorf("Header() x-agent = %v, want [edge1]", got)
+	}
+}
+
+func TestProxyEvict(t *testing.T) {
+	agent, sess := newSessionPair(t)
+	startHealthServer(t, agent)
+
+	reg := registry.New()
+	reg.Register("edge1", sess)
+	p := NewProxy(reg, nil)
+	conn := startProxy(t, p)
+	hc := healthpb.NewHealthClient(conn)
+
+	ctx, cancel := withAgent("edge1")
+	defer cancel()
+	if _, err := hc.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("Check() via proxy error = %v", err)
+	}
+
+	cached := func() int {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return len(p.conns)
+	}
+	if n := cached(); n != 1 {
+		t.Fatalf("cached conns = %d, want 1", n)
+	}
+
+	// Agent 下线后缓存的客户端被关闭并删除
+	sess.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for cached() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("cached conns = %d after agent left, want 0", cached())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := hc.Check(ctx, &healthpb.HealthCheckRequest{}); status.Code(err) != codes.Unavailable {
+		t.Errorf("Check() on offline agent error = %v, want Unavailable", err)
+	}
+}
@@ -0,0 +1,228 @@
+package grpctunnel
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// errNilSession Serve 的会话为 nil
+var errNilSession = errors.New("grpctunnel: nil session")
+
+// ServerOptions 定义隧道 gRPC 服务器的可选配置
+type ServerOptions struct {
+	// Health 随隧道状态更新的健康检查服务：有会话在服务时为 SERVING，所有会话断开后为 NOT_SERVING，停止后不再更新
+	// 服务器尚未注册健康检查服务时自动注册；为 nil 时不提供健康检查
+	Health *health.Server
+
+	// Services 除整体状态（空服务名）外同时随隧道状态更新的服务名
+	Services []string
+}
+
+// Server 管理会话上的 gRPC 服务器的生命周期
+// 会话断开后服务器仍然可用，Agent 重新连接后可以调用 Serve 在新的会话上继续服务。
+// 没有单个会话的优雅排空：会话断开时其上进行中的 RPC 立即中止，客户端收到 Unavailable，
+// 只有 GracefulStop 会等待进行中的 RPC 完成
+type Server struct {
+	server   *grpc.Server
+	health   *health.Server
+	services []string
+
+	mu      sync.Mutex
+	active  int           // 正在服务的会话数
+	idle    chan struct{} // 没有会话在服务时关闭
+	err     error         // 最近一次结束服务的错误
+	stopped bool
+}
+
+// NewServer 创建 gRPC 服务器的管理句柄，需要在 server 开始服务之前调用
+// opts.Health 不为 nil 时在此注册健康检查服务，状态为 NOT_SERVING 直到第一次 Serve
+func NewServer(server *grpc.Server, opts *ServerOptions) *Server {
+	s := &Server{server: server, idle: make(chan struct{})}
+	close(s.idle)
+
+	if opts != nil && opts.Health != nil {
+		s.health = opts.Health
+		s.services = opts.Services
+		if _, ok := server.GetServiceInfo()[healthpb.Health_ServiceDesc.ServiceName]; !ok {
+			healthpb.RegisterHealthServer(server, s.health)
+		}
+		s.setHealth(healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+	return s
+}
+
+// Serve 在 sess 上开始服务并立即返回，会话断开时这次服务结束，其上进行中的 RPC 被中止
+// 可以多次调用以在替代的会话上继续服务；服务器已停止时返回 grpc.ErrServerStopped
+func (s *Server) Serve(sess transport.MuxSession) error {
+	if sess == nil {
+		return errNilSession
+	}
+
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return grpc.ErrServerStopped
+	}
+	s.active++
+	if s.active == 1 {
+		s.idle = make(chan struct{})
+		s.setHealth(healthpb.HealthCheckResponse_SERVING)
+	}
+	s.mu.Unlock()
+
+	log.Printf("[Qymux-GRPC] 在 %s 协议上启动 gRPC 服务器", sess.Protocol())
+	go func() {
+		err := s.server.Serve(newSessionListener(sess)) // 当连接断开时，Serve() 会返回
+		if err != nil {
+			log.Printf("[Qymux-GRPC] %s 会话上的 gRPC 服务结束: %v", sess.Protocol(), err)
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.active--
+		if !s.stopped {
+			s.err = err
+		}
+		if s.active == 0 {
+			if !s.stopped {
+				s.setHealth(healthpb.HealthCheckResponse_NOT_SERVING)
+			}
+			close(s.idle)
+		}
+	}()
+	return nil
+}
+
+// Wait 等待所有会话上的服务结束，返回最近一次结束的原因：会话断开时为 Serve 的错误，服务器停止时为 nil
+// 没有会话在服务时立即返回
+func (s *Server) Wait() error {
+	s.mu.Lock()
+	idle := s.idle
+	s.mu.Unlock()
+
+	<-idle
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return nil
+	}
+	return s.err
+}
+
+// GracefulStop 停止接收新的 RPC 并等待进行中的 RPC 完成，ctx 结束时强制停止并返回 ctx 的错误
+// 健康检查立即变为 NOT_SERVING，之后不能再调用 Serve
+func (s *Server) GracefulStop(ctx context.Context) error {
+	s.stop()
+
+	done := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Stop 立即停止服务器，关闭所有会话上的连接；会话本身不会被关闭
+func (s *Server) Stop() {
+	s.stop()
+	s.server.Stop()
+}
+
+// stop 标记服务器已停止并更新健康状态
+func (s *Server) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+	if s.health != nil {
+		s.health.Shutdown()
+	}
+}
+
+// setHealth 更新健康状态，调用时持有 s.mu 或 s 尚未共享
+func (s *Server) setHealth(status healthpb.HealthCheckResponse_ServingStatus) {
+	if s.health == nil {
+		return
+	}
+	s.health.SetServingStatus("", status)
+	for _, service := range s.services {
+		s.health.SetServingStatus(service, status)
+	}
+}
+
+// sessionListener 将会话作为 grpc.Server 的监听器
+// Close 只停止接受新的流而不关闭会话，GracefulStop 时进行中的 RPC 可以继续在会话上完成
+type sessionListener struct {
+	transport.MuxSession
+	conns  chan net.Conn
+	err    error // 会话的 Accept 错误，conns 关闭后可读
+	closed chan struct{}
+	once   sync.Once
+}
+
+// newSessionListener 创建监听器并开始接受会话上的流
+func newSessionListener(sess transport.MuxSession) *sessionListener {
+	l := &sessionListener{
+		MuxSession: sess,
+		conns:      make(chan net.Conn),
+		closed:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+// acceptLoop 接受会话上的流直到会话关闭，监听器关闭后接受到的流直接关闭
+func (l *sessionListener) acceptLoop() {
+	defer close(l.conns)
+	for {
+		conn, err := l.MuxSession.Accept()
+		if err != nil {
+			l.err = err
+			return
+		}
+		select {
+		case l.conns <- conn:
+		case <-l.closed:
+			conn.Close()
+		}
+	}
+}
+
+// Accept 返回会话上的下一个流
+func (l *sessionListener) Accept() (net.Conn, error) {
+	select {
+	case conn, ok := <-l.conns:
+		if !ok {
+			return nil, l.err
+		}
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 停止接受新的流
+func (l *sessionListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
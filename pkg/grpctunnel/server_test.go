@@ -0,0 +1,112 @@
+package grpctunnel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// localStatus 查询本地健康检查服务的状态
+func localStatus(t *testing.T, hs *health.Server, service string) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	resp, err := hs.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatalf("Check(%q) error = %v", service, err)
+	}
+	return resp.Status
+}
+
+func TestServerLifecycle(t *testing.T) {
+	hs := health.NewServer()
+	srv := NewServer(grpc.NewServer(), &ServerOptions{Health: hs, Services: []string{"agent"}})
+	if got := localStatus(t, hs, "agent"); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("status before Serve = %v, want NOT_SERVING", got)
+	}
+
+	agent, sess := newSessionPair(t)
+	if err := srv.Serve(agent); err != nil {
+		t.Fatalf("Serve() error = %v", err)
+	}
+	conn, err := NewClient(Target("agent"), Single(sess), nil)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer conn.Close()
+	if err := check(conn, 5*time.Second); err != nil {
+		t.Fatalf("Check() through tunnel error = %v", err)
+	}
+	if got := localStatus(t, hs, ""); got != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("status while serving = %v, want SERVING", got)
+	}
+
+	// 会话断开：Wait 返回 Serve 的错误，健康状态变为 NOT_SERVING
+	agent.Close()
+	if err := srv.Wait(); err == nil {
+		t.Error("Wait() after session closed error = nil")
+	}
+	if got := localStatus(t, hs, "agent"); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("status after session closed = %v, want NOT_SERVING", got)
+	}
+
+	// 在替代的会话上继续服务
+	agent2, sess2 := newSessionPair(t)
+	if err := srv.Serve(agent2); err != nil {
+		t.Fatalf("Serve() on replacement session error = %v", err)
+	}
+	conn2, err := NewClient(Target("agent"), Single(sess2), nil)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer conn2.Close()
+	if err := check(conn2, 5*time.Second); err != nil {
+		t.Fatalf("Check() on replacement session error = %v", err)
+	}
+
+	// 进行中的流式 RPC 阻止优雅停止，ctx 结束后强制停止
+	watch, err := healthpb.NewHealthClient(conn2).Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	watch.Recv()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := srv.GracefulStop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GracefulStop() error = %v, want context.DeadlineExceeded", err)
+	}
+	if err := srv.Wait(); err != nil {
+		t.Errorf("Wait() after stop error = %v, want nil", err)
+	}
+	if got := localStatus(t, hs, ""); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("status after stop = %v, want NOT_SERVING", got)
+	}
+	if err := srv.Serve(sess2); !errors.Is(err, grpc.ErrServerStopped) {
+		t.Errorf("Serve() after stop error = %v, want grpc.ErrServerStopped", err)
+	}
+}
+
+func TestServerGracefulStop(t *testing.T) {
+	agent, sess := newSessionPair(t)
+	srv := NewServer(grpc.NewServer(), &ServerOptions{Health: health.NewServer()})
+	srv.Serve(agent)
+
+	conn, err := NewClient(Target("agent"), Single(sess), nil)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer conn.Close()
+	if err := check(conn, 5*time.Second); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+
+	if err := srv.GracefulStop(context.Background()); err != nil {
+		t.Errorf("GracefulStop() error = %v", err)
+	}
+	if err := srv.Wait(); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+}
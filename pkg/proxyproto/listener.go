@@ -0,0 +1,137 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/utils"
+)
+
+// DefaultHeaderTimeout 读取 PROXY 头的默认超时时间
+const DefaultHeaderTimeout = 5 * time.Second
+
+// Config 定义 PROXY 协议解析配置
+type Config struct {
+	// TrustedCIDRs 允许携带 PROXY 头的来源网段（通常为负载均衡器地址），不能为空
+	// 其他来源的 PROXY 头不会被解析；确需信任所有来源时显式配置 0.0.0.0/0 和 ::/0
+	TrustedCIDRs []string
+
+	// Required 为 true 时，来自可信来源但缺少 PROXY 头的连接将被拒绝
+	Required bool
+
+	// HeaderTimeout 读取 PROXY 头的超时时间，为 0 时使用 DefaultHeaderTimeout
+	HeaderTimeout time.Duration
+}
+
+// Listener 在 net.Listener 之上解析 PROXY 协议头
+type Listener struct {
+	net.Listener
+	trusted []*net.IPNet
+	config  Config
+}
+
+// NewListener 创建解析 PROXY 协议头的监听器
+// 未配置 TrustedCIDRs 时返回 ErrNoTrustedCIDRs，避免任意客户端伪造来源地址
+func NewListener(ln net.Listener, config *Config) (*Listener, error) {
+	if config == nil || len(config.TrustedCIDRs) == 0 {
+		return nil, ErrNoTrustedCIDRs
+	}
+
+	trusted, err := utils.ParseCIDRs(config.TrustedCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("proxyproto: %w", err)
+	}
+
+	cfg := *config
+	if cfg.HeaderTimeout <= 0 {
+		cfg.HeaderTimeout = DefaultHeaderTimeout
+	}
+
+	return &Listener{
+		Listener: ln,
+		trusted:  trusted,
+		config:   cfg,
+	}, nil
+}
+
+// Accept 接受新连接
+// PROXY 头在首次 Read 或 RemoteAddr 时才解析，不会阻塞接受循环
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	trusted := utils.ContainsIP(l.trusted, utils.AddrIP(conn.RemoteAddr()))
+	return &Conn{
+		Conn:     conn,
+		reader:   bufio.NewReader(conn),
+		trusted:  trusted,
+		required: l.config.Required,
+		timeout:  l.config.HeaderTimeout,
+	}, nil
+}
+
+// Conn 是携带 PROXY 协议信息的连接
+type Conn struct {
+	net.Conn
+	reader   *bufio.Reader
+	trusted  bool
+	required bool
+	timeout  time.Duration
+
+	once   sync.Once
+	header *Header
+	err    error
+}
+
+// Read 读取 PROXY 头之后的应用数据
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr 返回真实的客户端地址
+// 如果没有 PROXY 头或来源不可信，返回底层连接的对端地址
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.header != nil && c.header.SourceAddr != nil {
+		return c.header.SourceAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// ProxyHeader 返回解析到的 PROXY 头，没有时返回 nil
+func (c *Conn) ProxyHeader() *Header {
+	c.once.Do(c.readHeader)
+	return c.header
+}
+
+// readHeader 在连接开头解析 PROXY 头
+func (c *Conn) readHeader() {
+	if !c.trusted {
+		return
+	}
+
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	header, err := ReadHeader(c.reader)
+	switch {
+	case err == nil:
+		c.header = header
+	case errors.Is(err, ErrNoProxyHeader):
+		if c.required {
+			c.err = fmt.Errorf("proxyproto: header required from %s", c.Conn.RemoteAddr())
+		}
+	default:
+		c.err = fmt.Errorf("proxyproto: read header from %s: %w", c.Conn.RemoteAddr(), err)
+	}
+}
@@ -0,0 +1,237 @@
+// Package proxyproto 实现 HAProxy PROXY 协议 v1/v2 的解析
+// 用于服务端位于四层负载均衡之后时，还原真实的客户端地址
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrNoProxyHeader 连接开头没有 PROXY 协议头
+	ErrNoProxyHeader = errors.New("proxyproto: no proxy protocol header")
+
+	// ErrInvalidHeader PROXY 协议头格式错误
+	ErrInvalidHeader = errors.New("proxyproto: invalid proxy protocol header")
+
+	// ErrNoTrustedCIDRs 没有配置可信来源网段
+	ErrNoTrustedCIDRs = errors.New("proxyproto: trusted CIDRs required")
+)
+
+const (
+	// v1MaxLength v1 头部最大长度（含 CRLF）
+	v1MaxLength = 107
+
+	// v2HeaderLength v2 固定头部长度（签名 + 版本命令 + 地址族 + 长度）
+	v2HeaderLength = 16
+)
+
+var (
+	v1Signature = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// Command PROXY 协议命令
+type Command byte
+
+const (
+	// CommandLocal 负载均衡器自身发起的连接（如健康检查），不携带客户端地址
+	CommandLocal Command = 0x0
+	// CommandProxy 代理转发的连接，携带客户端地址
+	CommandProxy Command = 0x1
+)
+
+// Header 表示解析后的 PROXY 协议头
+type Header struct {
+	// Version 协议版本：1 或 2
+	Version int
+
+	// Command 命令类型，v1 始终为 CommandProxy
+	Command Command
+
+	// SourceAddr 真实的客户端地址，LOCAL 命令或 UNKNOWN 协议时为 nil
+	SourceAddr net.Addr
+
+	// DestAddr 负载均衡器接收连接的地址，LOCAL 命令或 UNKNOWN 协议时为 nil
+	DestAddr net.Addr
+}
+
+// ReadHeader 从 r 中读取并解析 PROXY 协议头
+// 如果连接开头不是 PROXY 协议签名，返回 ErrNoProxyHeader 且不消费任何数据
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	// 先读取 v1 签名长度的前缀用于区分版本
+	prefix, err := r.Peek(len(v1Signature))
+	if err != nil {
+		// 数据不足时，只要已读部分不是签名前缀即可判定没有 PROXY 头
+		if len(prefix) > 0 && !isPrefixOf(prefix, v1Signature, v2Signature) {
+			return nil, ErrNoProxyHeader
+		}
+		return nil, err
+	}
+
+	if bytes.Equal(prefix, v1Signature) {
+		return readV1(r)
+	}
+
+	if bytes.Equal(prefix, v2Signature[:len(prefix)]) {
+		sig, err := r.Peek(len(v2Signature))
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(sig, v2Signature) {
+			return readV2(r)
+		}
+	}
+
+	return nil, ErrNoProxyHeader
+}
+
+// isPrefixOf 判断 b 是否为任一签名的前缀
+func isPrefixOf(b []byte, signatures ...[]byte) bool {
+	for _, sig := range signatures {
+		if len(b) <= len(sig) && bytes.Equal(b, sig[:len(b)]) {
+			return true
+		}
+	}
+	return false
+}
+
+// readV1 解析文本格式的 v1 头部
+// 格式：PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: v1 header not terminated by CRLF", ErrInvalidHeader)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	header := &Header{Version: 1, Command: CommandProxy}
+
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return header, nil
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("%w: v1 header has %d fields", ErrInvalidHeader, len(fields))
+	}
+
+	srcIP := net.ParseIP(fields[2])
+	dstIP := net.ParseIP(fields[3])
+	if srcIP == nil || dstIP == nil {
+		return nil, fmt.Errorf("%w: v1 invalid address", ErrInvalidHeader)
+	}
+
+	switch fields[1] {
+	case "TCP4":
+		if srcIP.To4() == nil || dstIP.To4() == nil {
+			return nil, fmt.Errorf("%w: v1 TCP4 with non-IPv4 address", ErrInvalidHeader)
+		}
+	case "TCP6":
+		if srcIP.To4() != nil || dstIP.To4() != nil {
+			return nil, fmt.Errorf("%w: v1 TCP6 with non-IPv6 address", ErrInvalidHeader)
+		}
+	default:
+		return nil, fmt.Errorf("%w: v1 unsupported protocol %q", ErrInvalidHeader, fields[1])
+	}
+
+	srcPort, err := parsePort(fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dstPort, err := parsePort(fields[5])
+	if err != nil {
+		return nil, err
+	}
+
+	header.SourceAddr = &net.TCPAddr{IP: srcIP, Port: srcPort}
+	header.DestAddr = &net.TCPAddr{IP: dstIP, Port: dstPort}
+	return header, nil
+}
+
+// parsePort 解析 v1 头部中的端口号
+func parsePort(s string) (int, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("%w: v1 invalid port %q", ErrInvalidHeader, s)
+	}
+	return int(port), nil
+}
+
+// readV2 解析二进制格式的 v2 头部
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, v2HeaderLength)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+
+	verCmd := fixed[12]
+	if verCmd>>4 != 0x2 {
+		return nil, fmt.Errorf("%w: v2 unsupported version %d", ErrInvalidHeader, verCmd>>4)
+	}
+
+	header := &Header{Version: 2, Command: Command(verCmd & 0x0f)}
+	if header.Command != CommandLocal && header.Command != CommandProxy {
+		return nil, fmt.Errorf("%w: v2 unsupported command %d", ErrInvalidHeader, header.Command)
+	}
+
+	family := fixed[13]
+	length := int(binary.BigEndian.Uint16(fixed[14:16]))
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	// LOCAL 命令忽略地址信息
+	if header.Command == CommandLocal {
+		return header, nil
+	}
+
+	// 高 4 位为地址族，低 4 位为传输协议（1 = STREAM，2 = DGRAM）
+	switch family >> 4 {
+	case 0x1: // AF_INET
+		if length < 12 {
+			return nil, fmt.Errorf("%w: v2 IPv4 address block too short", ErrInvalidHeader)
+		}
+		header.SourceAddr, header.DestAddr = v2Addrs(family, payload[0:4], payload[4:8], payload[8:10], payload[10:12])
+	case 0x2: // AF_INET6
+		if length < 36 {
+			return nil, fmt.Errorf("%w: v2 IPv6 address block too short", ErrInvalidHeader)
+		}
+		header.SourceAddr, header.DestAddr = v2Addrs(family, payload[0:16], payload[16:32], payload[32:34], payload[34:36])
+	default:
+		// AF_UNSPEC 与 AF_UNIX 不携带可用的网络地址
+	}
+
+	return header, nil
+}
+
+// v2Addrs 根据传输协议构造地址
+func v2Addrs(family byte, srcIP, dstIP, srcPort, dstPort []byte) (net.Addr, net.Addr) {
+	src := net.IP(append([]byte(nil), srcIP...))
+	dst := net.IP(append([]byte(nil), dstIP...))
+	sp := int(binary.BigEndian.Uint16(srcPort))
+	dp := int(binary.BigEndian.Uint16(dstPort))
+
+	if family&0x0f == 0x2 {
+		return &net.UDPAddr{IP: src, Port: sp}, &net.UDPAddr{IP: dst, Port: dp}
+	}
+	return &net.TCPAddr{IP: src, Port: sp}, &net.TCPAddr{IP: dst, Port: dp}
+}
@@ -0,0 +1,189 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReadHeaderV1(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\nhello"))
+
+	header, err := ReadHeader(r)
+	if err != nil {
+		t.Fatalf("ReadHeader() error = %v", err)
+	}
+	if header.Version != 1 {
+		t.Errorf("Version = %d, want 1", header.Version)
+	}
+	if header.SourceAddr.String() != "192.168.0.1:56324" {
+		t.Errorf("SourceAddr = %v, want 192.168.0.1:56324", header.SourceAddr)
+	}
+	if header.DestAddr.String() != "10.0.0.1:443" {
+		t.Errorf("DestAddr = %v, want 10.0.0.1:443", header.DestAddr)
+	}
+
+	// 头部之后的数据应保持不变
+	rest, _ := io.ReadAll(r)
+	if string(rest) != "hello" {
+		t.Errorf("remaining data = %q, want %q", rest, "hello")
+	}
+}
+
+func TestReadHeaderV1Unknown(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PROXY UNKNOWN\r\n"))
+
+	header, err := ReadHeader(r)
+	if err != nil {
+		t.Fatalf("ReadHeader() error = %v", err)
+	}
+	if header.SourceAddr != nil {
+		t.Errorf("SourceAddr = %v, want nil", header.SourceAddr)
+	}
+}
+
+func TestReadHeaderV1Invalid(t *testing.T) {
+	tests := []string{
+		"PROXY TCP4 192.168.0.1 10.0.0.1 56324\r\n",
+		"PROXY TCP4 ::1 10.0.0.1 56324 443\r\n",
+		"PROXY TCP4 192.168.0.1 10.0.0.1 99999 443\r\n",
+		"PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\n",
+	}
+
+	for _, input := range tests {
+		_, err := ReadHeader(bufio.NewReader(strings.NewReader(input)))
+		if !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("ReadHeader(%q) error = %v, want ErrInvalidHeader", input, err)
+		}
+	}
+}
+
+func TestReadHeaderV2(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(v2Signature)
+	buf.WriteByte(0x21) // v2, PROXY
+	buf.WriteByte(0x11) // AF_INET, STREAM
+	binary.Write(&buf, binary.BigEndian, uint16(12))
+	buf.Write(net.ParseIP("203.0.113.7").To4())
+	buf.Write(net.ParseIP("10.0.0.1").To4())
+	binary.Write(&buf, binary.BigEndian, uint16(40000))
+	binary.Write(&buf, binary.BigEndian, uint16(9090))
+	buf.WriteString("data")
+
+	r := bufio.NewReader(&buf)
+	header, err := ReadHeader(r)
+	if err != nil {
+		t.Fatalf("ReadHeader() error = %v", err)
+	}
+	if header.Version != 2 || header.Command != CommandProxy {
+		t.Errorf("Version/Command = %d/%d, want 2/%d", header.Version, header.Command, CommandProxy)
+	}
+	if header.SourceAddr.String() != "203.0.113.7:40000" {
+		t.Errorf("SourceAddr = %v, want 203.0.113.7:40000", header.SourceAddr)
+	}
+
+	rest, _ := io.ReadAll(r)
+	if string(rest) != "data" {
+		t.Errorf("remaining data = %q, want %q", rest, "data")
+	}
+}
+
+func TestReadHeaderV2Local(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(v2Signature)
+	buf.WriteByte(0x20) // v2, LOCAL
+	buf.WriteByte(0x00)
+	binary.Write(&buf, binary.BigEndian, uint16(0))
+
+	header, err := ReadHeader(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("ReadHeader() error = %v", err)
+	}
+	if header.Command != CommandLocal || header.SourceAddr != nil {
+		t.Errorf("header = %+v, want LOCAL without address", header)
+	}
+}
+
+func TestReadHeaderNoHeader(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("\x16\x03\x01\x02\x00\x01"))
+
+	_, err := ReadHeader(r)
+	if !errors.Is(err, ErrNoProxyHeader) {
+		t.Fatalf("ReadHeader() error = %v, want ErrNoProxyHeader", err)
+	}
+
+	// 没有 PROXY 头时不应消费数据
+	if r.Buffered() != 6 {
+		t.Errorf("Buffered() = %d, want 6", r.Buffered())
+	}
+}
+
+func TestListenerTrustedSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted []string
+		want    string
+	}{
+		{"trusted", []string{"127.0.0.0/8"}, "198.51.100.9:1234"},
+		{"untrusted", []string{"10.0.0.0/8"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Listen() error = %v", err)
+			}
+			ln, err := NewListener(raw, &Config{TrustedCIDRs: tt.trusted})
+			if err != nil {
+				t.Fatalf("NewListener() error = %v", err)
+			}
+			defer ln.Close()
+
+			go func() {
+				c, err := net.Dial("tcp", ln.Addr().String())
+				if err != nil {
+					return
+				}
+				defer c.Close()
+				c.Write([]byte("PROXY TCP4 198.51.100.9 10.0.0.1 1234 9090\r\nping"))
+			}()
+
+			conn, err := ln.Accept()
+			if err != nil {
+				t.Fatalf("Accept() error = %v", err)
+			}
+			defer conn.Close()
+
+			got := conn.RemoteAddr().String()
+			if tt.want != "" && got != tt.want {
+				t.Errorf("RemoteAddr() = %v, want %v", got, tt.want)
+			}
+			if tt.want == "" && strings.HasPrefix(got, "198.51.100.9") {
+				t.Errorf("RemoteAddr() = %v, untrusted source should not be rewritten", got)
+			}
+		})
+	}
+}
+
+func TestNewListenerInvalidCIDR(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer raw.Close()
+
+	if _, err := NewListener(raw, &Config{TrustedCIDRs: []string{"not-a-cidr"}}); err == nil {
+		t.Error("NewListener() with invalid CIDR should fail")
+	}
+	for _, config := range []*Config{nil, {Required: true}} {
+		if _, err := NewListener(raw, config); !errors.Is(err, ErrNoTrustedCIDRs) {
+			t.Errorf("NewListener(%+v) error = %v, want ErrNoTrustedCIDRs", config, err)
+		}
+	}
+}
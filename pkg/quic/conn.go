@@ -1,17 +1,32 @@
 package quic
 
 import (
+	"errors"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/funcx27/qymux/pkg/transport"
+	"github.com/funcx27/qymux/pkg/utils"
 	"github.com/quic-go/quic-go"
 )
 
-// Conn 将 quic.Stream 封装为 net.Conn
+// Conn 将 quic.Stream 封装为 transport.Stream
 type Conn struct {
 	stream     *quic.Stream
 	localAddr  net.Addr
 	remoteAddr net.Addr
+	release    func() // 首次关闭时归还会话的并发流名额
+	once       sync.Once
+	writeOnce  sync.Once
+	writeErr   error
+	readClose  utils.ReadShutdown
+
+	reset        atomic.Pointer[transport.StreamError]
+	openedAt     time.Time
+	bytesRead    atomic.Uint64
+	bytesWritten atomic.Uint64
 }
 
 // NewConn 创建新的 QUIC 连接封装
@@ -20,22 +35,110 @@ func NewConn(stream *quic.Stream, localAddr, remoteAddr net.Addr) *Conn {
 		stream:     stream,
 		localAddr:  localAddr,
 		remoteAddr: remoteAddr,
+		openedAt:   time.Now(),
 	}
 }
 
-// Read 从流中读取数据
+// ID 返回 QUIC 流 ID
+func (c *Conn) ID() uint64 {
+	return uint64(c.stream.StreamID())
+}
+
+// Read 从流中读取数据，读方向关闭后返回 net.ErrClosed，流被重置后返回 *transport.StreamError
 func (c *Conn) Read(b []byte) (n int, err error) {
-	return c.stream.Read(b)
+	if err := c.reset.Load(); err != nil {
+		return 0, err
+	}
+
+	n, err = c.readClose.Read(c.stream, b)
+	c.bytesRead.Add(uint64(n))
+	return n, c.streamError(err)
 }
 
-// Write 向流中写入数据
+// Write 向流中写入数据，流被重置后返回 *transport.StreamError
 func (c *Conn) Write(b []byte) (n int, err error) {
-	return c.stream.Write(b)
+	if err := c.reset.Load(); err != nil {
+		return 0, err
+	}
+
+	n, err = c.stream.Write(b)
+	c.bytesWritten.Add(uint64(n))
+	return n, c.streamError(err)
+}
+
+// streamError 将 QUIC 的流错误转换为 *transport.StreamError
+func (c *Conn) streamError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if resetErr := c.reset.Load(); resetErr != nil {
+		return resetErr
+	}
+
+	var streamErr *quic.StreamError
+	if errors.As(err, &streamErr) {
+		return &transport.StreamError{
+			Code:   transport.ErrorCode(streamErr.ErrorCode),
+			Remote: streamErr.Remote,
+		}
+	}
+	return err
 }
 
-// Close 关闭流
+// Close 关闭流的读写两个方向，语义见 transport.Stream
 func (c *Conn) Close() error {
-	return c.stream.Close()
+	var err error
+	if c.reset.Load() == nil {
+		err = c.CloseWrite()
+		c.CloseRead()
+	}
+	c.once.Do(func() {
+		if c.release != nil {
+			c.release()
+		}
+	})
+	return err
+}
+
+// CloseWrite 关闭写方向（发送 FIN），对端读取到 EOF，本端仍可继续读取
+func (c *Conn) CloseWrite() error {
+	if c.reset.Load() != nil {
+		return nil
+	}
+	c.writeOnce.Do(func() {
+		c.writeErr = c.stream.Close()
+	})
+	return c.writeErr
+}
+
+// CloseRead 关闭读方向，本端后续读取返回 net.ErrClosed，对端发送的数据被丢弃
+// 对端继续发送的数据超出丢弃上限时以 transport.CodeCancelled 中止读方向（STOP_SENDING），对端的写入随之失败
+func (c *Conn) CloseRead() error {
+	c.readClose.ShutdownFunc(c.stream, func(err error) {
+		if err != nil {
+			c.stream.CancelRead(quic.StreamErrorCode(transport.CodeCancelled))
+		}
+	})
+	return nil
+}
+
+// Reset 以 code 中止流的两个方向（RESET_STREAM 与 STOP_SENDING）
+func (c *Conn) Reset(code transport.ErrorCode) error {
+	if !c.reset.CompareAndSwap(nil, &transport.StreamError{Code: code}) {
+		return nil
+	}
+	c.stream.CancelWrite(quic.StreamErrorCode(code))
+	c.stream.CancelRead(quic.StreamErrorCode(code))
+	return nil
+}
+
+// Stats 返回流的统计信息
+func (c *Conn) Stats() transport.StreamStats {
+	return transport.StreamStats{
+		OpenedAt:     c.openedAt,
+		BytesRead:    c.bytesRead.Load(),
+		BytesWritten: c.bytesWritten.Load(),
+	}
 }
 
 // LocalAddr 返回本地地址
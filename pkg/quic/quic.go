@@ -3,55 +3,138 @@ package quic
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"io"
+	"log"
 	"net"
+	"sync/atomic"
 
+	"github.com/funcx27/qymux/pkg/admission"
+	"github.com/funcx27/qymux/pkg/streamlimit"
 	tlsconfig "github.com/funcx27/qymux/pkg/tls"
 	"github.com/funcx27/qymux/pkg/transport"
+	"github.com/funcx27/qymux/pkg/utils"
 	"github.com/quic-go/quic-go"
 )
 
-// Session 实现 transport.MuxSession 接口
+// Session 实现 transport.MuxSession 和 transport.DatagramSession 接口
 type Session struct {
 	conn       *quic.Conn
 	localAddr  net.Addr
 	remoteAddr net.Addr
+	acceptor   *streamlimit.Acceptor
+
+	maxDatagram atomic.Int64 // SendDatagram 观察到的数据报上限，0 表示尚未观察到
 }
 
 // NewSession 创建新的 QUIC 会话适配器
 func NewSession(conn *quic.Conn, localAddr, remoteAddr net.Addr) *Session {
-	return &Session{
+	return newSession(conn, localAddr, remoteAddr, nil)
+}
+
+// newSession 创建会话适配器，并按 opts 限制入站流
+func newSession(conn *quic.Conn, localAddr, remoteAddr net.Addr, opts *transport.SessionOptions) *Session {
+	s := &Session{
 		conn:       conn,
 		localAddr:  localAddr,
 		remoteAddr: remoteAddr,
 	}
+	if conn != nil {
+		s.acceptor = streamlimit.NewAcceptor(opts, s.acceptStream, rejectStream, conn.Context().Done())
+	}
+	return s
 }
 
 // Accept 接受来自对端的虚拟流
 func (s *Session) Accept() (net.Conn, error) {
+	return s.AcceptStream()
+}
+
+// AcceptStream 接受来自对端的虚拟流
+func (s *Session) AcceptStream() (transport.Stream, error) {
+	conn, release, err := s.acceptor.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	c := conn.(*Conn)
+	c.release = release
+	return c, nil
+}
+
+// acceptStream 从 QUIC 连接接收下一个流
+func (s *Session) acceptStream() (net.Conn, error) {
 	stream, err := s.conn.AcceptStream(s.context())
 	if err != nil {
 		return nil, err
 	}
+	return NewConn(stream, s.localAddr, s.remoteAddr), nil
+}
 
-	return &Conn{
-		stream:    stream,
-		localAddr:  s.localAddr,
-		remoteAddr: s.remoteAddr,
-	}, nil
+// rejectStream 以 transport.CodeRejected 重置超出限制的流
+func rejectStream(conn net.Conn) {
+	conn.(*Conn).Reset(transport.CodeRejected)
 }
 
 // OpenStream 发起一个新的虚拟流
-func (s *Session) OpenStream() (net.Conn, error) {
+func (s *Session) OpenStream() (transport.Stream, error) {
 	stream, err := s.conn.OpenStreamSync(s.context())
 	if err != nil {
 		return nil, err
 	}
 
-	return &Conn{
-		stream:    stream,
-		localAddr:  s.localAddr,
-		remoteAddr: s.remoteAddr,
-	}, nil
+	return NewConn(stream, s.localAddr, s.remoteAddr), nil
+}
+
+// SendDatagram 发送一个不可靠的 QUIC 数据报 (RFC 9221)，实现 transport.DatagramSession
+// 数据报不保证送达和顺序，超过当前路径允许的大小时返回 *quic.DatagramTooLargeError
+func (s *Session) SendDatagram(b []byte) error {
+	err := s.conn.SendDatagram(b)
+	var tooLarge *quic.DatagramTooLargeError
+	switch {
+	case errors.As(err, &tooLarge):
+		s.maxDatagram.Store(tooLarge.MaxDatagramPayloadSize)
+	case err == nil && len(b) > s.datagramLimit():
+		s.maxDatagram.Store(int64(len(b)))
+	}
+	return err
+}
+
+// ReceiveDatagram 接收对端发送的数据报，会话关闭时返回错误
+// 接收队列已满时新到达的数据报被丢弃
+func (s *Session) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	return s.conn.ReceiveDatagram(ctx)
+}
+
+// minDatagramSize 在所有 QUIC 路径上都可以发送的数据报长度：
+// RFC 9000 要求路径至少支持 1200 字节的 UDP 载荷，扣除短包头、包号、AEAD 标签和 DATAGRAM 帧头后留有余量
+const minDatagramSize = 1150
+
+// MaxDatagramSize 返回当前可发送的最大数据报长度，对端未启用数据报时返回 0
+// quic-go 没有暴露上限，这里缓存 SendDatagram 观察到的值：初始为 minDatagramSize，
+// 发送超长时按错误中携带的上限更新，更长的数据报发送成功（MTU 探测成功后）时随之增大
+func (s *Session) MaxDatagramSize() int {
+	state := s.conn.ConnectionState().SupportsDatagrams
+	if !state.Local || !state.Remote {
+		return 0
+	}
+	return s.datagramLimit()
+}
+
+// datagramLimit 返回缓存的数据报上限，尚未观察到时返回 minDatagramSize
+func (s *Session) datagramLimit() int {
+	if max := s.maxDatagram.Load(); max > 0 {
+		return int(max)
+	}
+	return minDatagramSize
+}
+
+// RejectedStreams 返回因超出限制而被拒绝的入站流数量
+func (s *Session) RejectedStreams() uint64 {
+	if s.acceptor == nil {
+		return 0
+	}
+	return s.acceptor.Rejected()
 }
 
 // Protocol 返回协议类型
@@ -59,6 +142,14 @@ func (s *Session) Protocol() string {
 	return "QUIC"
 }
 
+// Done 返回会话关闭时关闭的 channel
+func (s *Session) Done() <-chan struct{} {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Context().Done()
+}
+
 // Close 关闭会话
 func (s *Session) Close() error {
 	return s.conn.CloseWithError(0, "")
@@ -69,6 +160,11 @@ func (s *Session) Addr() net.Addr {
 	return s.localAddr
 }
 
+// RemoteAddr 返回对端地址
+func (s *Session) RemoteAddr() net.Addr {
+	return s.remoteAddr
+}
+
 // context 返回默认上下文
 func (s *Session) context() context.Context {
 	return context.Background()
@@ -76,17 +172,24 @@ func (s *Session) context() context.Context {
 
 // Dialer 实现 QUIC 拨号器
 type Dialer struct {
-	tlsConfig *tls.Config
-	config    *quic.Config
+	tlsConfig   *tls.Config
+	config      *quic.Config
+	sessionOpts *transport.SessionOptions
 }
 
 // NewDialer 创建新的 QUIC 拨号器
 func NewDialer(tlsConfig *tls.Config) *Dialer {
+	return NewDialerWithOptions(tlsConfig, nil)
+}
+
+// NewDialerWithOptions 使用会话配置创建 QUIC 拨号器
+func NewDialerWithOptions(tlsConfig *tls.Config, sessionOpts *transport.SessionOptions) *Dialer {
 	tlsConfig = tlsconfig.EnsureClientTLSConfig(tlsConfig)
 
 	return &Dialer{
-		tlsConfig: tlsConfig,
-		config:    &quic.Config{},
+		tlsConfig:   tlsConfig,
+		config:      quicConfig(sessionOpts),
+		sessionOpts: sessionOpts,
 	}
 }
 
@@ -99,24 +202,32 @@ func (d *Dialer) Dial(target string) (transport.MuxSession, error) {
 	}
 
 	// 返回适配的会话
-	return NewSession(quicConn, quicConn.LocalAddr(), quicConn.RemoteAddr()), nil
+	return newSession(quicConn, quicConn.LocalAddr(), quicConn.RemoteAddr(), d.sessionOpts), nil
 }
 
 // Listener 实现 QUIC 监听器
 type Listener struct {
-	ln         *quic.Listener
-	localAddr  net.Addr
-	acceptChan chan *Session
-	errChan    chan error
+	ln          *quic.Listener
+	localAddr   net.Addr
+	sessionOpts *transport.SessionOptions
+	acceptChan  chan *Session
+	errChan     chan error
+	closers     []io.Closer // 监听器关闭后需要一并关闭的底层资源
 }
 
 // NewListener 创建新的 QUIC 监听器
 func NewListener(ln *quic.Listener, localAddr net.Addr) *Listener {
+	return newListener(ln, localAddr, nil)
+}
+
+// newListener 创建监听器并启动接受 goroutine
+func newListener(ln *quic.Listener, localAddr net.Addr, sessionOpts *transport.SessionOptions) *Listener {
 	l := &Listener{
-		ln:         ln,
-		localAddr:  localAddr,
-		acceptChan: make(chan *Session, 10),
-		errChan:    make(chan error, 1),
+		ln:          ln,
+		localAddr:   localAddr,
+		sessionOpts: sessionOpts,
+		acceptChan:  make(chan *Session, 10),
+		errChan:     make(chan error, 1),
 	}
 
 	// 启动接受 goroutine
@@ -134,7 +245,12 @@ func (l *Listener) acceptLoop() {
 			return
 		}
 
-		session := NewSession(conn, l.localAddr, conn.RemoteAddr())
+		// 握手已完成，归还准入控制的握手名额
+		if ticket, ok := conn.Context().Value(ticketKey{}).(*admission.Ticket); ok {
+			ticket.HandshakeDone()
+		}
+
+		session := newSession(conn, l.localAddr, conn.RemoteAddr(), l.sessionOpts)
 		l.acceptChan <- session
 	}
 }
@@ -151,7 +267,11 @@ func (l *Listener) Accept() (transport.MuxSession, error) {
 
 // Close 关闭监听器
 func (l *Listener) Close() error {
-	return l.ln.Close()
+	err := l.ln.Close()
+	if closeErr := utils.CloseAll(l.closers...); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
 // Addr 返回监听地址
@@ -161,16 +281,67 @@ func (l *Listener) Addr() net.Addr {
 
 // Listen 创建 QUIC 监听器
 func Listen(addr string, tlsConfig *tls.Config) (*Listener, error) {
+	return ListenWithOptions(addr, tlsConfig, nil, nil)
+}
+
+// ListenWithOptions 使用可选配置创建 QUIC 监听器
+func ListenWithOptions(addr string, tlsConfig *tls.Config, opts *transport.ListenerOptions, sessionOpts *transport.SessionOptions) (*Listener, error) {
 	var err error
 	tlsConfig, err = tlsconfig.EnsureServerTLSConfig(tlsConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	ln, err := quic.ListenAddr(addr, tlsConfig, &quic.Config{})
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, err := net.ListenUDP("udp", udpAddr)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewListener(ln, ln.Addr()), nil
+	tr := &quic.Transport{Conn: udpConn}
+	if opts != nil && opts.Admission != nil {
+		tr.ConnContext = admissionConnContext(opts.Admission)
+	}
+
+	ln, err := tr.Listen(tlsConfig, quicConfig(sessionOpts))
+	if err != nil {
+		utils.CloseAll(tr, udpConn)
+		return nil, err
+	}
+
+	l := newListener(ln, ln.Addr(), sessionOpts)
+	l.closers = []io.Closer{tr, udpConn}
+	return l, nil
+}
+
+// ticketKey 连接上下文中保存准入名额的键
+type ticketKey struct{}
+
+// admissionConnContext 返回在 QUIC 握手之前执行准入检查的回调
+// 名额在连接关闭或握手失败时自动归还
+func admissionConnContext(controller *admission.Controller) func(context.Context, *quic.ClientInfo) (context.Context, error) {
+	return func(ctx context.Context, info *quic.ClientInfo) (context.Context, error) {
+		ticket, err := controller.Admit(info.RemoteAddr)
+		if err != nil {
+			log.Printf("[Qymux-QUIC] 拒绝来自 %s 的连接: %v", info.RemoteAddr, err)
+			return nil, err
+		}
+
+		context.AfterFunc(ctx, ticket.Release)
+		return context.WithValue(ctx, ticketKey{}, ticket), nil
+	}
+}
+
+// quicConfig 根据会话配置返回 QUIC 配置
+// MaxIncomingStreams 同时交给 quic-go 执行，使对端在 BacklogBlock 模式下因流控而阻塞；
+// 总是启用数据报 (RFC 9221)，双方都启用时才可以使用
+func quicConfig(opts *transport.SessionOptions) *quic.Config {
+	config := &quic.Config{EnableDatagrams: true}
+	if opts != nil && opts.MaxIncomingStreams > 0 {
+		config.MaxIncomingStreams = int64(opts.MaxIncomingStreams)
+	}
+	return config
 }
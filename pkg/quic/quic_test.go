@@ -1,7 +1,16 @@
 package quic
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net"
 	"testing"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/admission"
+	"github.com/funcx27/qymux/pkg/transport"
+	"github.com/quic-go/quic-go"
 )
 
 func TestNewSession(t *testing.T) {
@@ -33,3 +42,237 @@ func TestSessionImplementsMuxSession(t *testing.T) {
 	_ = session.Protocol()
 	_ = session.Addr()
 }
+
+func TestListenWithAdmission(t *testing.T) {
+	controller, err := admission.NewController(&admission.Config{MaxSessions: 1})
+	if err != nil {
+		t.Fatalf("NewController() error = %v", err)
+	}
+
+	ln, err := ListenWithOptions("127.0.0.1:0", nil, &transport.ListenerOptions{Admission: controller}, nil)
+	if err != nil {
+		t.Fatalf("ListenWithOptions() error = %v", err)
+	}
+	defer ln.Close()
+
+	client, err := NewDialer(nil).Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer client.Close()
+
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer server.Close()
+
+	stats := controller.Stats()
+	if stats.Accepted != 1 || stats.ActiveSessions != 1 || stats.PendingHandshakes != 0 {
+		t.Errorf("Stats() = %+v, want Accepted=1 ActiveSessions=1 PendingHandshakes=0", stats)
+	}
+
+	// 会话数已达上限，新连接被拒绝
+	if _, err := NewDialer(nil).Dial(ln.Addr().String()); err == nil {
+		t.Error("Dial() beyond MaxSessions should fail")
+	}
+}
+
+func TestConnHalfClose(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+
+	client, err := NewDialer(nil).Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer client.Close()
+
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer server.Close()
+
+	conn, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("request"))
+	conn.(transport.Stream).CloseWrite()
+
+	peer, err := server.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer peer.Close()
+
+	data, err := io.ReadAll(peer)
+	if err != nil || string(data) != "request" {
+		t.Fatalf("ReadAll() = %q, %v; want %q", data, err, "request")
+	}
+	peer.Write([]byte("response"))
+
+	// 关闭读方向后本端读取立即失败，对端写入不受影响
+	peer.(transport.Stream).CloseRead()
+	if _, err := peer.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Read() after CloseRead error = %v, want net.ErrClosed", err)
+	}
+	peer.(transport.Stream).CloseWrite()
+
+	data, err = io.ReadAll(conn)
+	if err != nil || string(data) != "response" {
+		t.Errorf("ReadAll() = %q, %v; want %q", data, err, "response")
+	}
+}
+
+func TestConnCloseRead(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+
+	client, err := NewDialer(nil).Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer client.Close()
+
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer server.Close()
+
+	conn, _ := client.OpenStream()
+	defer conn.Close()
+	conn.Write([]byte("x"))
+
+	peer, err := server.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer peer.Close()
+	conn.(transport.Stream).CloseRead()
+
+	// 对端写入的数据先被丢弃，超出丢弃上限后读方向被中止，对端的写入失败
+	var streamErr *transport.StreamError
+	buf := make([]byte, 64<<10)
+	for i := 0; i < 256; i++ {
+		if _, err := peer.Write(buf); err != nil {
+			if !errors.As(err, &streamErr) || streamErr.Code != transport.CodeCancelled || !streamErr.Remote {
+				t.Errorf("peer Write() error = %v, want remote stop with CodeCancelled", err)
+			}
+			return
+		}
+	}
+	t.Error("peer Write() beyond drain limit should fail")
+}
+
+func TestConnReset(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+
+	client, err := NewDialer(nil).Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer client.Close()
+
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer server.Close()
+
+	conn, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("request"))
+
+	peer, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("AcceptStream() error = %v", err)
+	}
+	defer peer.Close()
+	if peer.ID() != conn.ID() {
+		t.Errorf("ID() = %d, want %d", peer.ID(), conn.ID())
+	}
+	io.ReadFull(peer, make([]byte, 7))
+
+	const code = transport.CodeApplication + 7
+	conn.Reset(code)
+
+	var streamErr *transport.StreamError
+	if _, err := peer.Read(make([]byte, 1)); !errors.As(err, &streamErr) || streamErr.Code != code || !streamErr.Remote {
+		t.Errorf("peer Read() error = %v, want remote reset with code %#x", err, code)
+	}
+	if _, err := conn.Write([]byte("x")); !errors.As(err, &streamErr) || streamErr.Remote {
+		t.Errorf("Write() after Reset error = %v, want local reset", err)
+	}
+	if stats := conn.Stats(); stats.BytesWritten != 7 {
+		t.Errorf("Stats().BytesWritten = %d, want 7", stats.BytesWritten)
+	}
+}
+
+func TestSessionDatagram(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+
+	dialed, err := NewDialer(nil).Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer dialed.Close()
+	accepted, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer accepted.Close()
+
+	client, server := dialed.(*Session), accepted.(*Session)
+	var _ transport.DatagramSession = client
+	max := client.MaxDatagramSize()
+	if max < 1000 || max > 1500 {
+		t.Fatalf("MaxDatagramSize() = %d, want about 1200", max)
+	}
+	if err := client.SendDatagram(make([]byte, max)); err != nil {
+		t.Errorf("SendDatagram(%d bytes) error = %v", max, err)
+	}
+
+	// 超长的数据报返回当前上限，MaxDatagramSize 随之更新
+	var tooLarge *quic.DatagramTooLargeError
+	if err := client.SendDatagram(make([]byte, 1<<16)); !errors.As(err, &tooLarge) {
+		t.Fatalf("SendDatagram(64 KiB) error = %v, want DatagramTooLargeError", err)
+	}
+	if max := client.MaxDatagramSize(); max != int(tooLarge.MaxDatagramPayloadSize) || max < minDatagramSize {
+		t.Errorf("MaxDatagramSize() after DatagramTooLargeError = %d, want %d", max, tooLarge.MaxDatagramPayloadSize)
+	}
+	if err := client.SendDatagram([]byte("telemetry")); err != nil {
+		t.Fatalf("SendDatagram() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// 先到达的是之前发送的最大长度的数据报
+	data, err := server.ReceiveDatagram(ctx)
+	if err == nil && len(data) == max {
+		data, err = server.ReceiveDatagram(ctx)
+	}
+	if err != nil || string(data) != "telemetry" {
+		t.Errorf("ReceiveDatagram() = %.20q, %v; want %q", data, err, "telemetry")
+	}
+}
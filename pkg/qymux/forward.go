@@ -0,0 +1,40 @@
+package qymux
+
+import (
+	"github.com/funcx27/qymux/pkg/forward"
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// ForwardServer 处理对端的端口转发请求，详见 forward.Server
+type ForwardServer = forward.Server
+
+// NewForwardServer 创建端口转发服务端，之后调用 Serve(sess) 在会话上处理请求
+func NewForwardServer(opts *forward.ServerOptions) *ForwardServer {
+	return forward.NewServer(opts)
+}
+
+// ForwardLocal 在本地 listenAddr 上监听，连接经由对端拨号到 target（-L）
+func ForwardLocal(sess transport.MuxSession, listenAddr, target string) (*forward.Forward, error) {
+	return forward.Local(sess, listenAddr, target)
+}
+
+// ForwardLocalUDP 在本地 listenAddr 上监听 UDP，包经由对端转发到 target
+func ForwardLocalUDP(sess transport.MuxSession, listenAddr, target string) (*forward.Forward, error) {
+	return forward.LocalUDP(sess, listenAddr, target)
+}
+
+// DatagramMux 为会话上的 UDP 转发分发数据报，详见 forward.DatagramMux
+type DatagramMux = forward.DatagramMux
+
+// NewDatagramMux 创建会话的数据报分发器，它接管会话的 ReceiveDatagram
+func NewDatagramMux(sess transport.MuxSession) *DatagramMux {
+	return forward.NewDatagramMux(sess)
+}
+
+// SessionDialer 请求对端拨号目标，可用作 http.Transport、数据库驱动等的 DialContext，详见 forward.SessionDialer
+type SessionDialer = forward.SessionDialer
+
+// NewSessionDialer 创建经由 sess 拨号的 SessionDialer，对端需要运行 ForwardServer
+func NewSessionDialer(sess transport.MuxSession) *SessionDialer {
+	return forward.NewSessionDialer(sess, 0)
+}
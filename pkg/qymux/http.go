@@ -2,170 +2,658 @@ package qymux
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httputil"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/funcx27/qymux/pkg/transport"
 )
 
+// HTTPOptions 定义 HTTP 隧道的可选配置
+type HTTPOptions struct {
+	// HTTP2 为 true 时客户端使用 HTTP/2 (h2c)，多个并发请求复用连接池中的少量流，
+	// 由 HTTP/2 提供流控和 trailer 支持；为 false 时每个请求使用一个独立的流 (HTTP/1.1)
+	// 服务端自动识别两种协议，无需配置
+	HTTP2 bool
+
+	// MaxConns HTTP/2 模式下客户端最多同时使用的流数，0 表示不限制
+	// 已建立的流上并发请求达到服务端的 MaxConcurrentStreams 后才会打开新的流，
+	// 但连接池为空时同时到达的请求可能各自打开一个流
+	MaxConns int
+
+	// MaxConcurrentStreams 服务端每个 HTTP/2 连接允许的并发请求数，0 表示默认值 (250)
+	MaxConcurrentStreams int
+
+	// IdleTimeout 流的空闲超时：客户端连接池中的流空闲超过该时间后关闭，
+	// 服务端在同一个流上等待下一个请求超过该时间后关闭流；0 表示默认值 (90s)
+	IdleTimeout time.Duration
+
+	// BodyIdleTimeout 客户端读取响应体时连续无数据的最长时间，超过后中止请求并返回 ErrBodyIdleTimeout
+	// 0 表示不限制，适合 Watch、日志跟踪等长时间运行的流式响应
+	BodyIdleTimeout time.Duration
+
+	// Upstream 客户端请求的默认上游名称，通过 HTTPUpstreamHeader 发送，请求中已设置该头时不覆盖
+	Upstream string
+
+	// Router 服务端的多上游路由，为 nil 时所有请求转发到 targetURL
+	// 设置后 targetURL 作为没有规则匹配时的默认上游，可为空
+	Router *HTTPRouter
+
+	// UpstreamTransport 服务端连接上游使用的 http.RoundTripper，可由 NewUpstreamTransport 创建
+	// 为 nil 时使用 NewUpstreamTransport(nil)，不读取代理环境变量；规则中设置的 Transport 优先
+	UpstreamTransport http.RoundTripper
+
+	// Agent 访问日志中记录的 Agent 名称
+	Agent string
+
+	// AccessLog 访问日志，可由 NewJSONAccessLogger 或 NewCommonAccessLogger 创建
+	// 客户端为 nil 时不记录；服务端为 nil 时以 "[Qymux-HTTP] METHOD PATH -> STATUS" 格式写入标准日志
+	AccessLog AccessLogger
+}
+
+const (
+	// defaultHTTPIdleTimeout 默认的流空闲超时
+	defaultHTTPIdleTimeout = 90 * time.Second
+
+	// httpReadHeaderTimeout 服务端读取请求头的超时时间
+	httpReadHeaderTimeout = 30 * time.Second
+)
+
+// ErrBodyIdleTimeout 响应体连续无数据的时间超过 BodyIdleTimeout
+var ErrBodyIdleTimeout = errors.New("qymux: http response body idle timeout")
+
+// idleTimeout 返回配置的流空闲超时
+func (o *HTTPOptions) idleTimeout() time.Duration {
+	if o == nil || o.IdleTimeout <= 0 {
+		return defaultHTTPIdleTimeout
+	}
+	return o.IdleTimeout
+}
+
+// upstreamTransport 返回配置的上游 RoundTripper
+func (o *HTTPOptions) upstreamTransport() http.RoundTripper {
+	if o == nil {
+		return nil
+	}
+	return o.UpstreamTransport
+}
+
+// accessLog 返回配置的访问日志和 Agent 名称
+func (o *HTTPOptions) accessLog() (AccessLogger, string) {
+	if o == nil {
+		return nil, ""
+	}
+	return o.AccessLog, o.Agent
+}
+
+// bodyIdleTimeout 返回配置的响应体空闲超时
+func (o *HTTPOptions) bodyIdleTimeout() time.Duration {
+	if o == nil {
+		return 0
+	}
+	return o.BodyIdleTimeout
+}
+
 // DialHTTP 创建 HTTP 客户端，通过 MuxSession 发送 HTTP 请求
 // 返回的 http.Client 会将所有请求通过 Session 上的虚拟流发送
 func DialHTTP(sess transport.MuxSession, targetURL string) (*http.Client, error) {
-	// 解析目标 URL
-	target, err := url.Parse(targetURL)
-	if err != nil {
-		return nil, fmt.Errorf("parse target URL failed: %w", err)
-	}
+	return DialHTTPWithOptions(sess, targetURL, nil)
+}
 
-	// 创建自定义 Transport
-	transport := &sessionTransport{
-		session: sess,
-		target:  target,
+// DialHTTPWithOptions 使用可选配置创建 HTTP 客户端
+func DialHTTPWithOptions(sess transport.MuxSession, targetURL string, opts *HTTPOptions) (*http.Client, error) {
+	transport, err := GetHTTPDialerWithOptions(sess, targetURL, opts)
+	if err != nil {
+		return nil, err
 	}
 
 	return &http.Client{
 		Transport: transport,
-		// 移除 Timeout 以支持长连接（如 Watch）
-		// 依赖底层 Stream 的 deadline 控制
+		// 不设置 Timeout 以支持长连接（如 Watch）
+		// 超时由请求的 context 和 HTTPOptions.BodyIdleTimeout 控制
 	}, nil
 }
 
 // sessionTransport 实现 http.RoundTripper 接口
 // 它将 HTTP 请求通过 Session 的虚拟流发送
 type sessionTransport struct {
-	session transport.MuxSession
-	target  *url.URL
-	mu      sync.Mutex
+	session         transport.MuxSession
+	target          *url.URL
+	h2              *http.Transport // HTTP/2 模式下复用流的连接池
+	bodyIdleTimeout time.Duration
+	upstream        string
+	accessLog       AccessLogger
+	agent           string
+	mu              sync.Mutex
+}
+
+// newSessionTransport 创建 sessionTransport，HTTP/2 模式下同时创建连接池
+func newSessionTransport(sess transport.MuxSession, target *url.URL, opts *HTTPOptions) *sessionTransport {
+	t := &sessionTransport{
+		session:         sess,
+		target:          target,
+		bodyIdleTimeout: opts.bodyIdleTimeout(),
+	}
+	t.accessLog, t.agent = opts.accessLog()
+	if opts != nil {
+		t.upstream = opts.Upstream
+	}
+	if opts != nil && opts.HTTP2 {
+		protocols := new(http.Protocols)
+		protocols.SetUnencryptedHTTP2(true)
+
+		t.h2 = &http.Transport{
+			// 连接池中的每个连接都是 Session 上的一个流
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return sess.OpenStream()
+			},
+			Protocols:       protocols,
+			MaxConnsPerHost: opts.MaxConns,
+			IdleConnTimeout: opts.idleTimeout(),
+		}
+	}
+	return t
 }
 
 // RoundTrip 执行 HTTP 请求
+// 流在响应体关闭时才释放；请求的 context 被取消或超时时重置流，中断正在进行的读写
+//
+// Upgrade 请求（WebSocket、SPDY 等）总是使用独立的 HTTP/1.1 流，
+// 收到 101 Switching Protocols 时响应体实现 io.ReadWriteCloser，直接读写升级后的原始字节流
+//
+// 配置了 AccessLog 时，请求失败或升级成功时立即记录，其余请求在响应体读取完毕或关闭时记录
 func (t *sessionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	// 1. 修改请求的 URL，指向目标服务器
-	req.URL.Scheme = t.target.Scheme
-	req.URL.Host = t.target.Host
+	if t.upstream != "" && req.Header.Get(HTTPUpstreamHeader) == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(HTTPUpstreamHeader, t.upstream)
+	}
+
+	var entry *AccessLogEntry
+	var reqBody *countingReader
+	if t.accessLog != nil {
+		entry = newAccessLogEntry(AccessLogClient, t.agent, req)
+		if req.Body != nil && req.Body != http.NoBody {
+			reqBody = &countingReader{ReadCloser: req.Body}
+			req = req.Clone(req.Context())
+			req.Body = reqBody
+		}
+	}
+
+	ctx, cancel := context.WithCancelCause(req.Context())
+
+	var resp *http.Response
+	var err error
+	if t.h2 != nil && !isUpgradeRequest(req) {
+		resp, err = t.roundTripHTTP2(ctx, req)
+	} else {
+		resp, err = t.roundTripHTTP1(ctx, req)
+	}
+	if err != nil {
+		cancel(err)
+		if entry != nil {
+			logClientAccess(t.accessLog, entry, reqBody, err)
+		}
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		// 升级后的连接不受 BodyIdleTimeout 约束，关闭时释放 context
+		resp.Body = &upgradedBody{upgradedConn: resp.Body.(*upgradedConn), cancel: cancel}
+		if entry != nil {
+			entry.Status = resp.StatusCode
+			logClientAccess(t.accessLog, entry, reqBody, nil)
+		}
+		return resp, nil
+	}
+
+	resp.Body = newTunnelBody(ctx, cancel, resp.Body, t.bodyIdleTimeout)
+	if entry != nil {
+		entry.Status = resp.StatusCode
+		resp.Body = &loggedBody{ReadCloser: resp.Body, entry: entry, reqBody: reqBody, logger: t.accessLog}
+	}
+	return resp, nil
+}
+
+// isUpgradeRequest 判断请求是否为协议升级请求
+func isUpgradeRequest(req *http.Request) bool {
+	if req.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range req.Header["Connection"] {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// roundTripHTTP1 在新流上以 HTTP/1.1 发送请求，每个请求独占一个流
+func (t *sessionTransport) roundTripHTTP1(ctx context.Context, req *http.Request) (*http.Response, error) {
+	// 1. 复制请求并修改 URL，指向目标服务器
+	req = outgoingRequest(ctx, req, t.target.Scheme, t.target.Host)
 
 	// 2. 在 Session 上打开一个新流
 	stream, err := t.session.OpenStream()
 	if err != nil {
 		return nil, fmt.Errorf("open stream failed: %w", err)
 	}
-	defer stream.Close()
 
-	// 设置流的截止时间（超时）
-	// 使用 10 分钟超时以支持长连接（如 Watch、Logs 等）
-	stream.SetDeadline(time.Now().Add(10 * time.Minute))
+	// 请求结束前 context 被取消时重置流，对端的转发请求随之取消
+	stop := context.AfterFunc(ctx, func() {
+		stream.Reset(transport.CodeCancelled)
+	})
+	fail := func(err error) error {
+		stop()
+		stream.Close()
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		return err
+	}
 
 	// 3. 写入 HTTP 请求到流
 	// 格式：METHOD PATH HTTP/1.1\r\nHeaders\r\n\r\nBody
 	if err := req.Write(stream); err != nil {
-		return nil, fmt.Errorf("write request to stream failed: %w", err)
+		return nil, fail(fmt.Errorf("write request to stream failed: %w", err))
 	}
 
 	// 4. 从流读取 HTTP 响应
-	resp, err := http.ReadResponse(bufio.NewReader(stream), req)
+	reader := bufio.NewReader(stream)
+	resp, err := http.ReadResponse(reader, req)
 	if err != nil {
-		return nil, fmt.Errorf("read response from stream failed: %w", err)
+		return nil, fail(fmt.Errorf("read response from stream failed: %w", err))
 	}
 
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		// 协议已升级，流上之后的字节直接交给调用方
+		resp.Body = &upgradedConn{reader: reader, stream: stream, stop: stop}
+		return resp, nil
+	}
+
+	// 流在响应体关闭时才关闭，调用方可以在 RoundTrip 返回后继续读取响应体
+	resp.Body = newStreamBody(resp, stream, stop)
 	return resp, nil
 }
 
+// roundTripHTTP2 通过连接池以 HTTP/2 发送请求
+// 隧道本身是明文的 h2c，目标地址的协议由服务端决定，因此请求统一使用 http:// 发出
+func (t *sessionTransport) roundTripHTTP2(ctx context.Context, req *http.Request) (*http.Response, error) {
+	resp, err := t.h2.RoundTrip(outgoingRequest(ctx, req, "http", t.target.Host))
+	if err != nil {
+		return nil, fmt.Errorf("round trip over HTTP/2 failed: %w", err)
+	}
+	return resp, nil
+}
+
+// CloseIdleConnections 关闭连接池中空闲的流
+func (t *sessionTransport) CloseIdleConnections() {
+	if t.h2 != nil {
+		t.h2.CloseIdleConnections()
+	}
+}
+
+// outgoingRequest 返回以 ctx 发往 scheme://host 的请求副本，RoundTripper 不应修改调用方的请求
+func outgoingRequest(ctx context.Context, req *http.Request, scheme, host string) *http.Request {
+	out := req.WithContext(ctx)
+	u := *req.URL
+	u.Scheme = scheme
+	u.Host = host
+	out.URL = &u
+	return out
+}
+
+// streamBody 在响应体关闭时一并关闭承载它的流
+type streamBody struct {
+	io.ReadCloser
+	stream    transport.Stream
+	stop      func() bool  // 停止 context 取消时的流重置
+	remaining atomic.Int64 // 尚未读取的长度，-1 表示未知
+	eof       atomic.Bool
+}
+
+// newStreamBody 包装响应体，没有响应体的响应（HEAD、204、304 等）视为已读完
+func newStreamBody(resp *http.Response, stream transport.Stream, stop func() bool) *streamBody {
+	b := &streamBody{ReadCloser: resp.Body, stream: stream, stop: stop}
+	b.remaining.Store(resp.ContentLength)
+	if resp.Body == http.NoBody || resp.ContentLength == 0 {
+		b.eof.Store(true)
+	}
+	return b
+}
+
+// Read 读取响应体并记录是否已读到结尾
+func (b *streamBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF || b.remaining.Load() >= 0 && b.remaining.Add(-int64(n)) <= 0 {
+		b.eof.Store(true)
+	}
+	return n, err
+}
+
+// Close 关闭响应体和流
+// 响应体还有未读的数据时先以 transport.CodeCancelled 重置流：http 的响应体在关闭时会读完剩余数据，
+// 对于 watch、日志跟随等不会结束的响应，不重置流会一直阻塞；已读完 Content-Length 或没有响应体时不重置
+func (b *streamBody) Close() error {
+	b.stop()
+	if !b.eof.Load() {
+		b.stream.Reset(transport.CodeCancelled)
+	}
+	err := b.ReadCloser.Close()
+	b.stream.Close()
+	return err
+}
+
+// upgradedConn 是协议升级后的原始字节流
+// 读取时先返回 HTTP 响应之后已缓冲的数据
+type upgradedConn struct {
+	reader *bufio.Reader
+	stream transport.Stream
+	stop   func() bool
+}
+
+// Read 读取升级后的数据
+func (c *upgradedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// Write 写入升级后的数据
+func (c *upgradedConn) Write(p []byte) (int, error) {
+	return c.stream.Write(p)
+}
+
+// CloseWrite 关闭写方向，对端读取到 EOF
+func (c *upgradedConn) CloseWrite() error {
+	return c.stream.CloseWrite()
+}
+
+// Close 关闭流
+func (c *upgradedConn) Close() error {
+	c.stop()
+	return c.stream.Close()
+}
+
+// upgradedBody 在关闭升级后的连接时释放请求的 context
+type upgradedBody struct {
+	*upgradedConn
+	cancel context.CancelCauseFunc
+}
+
+// Close 关闭连接并释放 context
+func (b *upgradedBody) Close() error {
+	err := b.upgradedConn.Close()
+	b.cancel(context.Canceled)
+	return err
+}
+
+// tunnelBody 将响应体与请求的 context 绑定
+// 读取在 context 取消后返回取消原因，连续无数据超过 idleTimeout 时取消 context，
+// 关闭响应体时释放 context
+type tunnelBody struct {
+	io.ReadCloser
+	ctx         context.Context
+	cancel      context.CancelCauseFunc
+	idle        *time.Timer
+	idleTimeout time.Duration
+}
+
+// newTunnelBody 包装响应体，idleTimeout 为 0 时不检测空闲
+func newTunnelBody(ctx context.Context, cancel context.CancelCauseFunc, body io.ReadCloser, idleTimeout time.Duration) *tunnelBody {
+	b := &tunnelBody{
+		ReadCloser:  body,
+		ctx:         ctx,
+		cancel:      cancel,
+		idleTimeout: idleTimeout,
+	}
+	if idleTimeout > 0 {
+		b.idle = time.AfterFunc(idleTimeout, func() { cancel(ErrBodyIdleTimeout) })
+	}
+	return b
+}
+
+// Read 读取响应体，并重置空闲计时
+func (b *tunnelBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && b.idle != nil {
+		b.idle.Reset(b.idleTimeout)
+	}
+	if err != nil && err != io.EOF && b.ctx.Err() != nil {
+		err = context.Cause(b.ctx)
+	}
+	return n, err
+}
+
+// Close 关闭响应体并释放 context
+func (b *tunnelBody) Close() error {
+	if b.idle != nil {
+		b.idle.Stop()
+	}
+	err := b.ReadCloser.Close()
+	b.cancel(context.Canceled)
+	return err
+}
+
 // StartHTTPServer 在 Session 上启动 HTTP 代理服务器
 // 接收来自对端的 HTTP 请求流，转发到 targetURL，然后返回响应
 //
 // targetURL: 例如 "http://localhost:8001" 或 "https://kubernetes.default.svc"
 func StartHTTPServer(sess transport.MuxSession, targetURL string) error {
-	target, err := url.Parse(targetURL)
-	if err != nil {
-		return fmt.Errorf("parse target URL failed: %w", err)
+	return StartHTTPServerWithOptions(sess, targetURL, nil)
+}
+
+// StartHTTPServerWithOptions 使用可选配置启动 HTTP 代理服务器
+// 每个流上自动识别 HTTP/1.1 和 HTTP/2 (h2c)
+func StartHTTPServerWithOptions(sess transport.MuxSession, targetURL string, opts *HTTPOptions) error {
+	var router *HTTPRouter
+	if opts != nil {
+		router = opts.Router
 	}
 
-	log.Printf("[Qymux-HTTP] 启动 HTTP 代理服务器，转发到: %s (%s)", targetURL, sess.Protocol())
+	var target *url.URL
+	if targetURL != "" || router == nil {
+		var err error
+		if target, err = url.Parse(targetURL); err != nil {
+			return fmt.Errorf("parse target URL failed: %w", err)
+		}
+	}
 
-	go func() {
-		for {
-			// 1. 接受新的虚拟流（每个流对应一个 HTTP 请求）
-			stream, err := sess.Accept()
-			if err != nil {
-				// Session 关闭或其他错误
-				log.Printf("[Qymux-HTTP] Accept stream failed: %v", err)
-				return
-			}
+	if router != nil {
+		log.Printf("[Qymux-HTTP] 启动 HTTP 代理服务器，按 %d 条规则路由，默认转发到: %s (%s)", len(router.Routes()), targetURL, sess.Protocol())
+	} else {
+		log.Printf("[Qymux-HTTP] 启动 HTTP 代理服务器，转发到: %s (%s)", targetURL, sess.Protocol())
+	}
 
-			// 2. 在 goroutine 中处理这个 HTTP 请求
-			go handleStream(stream, target)
+	upstream := opts.upstreamTransport()
+	if upstream == nil {
+		var err error
+		if upstream, err = NewUpstreamTransport(nil); err != nil {
+			return err
 		}
+	}
+
+	f := newForwarder(target, router, upstream)
+	f.accessLog, f.agent = opts.accessLog()
+	if f.accessLog == nil {
+		f.accessLog = defaultAccessLogger
+	}
+
+	server := newHTTPServer(f, opts)
+	go func() {
+		// MuxSession 实现了 net.Listener，每个流作为一个连接交给 http.Server
+		// Session 关闭时 Serve 返回
+		err := server.Serve(sess)
+		log.Printf("[Qymux-HTTP] Accept stream failed: %v", err)
 	}()
 
 	return nil
 }
 
-// handleStream 处理单个 HTTP 请求流
-func handleStream(stream net.Conn, target *url.URL) {
-	defer stream.Close()
+// newHTTPServer 创建使用 handler 处理请求的 http.Server，同时支持 HTTP/1.1 和 h2c
+func newHTTPServer(handler http.Handler, opts *HTTPOptions) *http.Server {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
 
-	// 设置超时
-	// 使用 10 分钟超时以支持长连接（如 Watch、Logs 等）
-	stream.SetDeadline(time.Now().Add(10 * time.Minute))
+	// 不设置读写超时，请求的生命周期由客户端的 context 决定：
+	// 客户端取消请求时流被重置，转发请求的 context 随之取消
+	server := &http.Server{
+		Handler:           handler,
+		Protocols:         protocols,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		IdleTimeout:       opts.idleTimeout(),
+	}
+	if opts != nil && opts.MaxConcurrentStreams > 0 {
+		server.HTTP2 = &http.HTTP2Config{MaxConcurrentStreams: opts.MaxConcurrentStreams}
+	}
+	return server
+}
 
-	// 1. 从流读取 HTTP 请求
-	req, err := http.ReadRequest(bufio.NewReader(stream))
-	if err != nil {
-		log.Printf("[Qymux-HTTP] Read request failed: %v", err)
-		return
+// routeKey 请求上下文中保存所选规则的键
+type routeKey struct{}
+
+// forwarder 按路由规则选择上游并转发请求
+type forwarder struct {
+	fallback *route // 没有规则匹配时的默认上游，可为 nil
+	router   *HTTPRouter
+	proxy    *httputil.ReverseProxy
+
+	accessLog AccessLogger // 为 nil 时不记录
+	agent     string
+}
+
+// newForwarder 创建将请求转发到上游的处理器，target 和 router 至少有一个不为 nil
+// upstream 为默认的上游 RoundTripper，规则中设置了 Transport 时使用规则的
+// httputil.ReverseProxy 负责逐跳头部的处理、trailer 的转发以及流式响应的刷新；
+// 对于 Upgrade 请求，它保留 Connection/Upgrade 头，目标返回 101 后接管流并双向转发原始字节
+func newForwarder(target *url.URL, router *HTTPRouter, upstream http.RoundTripper) *forwarder {
+	f := &forwarder{router: router}
+	if target != nil {
+		f.fallback = &route{target: target}
 	}
 
-	// 2. 修改请求的 URL，指向目标服务器
-	req.URL.Scheme = target.Scheme
-	req.URL.Host = target.Host
-	req.Host = target.Host
+	f.proxy = &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.Context().Value(routeKey{}).(*route).rewrite(req)
+		},
+		Transport:     &routeTransport{fallback: upstream},
+		FlushInterval: -1, // 立即刷新，支持 Watch、Logs 等流式响应
+		ModifyResponse: func(resp *http.Response) error {
+			// 升级后的连接被接管，不经过 WriteHeader，状态码在这里记录
+			if entry := accessLogFromContext(resp.Request.Context()); entry != nil {
+				entry.Status = resp.StatusCode
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			log.Printf("[Qymux-HTTP] Forward request failed: %v", err)
+			if entry := accessLogFromContext(req.Context()); entry != nil {
+				entry.Error = err.Error()
+			}
+			http.Error(w, "Bad Gateway: "+err.Error(), http.StatusBadGateway)
+		},
+	}
+	return f
+}
 
-	// 3. 转发请求到目标服务器
-	resp, err := http.DefaultTransport.RoundTrip(req)
-	if err != nil {
-		// 返回错误响应
-		log.Printf("[Qymux-HTTP] Forward request failed: %v", err)
-		errorResp := &http.Response{
-			StatusCode: http.StatusBadGateway,
-			Header:     make(http.Header),
-			Body:       io.NopCloser(strings.NewReader("Bad Gateway: " + err.Error())),
-		}
-		errorResp.Write(stream)
+// routeTransport 按请求所选规则的 Transport 转发
+type routeTransport struct {
+	fallback http.RoundTripper
+}
+
+// RoundTrip 转发请求
+func (t *routeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rt, ok := req.Context().Value(routeKey{}).(*route); ok && rt.Transport != nil {
+		return rt.Transport.RoundTrip(req)
+	}
+	return t.fallback.RoundTrip(req)
+}
+
+// ServeHTTP 选择上游并转发请求，结束后记录访问日志
+func (f *forwarder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if f.accessLog == nil {
+		f.serve(w, req)
 		return
 	}
-	defer resp.Body.Close()
 
-	// 4. 将响应写回流
-	// 移除 Connection 头（HTTP/1.1）
-	resp.Header.Del("Connection")
-	resp.Header.Del("Transfer-Encoding")
-	resp.Close = true
+	entry := newAccessLogEntry(AccessLogServer, f.agent, req)
+	entry.RemoteAddr = req.RemoteAddr
+	body := &countingReader{ReadCloser: req.Body}
+	req.Body = body
+	lw := &loggingResponseWriter{ResponseWriter: w}
+
+	f.serve(lw, req.WithContext(context.WithValue(req.Context(), accessLogKey{}, entry)))
 
-	if err := resp.Write(stream); err != nil {
-		log.Printf("[Qymux-HTTP] Write response failed: %v", err)
+	if lw.status != 0 {
+		entry.Status = lw.status
+	}
+	entry.BytesIn = body.n.Load()
+	entry.BytesOut = lw.n
+	entry.Duration = time.Since(entry.Time)
+	f.accessLog.LogAccess(entry)
+}
+
+// serve 选择上游并转发请求
+func (f *forwarder) serve(w http.ResponseWriter, req *http.Request) {
+	entry := accessLogFromContext(req.Context())
+
+	rt := f.fallback
+	if f.router != nil {
+		matched, err := f.router.match(req)
+		if err != nil {
+			log.Printf("[Qymux-HTTP] %s %s: %v", req.Method, req.URL.Path, err)
+			if entry != nil {
+				entry.Error = err.Error()
+			}
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if matched != nil {
+			rt = matched
+		}
+	}
+	if rt == nil {
+		log.Printf("[Qymux-HTTP] %s %s: %v", req.Method, req.URL.Path, ErrNoRoute)
+		if entry != nil {
+			entry.Error = ErrNoRoute.Error()
+		}
+		http.Error(w, ErrNoRoute.Error(), http.StatusNotFound)
 		return
 	}
 
-	log.Printf("[Qymux-HTTP] %s %s -> %d", req.Method, req.URL.Path, resp.StatusCode)
+	if entry != nil {
+		entry.Upstream = rt.Name
+		if entry.Upstream == "" {
+			entry.Upstream = rt.target.Host
+		}
+	}
+
+	req.Header.Del(HTTPUpstreamHeader)
+	f.proxy.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), routeKey{}, rt)))
 }
 
 // GetHTTPDialer 返回一个 http.RoundTripper，可以用于创建自定义的 http.Client
 // 这个函数提供了更灵活的配置方式
 func GetHTTPDialer(sess transport.MuxSession, targetURL string) (http.RoundTripper, error) {
+	return GetHTTPDialerWithOptions(sess, targetURL, nil)
+}
+
+// GetHTTPDialerWithOptions 使用可选配置返回 http.RoundTripper
+func GetHTTPDialerWithOptions(sess transport.MuxSession, targetURL string, opts *HTTPOptions) (http.RoundTripper, error) {
 	target, err := url.Parse(targetURL)
 	if err != nil {
 		return nil, fmt.Errorf("parse target URL failed: %w", err)
 	}
 
-	return &sessionTransport{
-		session: sess,
-		target:  target,
-	}, nil
+	return newSessionTransport(sess, target, opts), nil
 }
@@ -0,0 +1,217 @@
+package qymux
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// AccessLogClient 由 DialHTTP/GetHTTPDialer 一侧记录
+	AccessLogClient = "client"
+
+	// AccessLogServer 由 StartHTTPServer 一侧记录
+	AccessLogServer = "server"
+)
+
+// AccessLogEntry 是一条 HTTP 隧道访问日志
+// 请求在响应体读取完毕或关闭后记录，Watch 等长连接在结束时才会产生日志
+type AccessLogEntry struct {
+	Time       time.Time     `json:"time"`                  // 请求开始时间
+	Side       string        `json:"side"`                  // AccessLogClient 或 AccessLogServer
+	Agent      string        `json:"agent,omitempty"`       // HTTPOptions.Agent
+	Upstream   string        `json:"upstream,omitempty"`    // 服务端选中的上游（规则名称或地址）
+	RemoteAddr string        `json:"remote_addr,omitempty"` // 服务端看到的请求来源
+	Method     string        `json:"method"`
+	Host       string        `json:"host"`
+	Path       string        `json:"path"`
+	Proto      string        `json:"proto"`
+	Status     int           `json:"status"`    // 未收到响应时为 0
+	BytesIn    int64         `json:"bytes_in"`  // 请求体字节数，不含升级后连接上的数据
+	BytesOut   int64         `json:"bytes_out"` // 响应体字节数，不含升级后连接上的数据
+	Duration   time.Duration `json:"-"`
+	Error      string        `json:"error,omitempty"` // 隧道或上游错误
+}
+
+// MarshalJSON 以毫秒输出 Duration
+func (e *AccessLogEntry) MarshalJSON() ([]byte, error) {
+	type entry AccessLogEntry
+	return json.Marshal(struct {
+		*entry
+		DurationMS float64 `json:"duration_ms"`
+	}{(*entry)(e), float64(e.Duration.Microseconds()) / 1000})
+}
+
+// AccessLogger 接收 HTTP 隧道的访问日志，实现需要并发安全
+type AccessLogger interface {
+	LogAccess(entry *AccessLogEntry)
+}
+
+// AccessLoggerFunc 将函数适配为 AccessLogger
+type AccessLoggerFunc func(entry *AccessLogEntry)
+
+// LogAccess 实现 AccessLogger
+func (f AccessLoggerFunc) LogAccess(entry *AccessLogEntry) {
+	f(entry)
+}
+
+// NewJSONAccessLogger 返回每行输出一个 JSON 对象的 AccessLogger
+func NewJSONAccessLogger(w io.Writer) AccessLogger {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return AccessLoggerFunc(func(entry *AccessLogEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(entry)
+	})
+}
+
+// NewCommonAccessLogger 返回 Common Log Format 的 AccessLogger，并在末尾追加隧道相关字段：
+//
+//	127.0.0.1 - - [02/Jan/2006:15:04:05 -0700] "GET /api HTTP/1.1" 200 512 agent="edge1" in=0 duration=1.2ms
+func NewCommonAccessLogger(w io.Writer) AccessLogger {
+	var mu sync.Mutex
+	return AccessLoggerFunc(func(e *AccessLogEntry) {
+		remote := e.RemoteAddr
+		if remote == "" {
+			remote = "-"
+		}
+		line := fmt.Sprintf("%s - - [%s] %q %d %d agent=%q in=%d duration=%s",
+			remote, e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			e.Method+" "+e.Path+" "+e.Proto, e.Status, e.BytesOut,
+			e.Agent, e.BytesIn, e.Duration)
+		if e.Error != "" {
+			line += fmt.Sprintf(" error=%q", e.Error)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		io.WriteString(w, line+"\n")
+	})
+}
+
+// defaultAccessLogger 未配置 AccessLog 时服务端使用的日志
+var defaultAccessLogger = AccessLoggerFunc(func(e *AccessLogEntry) {
+	if e.Status != 0 {
+		log.Printf("[Qymux-HTTP] %s %s -> %d", e.Method, e.Path, e.Status)
+	}
+})
+
+// newAccessLogEntry 根据请求创建日志条目
+func newAccessLogEntry(side, agent string, req *http.Request) *AccessLogEntry {
+	return &AccessLogEntry{
+		Time:   time.Now(),
+		Side:   side,
+		Agent:  agent,
+		Method: req.Method,
+		Host:   req.Host,
+		Path:   req.URL.Path,
+		Proto:  req.Proto,
+	}
+}
+
+// accessLogKey 请求上下文中保存日志条目的键
+type accessLogKey struct{}
+
+// accessLogFromContext 返回请求上下文中的日志条目，没有时返回 nil
+func accessLogFromContext(ctx context.Context) *AccessLogEntry {
+	entry, _ := ctx.Value(accessLogKey{}).(*AccessLogEntry)
+	return entry
+}
+
+// countingReader 统计读取的字节数
+type countingReader struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+// Read 读取并计数
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+// loggingResponseWriter 记录状态码和写入的字节数
+// 实现 Unwrap，使 http.ResponseController 可以使用底层的 Flush、Hijack 等能力
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+// WriteHeader 记录状态码
+func (w *loggingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write 写入并计数
+func (w *loggingResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Unwrap 返回底层 ResponseWriter
+func (w *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// loggedBody 在响应体读取完毕或关闭时记录一次日志
+type loggedBody struct {
+	io.ReadCloser
+	entry   *AccessLogEntry
+	reqBody *countingReader
+	logger  AccessLogger
+	n       atomic.Int64
+	once    sync.Once
+}
+
+// Read 读取并计数，读取出错（包括 EOF）时记录日志
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	if err != nil {
+		b.finish(err)
+	}
+	return n, err
+}
+
+// Close 关闭响应体并记录日志
+func (b *loggedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish(nil)
+	return err
+}
+
+// finish 填充统计信息并记录日志
+func (b *loggedBody) finish(err error) {
+	b.once.Do(func() {
+		b.entry.BytesOut = b.n.Load()
+		logClientAccess(b.logger, b.entry, b.reqBody, err)
+	})
+}
+
+// logClientAccess 填充请求体字节数、耗时和错误后记录客户端日志
+func logClientAccess(logger AccessLogger, entry *AccessLogEntry, reqBody *countingReader, err error) {
+	if reqBody != nil {
+		entry.BytesIn = reqBody.n.Load()
+	}
+	entry.Duration = time.Since(entry.Time)
+	if err != nil && err != io.EOF {
+		entry.Error = err.Error()
+	}
+	logger.LogAccess(entry)
+}
@@ -0,0 +1,143 @@
+package qymux
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chanLogger 将日志条目发送到通道
+type chanLogger chan *AccessLogEntry
+
+func (c chanLogger) LogAccess(entry *AccessLogEntry) {
+	c <- entry
+}
+
+// receive 等待一条日志
+func (c chanLogger) receive(t *testing.T) *AccessLogEntry {
+	t.Helper()
+	select {
+	case entry := <-c:
+		return entry
+	case <-time.After(5 * time.Second):
+		t.Fatal("access log entry not received")
+		return nil
+	}
+}
+
+func TestHTTPAccessLog(t *testing.T) {
+	for _, h2 := range []bool{false, true} {
+		name := "http1"
+		if h2 {
+			name = "http2"
+		}
+		t.Run(name, func(t *testing.T) {
+			target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				w.WriteHeader(http.StatusCreated)
+				w.Write(bytes.Repeat(body, 2))
+			}))
+			defer target.Close()
+
+			router, err := NewHTTPRouter([]HTTPRoute{{Name: "api", Target: target.URL}})
+			if err != nil {
+				t.Fatalf("NewHTTPRouter() error = %v", err)
+			}
+
+			clientLog, serverLog := make(chanLogger, 1), make(chanLogger, 1)
+			client, server := newSessionPair(t)
+			if err := StartHTTPServerWithOptions(server, "", &HTTPOptions{
+				Router:    router,
+				Agent:     "edge1",
+				AccessLog: serverLog,
+			}); err != nil {
+				t.Fatalf("StartHTTPServerWithOptions() error = %v", err)
+			}
+
+			c, err := DialHTTPWithOptions(client, "http://agent", &HTTPOptions{
+				HTTP2:     h2,
+				Agent:     "edge1",
+				AccessLog: clientLog,
+			})
+			if err != nil {
+				t.Fatalf("DialHTTPWithOptions() error = %v", err)
+			}
+			resp, err := c.Post("http://agent/upload", "text/plain", strings.NewReader("hello"))
+			if err != nil {
+				t.Fatalf("Post() error = %v", err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+
+			for _, entry := range []*AccessLogEntry{clientLog.receive(t), serverLog.receive(t)} {
+				if entry.Agent != "edge1" || entry.Method != http.MethodPost || entry.Path != "/upload" ||
+					entry.Status != http.StatusCreated || entry.BytesIn != 5 || entry.BytesOut != 10 || entry.Error != "" {
+					t.Errorf("%s entry = %+v", entry.Side, entry)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPAccessLogUpstreamError(t *testing.T) {
+	target := httptest.NewServer(http.NotFoundHandler())
+	target.Close()
+
+	serverLog := make(chanLogger, 1)
+	client, server := newSessionPair(t)
+	if err := StartHTTPServerWithOptions(server, target.URL, &HTTPOptions{AccessLog: serverLog}); err != nil {
+		t.Fatalf("StartHTTPServerWithOptions() error = %v", err)
+	}
+
+	c, err := DialHTTP(client, "http://agent")
+	if err != nil {
+		t.Fatalf("DialHTTP() error = %v", err)
+	}
+	resp, err := c.Get("http://agent/")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+
+	entry := serverLog.receive(t)
+	if entry.Status != http.StatusBadGateway || entry.Error == "" || entry.Upstream != strings.TrimPrefix(target.URL, "http://") {
+		t.Errorf("entry = %+v, want 502 with upstream error", entry)
+	}
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	entry := &AccessLogEntry{
+		Time:       time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Side:       AccessLogServer,
+		Agent:      "edge1",
+		RemoteAddr: "10.0.0.1:1234",
+		Method:     http.MethodGet,
+		Path:       "/api",
+		Proto:      "HTTP/1.1",
+		Status:     http.StatusOK,
+		BytesOut:   512,
+		Duration:   1500 * time.Microsecond,
+	}
+
+	var buf bytes.Buffer
+	NewJSONAccessLogger(&buf).LogAccess(entry)
+	var decoded map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if decoded["agent"] != "edge1" || decoded["status"] != float64(200) || decoded["duration_ms"] != 1.5 {
+		t.Errorf("JSON = %s", buf.String())
+	}
+
+	buf.Reset()
+	NewCommonAccessLogger(&buf).LogAccess(entry)
+	want := `10.0.0.1:1234 - - [02/Jan/2024:03:04:05 +0000] "GET /api HTTP/1.1" 200 512 agent="edge1" in=0 duration=1.5ms` + "\n"
+	if buf.String() != want {
+		t.Errorf("common log = %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,233 @@
+package qymux
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/forward"
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// DefaultProxyAgentHeader 未使用代理认证时指定 Agent 名称的请求头
+const DefaultProxyAgentHeader = "X-Qymux-Agent"
+
+// HTTPProxyOptions 定义 HTTP 代理的可选配置
+type HTTPProxyOptions struct {
+	// Authenticate 校验 Proxy-Authorization (Basic) 中的用户名和密码，设置后客户端必须认证
+	// 为 nil 时不校验密码，Proxy-Authorization 中的用户名仍用于选择 Agent
+	Authenticate func(username, password string) bool
+
+	// AgentHeader 指定 Agent 名称的请求头，默认 DefaultProxyAgentHeader
+	// Proxy-Authorization 中的用户名优先；该请求头不会转发给目标
+	AgentHeader string
+}
+
+// proxyKey 请求上下文中保存所选会话 Transport 的键
+type proxyKey struct{}
+
+// HTTPProxy 是出站连接经由 Agent 拨号的 HTTP 代理，实现 http.Handler
+// CONNECT 请求通过 forward.Dial 建立到目标的隧道，绝对 URI 的请求经由 forward.SessionDialer 拨号后转发；
+// 目标由 Agent 端的 ForwardServer 拨号，受其 ServerOptions.Targets 策略的限制
+type HTTPProxy struct {
+	registry    *SessionRegistry
+	opts        HTTPProxyOptions
+	agentHeader string
+	proxy       *httputil.ReverseProxy
+
+	mu         sync.Mutex
+	transports map[transport.MuxSession]*http.Transport
+}
+
+// NewHTTPProxy 创建 HTTP 代理，按 Proxy-Authorization 的用户名或 AgentHeader 从 registry 中选择 Agent
+func NewHTTPProxy(registry *SessionRegistry, opts *HTTPProxyOptions) *HTTPProxy {
+	p := &HTTPProxy{
+		registry:    registry,
+		agentHeader: DefaultProxyAgentHeader,
+		transports:  make(map[transport.MuxSession]*http.Transport),
+	}
+	if opts != nil {
+		p.opts = *opts
+		if opts.AgentHeader != "" {
+			p.agentHeader = opts.AgentHeader
+		}
+	}
+
+	p.proxy = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			// Proxy-Authorization 作为逐跳头已被移除
+			pr.Out.Header.Del(p.agentHeader)
+		},
+		Transport:     proxyTransport{},
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			log.Printf("[Qymux-Proxy] %s %s: %v", req.Method, req.URL, err)
+			http.Error(w, err.Error(), proxyStatus(err))
+		},
+	}
+	return p
+}
+
+// ServeHTTP 选择 Agent 会话并处理 CONNECT 或绝对 URI 的代理请求
+func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	agent, ok := p.agent(req)
+	if !ok {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="qymux"`)
+		http.Error(w, ErrUnknownAgent.Error(), http.StatusProxyAuthRequired)
+		return
+	}
+
+	sess, ok := p.registry.Get(agent)
+	if !ok {
+		http.Error(w, fmt.Sprintf("%v: %s", ErrAgentOffline, agent), http.StatusBadGateway)
+		return
+	}
+
+	if req.Method == http.MethodConnect {
+		p.connect(w, req, agent, sess)
+		return
+	}
+	if !req.URL.IsAbs() {
+		http.Error(w, "qymux: proxy request requires absolute URI", http.StatusBadRequest)
+		return
+	}
+	ctx := context.WithValue(req.Context(), proxyKey{}, p.transport(sess))
+	p.proxy.ServeHTTP(w, req.WithContext(ctx))
+}
+
+// agent 从 Proxy-Authorization 或 AgentHeader 中解析 Agent 名称，设置了 Authenticate 时同时校验密码
+func (p *HTTPProxy) agent(req *http.Request) (string, bool) {
+	username, password, hasAuth := parseProxyAuth(req.Header.Get("Proxy-Authorization"))
+	if p.opts.Authenticate != nil {
+		if !hasAuth || !p.opts.Authenticate(username, password) {
+			return "", false
+		}
+	}
+	if username != "" {
+		return username, true
+	}
+	agent := req.Header.Get(p.agentHeader)
+	return agent, agent != ""
+}
+
+// connect 请求 Agent 拨号 CONNECT 的目标，接管客户端连接并转发
+// 仅支持 HTTP/1.x
+func (p *HTTPProxy) connect(w http.ResponseWriter, req *http.Request, agent string, sess transport.MuxSession) {
+	stream, err := forward.Dial(sess, "tcp", req.Host)
+	if err != nil {
+		log.Printf("[Qymux-Proxy] CONNECT %s via %s: %v", req.Host, agent, err)
+		http.Error(w, err.Error(), proxyStatus(err))
+		return
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		stream.Close()
+		http.Error(w, "qymux: CONNECT not supported: "+err.Error(), http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if _, err := brw.WriteString("HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil || brw.Flush() != nil {
+		stream.Close()
+		conn.Close()
+		return
+	}
+
+	client := conn
+	if brw.Reader.Buffered() > 0 {
+		// 客户端在收到响应前已经发送的数据
+		client = &bufferedConn{Conn: conn, r: brw.Reader}
+	}
+	forward.Pipe(client, stream)
+}
+
+// transport 返回会话对应的 Transport，同一会话复用以保留连接池，会话关闭后关闭空闲连接并移除缓存
+func (p *HTTPProxy) transport(sess transport.MuxSession) *http.Transport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if t, ok := p.transports[sess]; ok {
+		return t
+	}
+
+	t := &http.Transport{
+		DialContext:         forward.NewSessionDialer(sess, 0).DialContext,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConnsPerHost: 8,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	p.transports[sess] = t
+
+	if done := transport.SessionDone(sess); done != nil {
+		go func() {
+			<-done
+			p.mu.Lock()
+			delete(p.transports, sess)
+			p.mu.Unlock()
+			t.CloseIdleConnections()
+		}()
+	}
+	return t
+}
+
+// proxyTransport 使用请求所选会话的 Transport 转发
+type proxyTransport struct{}
+
+// RoundTrip 转发请求
+func (proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return req.Context().Value(proxyKey{}).(*http.Transport).RoundTrip(req)
+}
+
+// proxyStatus 将 Agent 的拨号错误映射为响应状态码
+func proxyStatus(err error) int {
+	var dialErr *forward.DialError
+	if !errors.As(err, &dialErr) {
+		return http.StatusBadGateway
+	}
+	switch dialErr.Code {
+	case forward.ReplyDenied:
+		return http.StatusForbidden
+	case forward.ReplyTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// parseProxyAuth 解析 Basic 认证的 Proxy-Authorization
+func parseProxyAuth(auth string) (username, password string, ok bool) {
+	scheme, credentials, found := strings.Cut(auth, " ")
+	if !found || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+// bufferedConn 先读取接管连接时已缓冲的数据
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Read 从缓冲区读取
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// CloseWrite 关闭底层连接的写方向
+func (c *bufferedConn) CloseWrite() error {
+	return transport.CloseWrite(c.Conn)
+}
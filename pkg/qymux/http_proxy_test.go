@@ -0,0 +1,111 @@
+package qymux
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/funcx27/qymux/pkg/forward"
+)
+
+func TestHTTPProxy(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path + " " + r.Header.Get(DefaultProxyAgentHeader) + r.Header.Get("Proxy-Authorization")))
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+	denied := httptest.NewServer(handler)
+	defer denied.Close()
+
+	// Agent 端拒绝 denied 服务
+	targets, err := forward.NewPolicy(nil, []string{strings.TrimPrefix(denied.URL, "http://")})
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+	agent, sess := newSessionPair(t)
+	go NewForwardServer(&forward.ServerOptions{Targets: targets}).Serve(agent)
+
+	reg := NewSessionRegistry()
+	reg.Register("edge1", sess)
+	front := httptest.NewServer(NewHTTPProxy(reg, &HTTPProxyOptions{
+		Authenticate: func(username, password string) bool { return password == "secret" },
+	}))
+	defer front.Close()
+
+	client := func(user string) *http.Client {
+		proxyURL, _ := url.Parse(front.URL)
+		if user != "" {
+			proxyURL.User = url.UserPassword(user, "secret")
+		}
+		return &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}}
+	}
+	get := func(c *http.Client, target string) (int, string) {
+		t.Helper()
+		resp, err := c.Get(target)
+		if err != nil {
+			t.Fatalf("Get(%s) error = %v", target, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// 绝对 URI：代理相关的请求头不会转发给目标
+	if status, body := get(client("edge1"), plain.URL+"/api"); status != http.StatusOK || body != "/api " {
+		t.Errorf("absolute URI = %d %q", status, body)
+	}
+	// CONNECT
+	if status, body := get(client("edge1"), secure.URL+"/tls"); status != http.StatusOK || body != "/tls " {
+		t.Errorf("CONNECT = %d %q", status, body)
+	}
+	if status, _ := get(client("edge1"), denied.URL); status != http.StatusForbidden {
+		t.Errorf("denied target status = %d, want 403", status)
+	}
+	if status, _ := get(client("edge2"), plain.URL); status != http.StatusBadGateway {
+		t.Errorf("offline agent status = %d, want 502", status)
+	}
+	if status, _ := get(client(""), plain.URL); status != http.StatusProxyAuthRequired {
+		t.Errorf("unauthenticated status = %d, want 407", status)
+	}
+	if _, err := client("edge2").Get(secure.URL); err == nil {
+		t.Error("CONNECT to offline agent error = nil")
+	}
+}
+
+func TestHTTPProxyAgentHeader(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Agent")))
+	}))
+	defer target.Close()
+
+	targets, _ := forward.NewPolicy(nil, nil)
+	agent, sess := newSessionPair(t)
+	go NewForwardServer(&forward.ServerOptions{Targets: targets}).Serve(agent)
+	reg := NewSessionRegistry()
+	reg.Register("edge1", sess)
+	front := httptest.NewServer(NewHTTPProxy(reg, &HTTPProxyOptions{AgentHeader: "X-Agent"}))
+	defer front.Close()
+
+	proxyURL, _ := url.Parse(front.URL)
+	c := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	req, _ := http.NewRequest(http.MethodGet, target.URL, nil)
+	req.Header.Set("X-Agent", "edge1")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "" {
+		t.Errorf("agent header = %d %q, want 200 without header", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,198 @@
+package qymux
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// HTTPUpstreamHeader 客户端指定上游名称的请求头，服务端在转发前移除
+const HTTPUpstreamHeader = "X-Qymux-Upstream"
+
+// ErrNoRoute 没有与请求匹配的上游
+var ErrNoRoute = errors.New("qymux: no upstream for request")
+
+// HTTPRoute 定义一条 HTTP 转发规则
+type HTTPRoute struct {
+	// Name 上游名称，客户端可通过 HTTPUpstreamHeader 或 HTTPOptions.Upstream 直接选择，可为空
+	Name string
+
+	// Host 匹配的 Host 头（不含端口），支持 "*.example.com" 形式的通配符，为空时匹配任意主机
+	Host string
+
+	// PathPrefix 匹配的路径前缀，按路径段匹配（"/api" 匹配 "/api" 和 "/api/v1"，不匹配 "/apis"），为空时匹配任意路径
+	PathPrefix string
+
+	// StripPrefix 转发前去除路径中的 PathPrefix
+	StripPrefix bool
+
+	// Target 上游地址，例如 "http://localhost:8080" 或 "https://kubernetes.default.svc"
+	// 路径部分会作为转发路径的前缀
+	Target string
+
+	// SetHeaders 转发前设置的请求头
+	SetHeaders map[string]string
+
+	// RemoveHeaders 转发前移除的请求头
+	RemoveHeaders []string
+
+	// Transport 连接该上游使用的 http.RoundTripper，可由 NewUpstreamTransport 创建
+	// 为 nil 时使用 HTTPOptions.UpstreamTransport
+	Transport http.RoundTripper
+}
+
+// route 是解析后的转发规则
+type route struct {
+	HTTPRoute
+	target *url.URL
+}
+
+// HTTPRouter 按 Host 头和路径前缀将请求分发到不同的上游
+// 规则可以在运行时通过 Update 整体替换，正在处理的请求不受影响
+//
+// 匹配顺序：请求指定了上游名称时按名称选择；否则精确主机优先于通配符主机，通配符主机优先于任意主机，
+// 主机相同时路径前缀最长的规则优先，仍相同时先定义的规则优先
+type HTTPRouter struct {
+	routes atomic.Pointer[[]*route]
+}
+
+// NewHTTPRouter 创建 HTTP 路由器
+func NewHTTPRouter(routes []HTTPRoute) (*HTTPRouter, error) {
+	r := &HTTPRouter{}
+	if err := r.Update(routes); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Update 校验并替换全部规则，校验失败时保留原有规则
+func (r *HTTPRouter) Update(routes []HTTPRoute) error {
+	compiled := make([]*route, 0, len(routes))
+	names := make(map[string]bool)
+	for i, rt := range routes {
+		target, err := url.Parse(rt.Target)
+		if err != nil {
+			return fmt.Errorf("route %d: parse target URL failed: %w", i, err)
+		}
+		if target.Scheme == "" || target.Host == "" {
+			return fmt.Errorf("route %d: target URL %q must include scheme and host", i, rt.Target)
+		}
+		if rt.PathPrefix != "" && !strings.HasPrefix(rt.PathPrefix, "/") {
+			return fmt.Errorf("route %d: path prefix %q must start with /", i, rt.PathPrefix)
+		}
+		if rt.Name != "" {
+			if names[rt.Name] {
+				return fmt.Errorf("route %d: duplicate upstream name %q", i, rt.Name)
+			}
+			names[rt.Name] = true
+		}
+
+		rt.Host = strings.ToLower(rt.Host)
+		compiled = append(compiled, &route{HTTPRoute: rt, target: target})
+	}
+
+	r.routes.Store(&compiled)
+	return nil
+}
+
+// Routes 返回当前的规则
+func (r *HTTPRouter) Routes() []HTTPRoute {
+	routes := *r.routes.Load()
+	result := make([]HTTPRoute, len(routes))
+	for i, rt := range routes {
+		result[i] = rt.HTTPRoute
+	}
+	return result
+}
+
+// match 选择与请求匹配的规则，没有匹配时返回 nil
+func (r *HTTPRouter) match(req *http.Request) (*route, error) {
+	routes := *r.routes.Load()
+
+	if name := req.Header.Get(HTTPUpstreamHeader); name != "" {
+		for _, rt := range routes {
+			if rt.Name == name {
+				return rt, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: unknown upstream %q", ErrNoRoute, name)
+	}
+
+	host := strings.ToLower(req.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	var best *route
+	bestHost, bestPath := -1, -1
+	for _, rt := range routes {
+		hostScore := matchHost(rt.Host, host)
+		if hostScore < 0 || !matchPathPrefix(rt.PathPrefix, req.URL.Path) {
+			continue
+		}
+		if hostScore > bestHost || (hostScore == bestHost && len(rt.PathPrefix) > bestPath) {
+			best, bestHost, bestPath = rt, hostScore, len(rt.PathPrefix)
+		}
+	}
+	return best, nil
+}
+
+// matchHost 返回主机匹配的优先级：2 为精确匹配，1 为通配符匹配，0 为任意主机，-1 为不匹配
+func matchHost(pattern, host string) int {
+	switch {
+	case pattern == "":
+		return 0
+	case pattern == host:
+		return 2
+	case strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]):
+		return 1
+	default:
+		return -1
+	}
+}
+
+// matchPathPrefix 按路径段判断 path 是否以 prefix 开头
+func matchPathPrefix(prefix, path string) bool {
+	if prefix == "" || prefix == "/" {
+		return true
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// rewrite 将请求改写为发往该规则的上游
+func (rt *route) rewrite(req *http.Request) {
+	if rt.StripPrefix && rt.PathPrefix != "" {
+		req.URL.Path = stripPathPrefix(req.URL.Path, rt.PathPrefix)
+		req.URL.RawPath = ""
+	}
+	if rt.target.Path != "" && rt.target.Path != "/" {
+		req.URL.Path = strings.TrimSuffix(rt.target.Path, "/") + "/" + strings.TrimPrefix(req.URL.Path, "/")
+		req.URL.RawPath = ""
+	}
+
+	// 修改请求的 URL，指向目标服务器
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	req.Host = rt.target.Host
+
+	for _, name := range rt.RemoveHeaders {
+		req.Header.Del(name)
+	}
+	for name, value := range rt.SetHeaders {
+		req.Header.Set(name, value)
+	}
+}
+
+// stripPathPrefix 去除路径前缀，结果总是以 / 开头
+func stripPathPrefix(path, prefix string) string {
+	path = strings.TrimPrefix(path, strings.TrimSuffix(prefix, "/"))
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
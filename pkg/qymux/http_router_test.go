@@ -0,0 +1,121 @@
+package qymux
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPRouterMatch(t *testing.T) {
+	router, err := NewHTTPRouter([]HTTPRoute{
+		{Name: "default", Target: "http://default"},
+		{Name: "api", PathPrefix: "/api", Target: "http://api"},
+		{Name: "api-v2", PathPrefix: "/api/v2", Target: "http://api-v2"},
+		{Name: "wildcard", Host: "*.example.com", Target: "http://wildcard"},
+		{Name: "exact", Host: "www.example.com", Target: "http://exact"},
+	})
+	if err != nil {
+		t.Fatalf("NewHTTPRouter() error = %v", err)
+	}
+
+	tests := []struct {
+		host, path, upstream string
+		want                 string
+	}{
+		{"agent", "/", "", "default"},
+		{"agent", "/api", "", "api"},
+		{"agent", "/apis", "", "default"},
+		{"agent", "/api/v2/pods", "", "api-v2"},
+		{"foo.example.com", "/api", "", "wildcard"},
+		{"www.example.com:8080", "/", "", "exact"},
+		{"agent", "/", "api", "api"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://"+tt.host+tt.path, nil)
+		if tt.upstream != "" {
+			req.Header.Set(HTTPUpstreamHeader, tt.upstream)
+		}
+		rt, err := router.match(req)
+		if err != nil || rt == nil || rt.Name != tt.want {
+			t.Errorf("match(%s%s) = %v, %v; want %s", tt.host, tt.path, rt, err, tt.want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://agent/", nil)
+	req.Header.Set(HTTPUpstreamHeader, "missing")
+	if _, err := router.match(req); err == nil {
+		t.Error("match() with unknown upstream should fail")
+	}
+}
+
+func TestHTTPRouterUpdateInvalid(t *testing.T) {
+	router, _ := NewHTTPRouter([]HTTPRoute{{Name: "a", Target: "http://a"}})
+
+	invalid := [][]HTTPRoute{
+		{{Target: "localhost:8080"}},
+		{{Target: "http://a", PathPrefix: "api"}},
+		{{Name: "a", Target: "http://a"}, {Name: "a", Target: "http://b"}},
+	}
+	for _, routes := range invalid {
+		if err := router.Update(routes); err == nil {
+			t.Errorf("Update(%+v) should fail", routes)
+		}
+	}
+	if routes := router.Routes(); len(routes) != 1 || routes[0].Name != "a" {
+		t.Errorf("Routes() = %+v, want original routes", routes)
+	}
+}
+
+func TestHTTPTunnelRouting(t *testing.T) {
+	newTarget := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + " " + r.URL.Path + " " + r.Header.Get("X-Route") + r.Header.Get(HTTPUpstreamHeader)))
+		}))
+	}
+	apiTarget, webTarget := newTarget("api"), newTarget("web")
+	defer apiTarget.Close()
+	defer webTarget.Close()
+
+	router, err := NewHTTPRouter([]HTTPRoute{
+		{Name: "api", PathPrefix: "/api", StripPrefix: true, Target: apiTarget.URL + "/v1", SetHeaders: map[string]string{"X-Route": "api"}},
+		{Name: "web", Target: webTarget.URL},
+	})
+	if err != nil {
+		t.Fatalf("NewHTTPRouter() error = %v", err)
+	}
+
+	client, server := newSessionPair(t)
+	StartHTTPServerWithOptions(server, "", &HTTPOptions{Router: router})
+
+	get := func(c *http.Client, path string) string {
+		t.Helper()
+		resp, err := c.Get("http://agent" + path)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	c, _ := DialHTTP(client, "http://agent")
+	if got, want := get(c, "/api/pods"), "api /v1/pods api"; got != want {
+		t.Errorf("GET /api/pods = %q, want %q", got, want)
+	}
+	if got, want := get(c, "/index.html"), "web /index.html "; got != want {
+		t.Errorf("GET /index.html = %q, want %q", got, want)
+	}
+
+	// 客户端按名称选择上游
+	named, _ := DialHTTPWithOptions(client, "http://agent", &HTTPOptions{Upstream: "web"})
+	if got, want := get(named, "/api/pods"), "web /api/pods "; got != want {
+		t.Errorf("GET /api/pods via web = %q, want %q", got, want)
+	}
+
+	// 运行时替换规则
+	router.Update([]HTTPRoute{{Target: apiTarget.URL}})
+	if got, want := get(c, "/index.html"), "api /index.html "; got != want {
+		t.Errorf("GET /index.html after Update = %q, want %q", got, want)
+	}
+}
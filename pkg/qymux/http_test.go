@@ -0,0 +1,396 @@
+package qymux
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/tcp"
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// newSessionPair 在本地回环上建立一对 TCP 会话
+func newSessionPair(t *testing.T) (client, server transport.MuxSession) {
+	t.Helper()
+
+	ln, err := tcp.Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	client, err = tcp.NewDialer(nil).Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	server, err = ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return client, server
+}
+
+// countingSession 统计打开的流数
+type countingSession struct {
+	transport.MuxSession
+	mu     sync.Mutex
+	opened int
+}
+
+func (s *countingSession) OpenStream() (transport.Stream, error) {
+	s.mu.Lock()
+	s.opened++
+	s.mu.Unlock()
+	return s.MuxSession.OpenStream()
+}
+
+func TestHTTPTunnel(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok " + r.URL.Path))
+	}))
+	defer target.Close()
+
+	client, server := newSessionPair(t)
+	if err := StartHTTPServer(server, target.URL); err != nil {
+		t.Fatalf("StartHTTPServer() error = %v", err)
+	}
+
+	c, err := DialHTTP(client, target.URL)
+	if err != nil {
+		t.Fatalf("DialHTTP() error = %v", err)
+	}
+	resp, err := c.Get("http://agent/hello")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.ProtoMajor != 1 || string(body) != "ok /hello" {
+		t.Errorf("response = %s %q, want HTTP/1.x %q", resp.Proto, body, "ok /hello")
+	}
+}
+
+func TestHTTPTunnelHTTP2(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Write([]byte("ok " + r.URL.Path))
+		w.Header().Set("X-Checksum", "abc")
+	}))
+	defer target.Close()
+
+	client, server := newSessionPair(t)
+	if err := StartHTTPServer(server, target.URL); err != nil {
+		t.Fatalf("StartHTTPServer() error = %v", err)
+	}
+
+	counting := &countingSession{MuxSession: client}
+	c, err := DialHTTPWithOptions(counting, target.URL, &HTTPOptions{HTTP2: true})
+	if err != nil {
+		t.Fatalf("DialHTTPWithOptions() error = %v", err)
+	}
+
+	// 第一个请求建立 HTTP/2 连接后，并发请求复用同一个流
+	resp, err := c.Get("http://agent/warmup")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.Get("http://agent/hello")
+			if err != nil {
+				t.Errorf("Get() error = %v", err)
+				return
+			}
+			defer resp.Body.Close()
+
+			body, _ := io.ReadAll(resp.Body)
+			if resp.ProtoMajor != 2 || string(body) != "ok /hello" {
+				t.Errorf("response = %s %q, want HTTP/2.0 %q", resp.Proto, body, "ok /hello")
+			}
+			if got := resp.Trailer.Get("X-Checksum"); got != "abc" {
+				t.Errorf("Trailer X-Checksum = %q, want %q", got, "abc")
+			}
+		}()
+	}
+	wg.Wait()
+
+	counting.mu.Lock()
+	defer counting.mu.Unlock()
+	if counting.opened != 1 {
+		t.Errorf("opened %d streams, want 1", counting.opened)
+	}
+}
+
+func TestHTTPTunnelStreaming(t *testing.T) {
+	upstreamDone := make(chan struct{})
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(upstreamDone)
+		for i := 0; ; i++ {
+			fmt.Fprintf(w, "event %d\n", i)
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+	defer target.Close()
+
+	for _, opts := range []*HTTPOptions{nil, {HTTP2: true}} {
+		client, server := newSessionPair(t)
+		StartHTTPServer(server, target.URL)
+		c, _ := DialHTTPWithOptions(client, target.URL, opts)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://agent/watch", nil)
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+
+		// 响应体在 RoundTrip 返回后持续可读
+		reader := bufio.NewReader(resp.Body)
+		for i := 0; i < 3; i++ {
+			if line, err := reader.ReadString('\n'); err != nil || line != fmt.Sprintf("event %d\n", i) {
+				t.Fatalf("ReadString() = %q, %v", line, err)
+			}
+		}
+
+		// 取消请求后读取返回错误，上游请求随之取消
+		cancel()
+		if _, err := io.ReadAll(reader); !errors.Is(err, context.Canceled) {
+			t.Errorf("ReadAll() after cancel error = %v, want context.Canceled", err)
+		}
+		resp.Body.Close()
+
+		select {
+		case <-upstreamDone:
+		case <-time.After(5 * time.Second):
+			t.Fatal("upstream request not cancelled")
+		}
+		upstreamDone = make(chan struct{})
+	}
+}
+
+func TestHTTPTunnelStreamingClose(t *testing.T) {
+	upstreamDone := make(chan struct{})
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(upstreamDone)
+		for i := 0; ; i++ {
+			fmt.Fprintf(w, "event %d\n", i)
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+	defer target.Close()
+
+	client, server := newSessionPair(t)
+	StartHTTPServer(server, target.URL)
+	c, _ := DialHTTP(client, target.URL)
+
+	resp, err := c.Get("http://agent/watch")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if line, err := bufio.NewReader(resp.Body).ReadString('\n'); err != nil || line != "event 0\n" {
+		t.Fatalf("ReadString() = %q, %v", line, err)
+	}
+
+	// 不取消 context 直接关闭未读完的响应体，关闭不阻塞，上游请求随之取消
+	closed := make(chan struct{})
+	go func() {
+		resp.Body.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Body.Close() blocked on streaming response")
+	}
+
+	select {
+	case <-upstreamDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream request not cancelled")
+	}
+}
+
+// resetRecorder 记录以 Reset 中止的流数
+type resetRecorder struct {
+	transport.MuxSession
+	mu     sync.Mutex
+	resets int
+}
+
+func (s *resetRecorder) OpenStream() (transport.Stream, error) {
+	stream, err := s.MuxSession.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	return &recordedStream{Stream: stream, s: s}, nil
+}
+
+func (s *resetRecorder) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resets
+}
+
+// recordedStream 在 Reset 时计数
+type recordedStream struct {
+	transport.Stream
+	s *resetRecorder
+}
+
+func (c *recordedStream) Reset(code transport.ErrorCode) error {
+	c.s.mu.Lock()
+	c.s.resets++
+	c.s.mu.Unlock()
+	return c.Stream.Reset(code)
+}
+
+func TestHTTPTunnelCompleteBodyNoReset(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Length", "5")
+		io.WriteString(w, "hello")
+	}))
+	defer target.Close()
+
+	client, server := newSessionPair(t)
+	StartHTTPServer(server, target.URL)
+	recorder := &resetRecorder{MuxSession: client}
+	c, _ := DialHTTP(recorder, target.URL)
+
+	// 恰好读完 Content-Length 但没有读到 io.EOF
+	resp, err := c.Get("http://agent/data")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(resp.Body, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("ReadFull() = %q, %v", buf, err)
+	}
+	resp.Body.Close()
+
+	// 没有响应体的响应
+	for _, req := range []struct{ method, path string }{{http.MethodHead, "/data"}, {http.MethodGet, "/empty"}} {
+		r, _ := http.NewRequest(req.method, "http://agent"+req.path, nil)
+		resp, err := c.Do(r)
+		if err != nil {
+			t.Fatalf("%s %s error = %v", req.method, req.path, err)
+		}
+		resp.Body.Close()
+	}
+
+	if n := recorder.count(); n != 0 {
+		t.Errorf("streams reset = %d, want 0 for completely delivered responses", n)
+	}
+
+	// 调用方的请求不被修改
+	req, _ := http.NewRequest(http.MethodGet, "http://agent/data", nil)
+	resp, err = c.Transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	resp.Body.Close()
+	if req.URL.String() != "http://agent/data" {
+		t.Errorf("request URL after RoundTrip = %q, want unchanged", req.URL)
+	}
+}
+
+func TestHTTPTunnelBodyIdleTimeout(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer target.Close()
+
+	client, server := newSessionPair(t)
+	StartHTTPServer(server, target.URL)
+	c, _ := DialHTTPWithOptions(client, target.URL, &HTTPOptions{BodyIdleTimeout: 200 * time.Millisecond})
+
+	resp, err := c.Get("http://agent/")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if string(data) != "first" || !errors.Is(err, ErrBodyIdleTimeout) {
+		t.Errorf("ReadAll() = %q, %v; want %q, ErrBodyIdleTimeout", data, err, "first")
+	}
+}
+
+func TestHTTPTunnelUpgrade(t *testing.T) {
+	// 目标服务在 101 之后回显收到的数据
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		rw.Flush()
+		io.Copy(conn, rw)
+	}))
+	defer target.Close()
+
+	for _, opts := range []*HTTPOptions{nil, {HTTP2: true}} {
+		client, server := newSessionPair(t)
+		StartHTTPServer(server, target.URL)
+		c, _ := DialHTTPWithOptions(client, target.URL, opts)
+
+		req, _ := http.NewRequest(http.MethodGet, "http://agent/ws", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "echo")
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("StatusCode = %d, want 101", resp.StatusCode)
+		}
+
+		conn, ok := resp.Body.(io.ReadWriteCloser)
+		if !ok {
+			t.Fatal("101 response body is not io.ReadWriteCloser")
+		}
+		conn.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+			t.Errorf("ReadFull() = %q, %v; want %q", buf, err, "ping")
+		}
+		conn.Close()
+	}
+}
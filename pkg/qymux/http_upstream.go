@@ -0,0 +1,245 @@
+package qymux
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// inClusterCAFile Kubernetes Pod 内的服务账号 CA 证书
+	inClusterCAFile = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+
+	// inClusterTokenFile Kubernetes Pod 内的服务账号令牌
+	inClusterTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	// tokenReloadInterval 令牌文件的重新读取间隔，适配自动轮换的服务账号令牌
+	tokenReloadInterval = time.Minute
+)
+
+// UpstreamOptions 定义 HTTP 服务端连接上游时的传输配置
+// 零值表示：使用系统 CA，不使用代理（不读取 HTTP_PROXY 等环境变量），连接池参数与 http.DefaultTransport 一致
+type UpstreamOptions struct {
+	// CAFile 用于校验上游证书的 PEM 格式 CA 文件，与 CAData 可同时使用
+	CAFile string
+
+	// CAData PEM 格式的 CA 证书
+	CAData []byte
+
+	// CertFile、KeyFile 提供给上游的客户端证书（mTLS）
+	CertFile string
+	KeyFile  string
+
+	// ServerName 校验上游证书时使用的服务器名，为空时使用上游地址中的主机名
+	ServerName string
+
+	// InsecureSkipVerify 跳过上游证书校验，仅用于测试
+	InsecureSkipVerify bool
+
+	// Headers 转发前注入的请求头，会覆盖请求中的同名头，例如 Authorization
+	Headers map[string]string
+
+	// BearerTokenFile 令牌文件，每次转发时以 "Authorization: Bearer <token>" 注入
+	// 文件每分钟重新读取一次，以支持自动轮换的令牌
+	BearerTokenFile string
+
+	// Proxy 连接上游时使用的代理，为 nil 时直连
+	// 需要读取环境变量时可显式设置为 http.ProxyFromEnvironment
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// DialTimeout 建立 TCP 连接的超时时间，0 表示默认值 (30s)
+	DialTimeout time.Duration
+
+	// TLSHandshakeTimeout TLS 握手超时时间，0 表示默认值 (10s)
+	TLSHandshakeTimeout time.Duration
+
+	// ResponseHeaderTimeout 等待上游响应头的超时时间，0 表示不限制
+	ResponseHeaderTimeout time.Duration
+
+	// MaxIdleConns 连接池的最大空闲连接数，0 表示默认值 (100)
+	MaxIdleConns int
+
+	// MaxIdleConnsPerHost 每个上游的最大空闲连接数，0 表示默认值 (2)
+	MaxIdleConnsPerHost int
+
+	// MaxConnsPerHost 每个上游的最大连接数，0 表示不限制
+	MaxConnsPerHost int
+
+	// IdleConnTimeout 空闲连接的关闭时间，0 表示默认值 (90s)
+	IdleConnTimeout time.Duration
+}
+
+// InClusterUpstreamOptions 返回在 Kubernetes Pod 内访问 API Server 的上游配置：
+// 使用服务账号的 CA 证书校验 https://kubernetes.default.svc，并注入服务账号令牌
+func InClusterUpstreamOptions() *UpstreamOptions {
+	return &UpstreamOptions{
+		CAFile:          inClusterCAFile,
+		BearerTokenFile: inClusterTokenFile,
+	}
+}
+
+// NewUpstreamTransport 根据配置创建连接上游的 http.RoundTripper
+// 返回值可用于 HTTPOptions.UpstreamTransport 或 HTTPRoute.Transport
+func NewUpstreamTransport(opts *UpstreamOptions) (http.RoundTripper, error) {
+	if opts == nil {
+		opts = &UpstreamOptions{}
+	}
+
+	tlsConfig, err := opts.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   durationOr(opts.DialTimeout, 30*time.Second),
+		KeepAlive: 30 * time.Second,
+	}
+	base := &http.Transport{
+		Proxy:                 opts.Proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   durationOr(opts.TLSHandshakeTimeout, 10*time.Second),
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		MaxIdleConns:          intOr(opts.MaxIdleConns, 100),
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       opts.MaxConnsPerHost,
+		IdleConnTimeout:       durationOr(opts.IdleConnTimeout, 90*time.Second),
+	}
+
+	if len(opts.Headers) == 0 && opts.BearerTokenFile == "" {
+		return base, nil
+	}
+
+	t := &headerTransport{
+		base:      base,
+		headers:   make(http.Header),
+		tokenFile: opts.BearerTokenFile,
+	}
+	for name, value := range opts.Headers {
+		t.headers.Set(name, value)
+	}
+	if t.tokenFile != "" {
+		// 启动时校验令牌文件可读
+		if _, err := t.token(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// tlsConfig 根据配置创建 TLS 配置
+func (o *UpstreamOptions) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if o.CAFile != "" || len(o.CAData) > 0 {
+		pool := x509.NewCertPool()
+		caData := o.CAData
+		if o.CAFile != "" {
+			data, err := os.ReadFile(o.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("read upstream CA file failed: %w", err)
+			}
+			caData = append(append([]byte{}, caData...), data...)
+		}
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, errors.New("no valid certificates in upstream CA")
+		}
+		config.RootCAs = pool
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load upstream client certificate failed: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// headerTransport 在转发前注入请求头
+type headerTransport struct {
+	base      *http.Transport
+	headers   http.Header
+	tokenFile string
+
+	mu       sync.Mutex
+	cached   string
+	loadedAt time.Time
+}
+
+// RoundTrip 注入请求头后转发请求，不修改原请求
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, values := range t.headers {
+		req.Header[name] = values
+	}
+
+	if t.tokenFile != "" {
+		token, err := t.token()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// CloseIdleConnections 关闭空闲的上游连接
+func (t *headerTransport) CloseIdleConnections() {
+	t.base.CloseIdleConnections()
+}
+
+// token 返回令牌，超过 tokenReloadInterval 后重新读取文件
+// 读取失败时继续使用上一次的令牌
+func (t *headerTransport) token() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.cached != "" && time.Since(t.loadedAt) < tokenReloadInterval {
+		return t.cached, nil
+	}
+
+	data, err := os.ReadFile(t.tokenFile)
+	if err != nil {
+		if t.cached != "" {
+			return t.cached, nil
+		}
+		return "", fmt.Errorf("read bearer token file failed: %w", err)
+	}
+
+	t.cached = strings.TrimSpace(string(data))
+	t.loadedAt = time.Now()
+	return t.cached, nil
+}
+
+// durationOr 在 d 未设置时返回默认值
+func durationOr(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+// intOr 在 n 未设置时返回默认值
+func intOr(n, def int) int {
+	if n <= 0 {
+		return def
+	}
+	return n
+}
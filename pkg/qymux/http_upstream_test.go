@@ -0,0 +1,79 @@
+package qymux
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHTTPTunnelUpstreamTLS(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization") + " " + r.Header.Get("X-Tenant")))
+	}))
+	defer target.Close()
+
+	caData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: target.Certificate().Raw})
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(tokenFile, []byte("secret\n"), 0600)
+
+	upstream, err := NewUpstreamTransport(&UpstreamOptions{
+		CAData:          caData,
+		BearerTokenFile: tokenFile,
+		Headers:         map[string]string{"X-Tenant": "team-a"},
+	})
+	if err != nil {
+		t.Fatalf("NewUpstreamTransport() error = %v", err)
+	}
+
+	client, server := newSessionPair(t)
+	StartHTTPServerWithOptions(server, target.URL, &HTTPOptions{UpstreamTransport: upstream})
+
+	c, _ := DialHTTP(client, target.URL)
+	resp, err := c.Get("http://agent/")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if got, want := string(body), "Bearer secret team-a"; resp.StatusCode != http.StatusOK || got != want {
+		t.Errorf("response = %d %q, want 200 %q", resp.StatusCode, got, want)
+	}
+}
+
+func TestHTTPTunnelUpstreamUntrusted(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	// 默认配置使用系统 CA，不信任测试证书
+	client, server := newSessionPair(t)
+	StartHTTPServer(server, target.URL)
+
+	c, _ := DialHTTP(client, target.URL)
+	resp, err := c.Get("http://agent/")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("StatusCode = %d, want 502", resp.StatusCode)
+	}
+}
+
+func TestNewUpstreamTransportInvalid(t *testing.T) {
+	invalid := []*UpstreamOptions{
+		{CAData: []byte("not a certificate")},
+		{CAFile: filepath.Join(t.TempDir(), "missing.crt")},
+		{CertFile: "missing.crt", KeyFile: "missing.key"},
+		{BearerTokenFile: filepath.Join(t.TempDir(), "missing")},
+	}
+	for _, opts := range invalid {
+		if _, err := NewUpstreamTransport(opts); err == nil {
+			t.Errorf("NewUpstreamTransport(%+v) should fail", opts)
+		}
+	}
+}
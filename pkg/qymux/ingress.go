@@ -0,0 +1,215 @@
+package qymux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+
+	"github.com/funcx27/qymux/pkg/registry"
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+var (
+	// ErrUnknownAgent 无法从请求中确定目标 Agent
+	ErrUnknownAgent = errors.New("qymux: unknown agent")
+
+	// ErrAgentOffline 目标 Agent 没有在线的会话
+	ErrAgentOffline = errors.New("qymux: agent offline")
+)
+
+// SessionRegistry 按名称管理 Agent 会话，详见 registry.Registry
+type SessionRegistry = registry.Registry
+
+// NewSessionRegistry 创建会话注册表
+func NewSessionRegistry() *SessionRegistry {
+	return registry.New()
+}
+
+// IngressOptions 定义 HTTP 入口的可选配置
+// Domain 和 PathPrefix 至少设置一个，同时设置时先按主机名匹配
+type IngressOptions struct {
+	// Domain 按主机名路由的域名，例如 "tunnel.local"：<agent>.tunnel.local 的请求转发到名为 agent 的会话
+	Domain string
+
+	// PathPrefix 按路径路由的前缀，例如 "/agents"：/agents/<agent>/api 的请求转发到 agent，路径改写为 /api
+	PathPrefix string
+
+	// HTTP 通过隧道转发时使用的配置，与 DialHTTPWithOptions 相同，其中的 Agent 按请求所选的 Agent 设置
+	HTTP *HTTPOptions
+
+	// ErrorHandler 自定义错误页，为 nil 时返回简单的 HTML 错误页
+	// Agent 不在线时 err 为 ErrAgentOffline，无法确定 Agent 时为 ErrUnknownAgent
+	ErrorHandler func(w http.ResponseWriter, req *http.Request, status int, err error)
+}
+
+// ingressKey 请求上下文中保存目标会话的键
+type ingressKey struct{}
+
+// ingressTarget 是请求选中的 Agent
+type ingressTarget struct {
+	agent     string
+	transport http.RoundTripper
+}
+
+// Ingress 是将内部用户的 HTTP 请求转发到 Agent 服务的 http.Handler
+// 通过 GetHTTPDialer 在 Agent 会话上发送请求，由 Agent 端的 StartHTTPServer 转发到实际服务；
+// 设置 X-Forwarded-For/Host/Proto 头，流式响应立即刷新，Upgrade 请求原样透传
+type Ingress struct {
+	registry *SessionRegistry
+	opts     IngressOptions
+	proxy    *httputil.ReverseProxy
+
+	mu         sync.Mutex
+	transports map[transport.MuxSession]http.RoundTripper
+}
+
+// NewIngress 创建 HTTP 入口，按 opts 从 registry 中选择 Agent 会话
+func NewIngress(registry *SessionRegistry, opts *IngressOptions) (*Ingress, error) {
+	if opts == nil || (opts.Domain == "" && opts.PathPrefix == "") {
+		return nil, errors.New("qymux: ingress requires Domain or PathPrefix")
+	}
+
+	i := &Ingress{
+		registry:   registry,
+		opts:       *opts,
+		transports: make(map[transport.MuxSession]http.RoundTripper),
+	}
+	i.opts.Domain = strings.ToLower(strings.Trim(opts.Domain, "."))
+	if opts.PathPrefix != "" {
+		i.opts.PathPrefix = "/" + strings.Trim(opts.PathPrefix, "/")
+	}
+
+	i.proxy = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			// 入站请求中的 X-Forwarded-* 已被移除，这里按实际客户端重新设置
+			pr.SetXForwarded()
+		},
+		Transport:     ingressTransport{},
+		FlushInterval: -1, // 立即刷新，支持 Watch、Logs 等流式响应
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			log.Printf("[Qymux-Ingress] %s %s: %v", req.Method, req.URL.Path, err)
+			i.writeError(w, req, http.StatusBadGateway, err)
+		},
+	}
+	return i, nil
+}
+
+// ServeHTTP 选择 Agent 会话并转发请求
+func (i *Ingress) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	agent, path, ok := i.resolve(req)
+	if !ok {
+		i.writeError(w, req, http.StatusNotFound, ErrUnknownAgent)
+		return
+	}
+
+	sess, ok := i.registry.Get(agent)
+	if !ok {
+		i.writeError(w, req, http.StatusBadGateway, fmt.Errorf("%w: %s", ErrAgentOffline, agent))
+		return
+	}
+
+	rt, err := i.transport(agent, sess)
+	if err != nil {
+		i.writeError(w, req, http.StatusInternalServerError, err)
+		return
+	}
+
+	if path != req.URL.Path {
+		req = req.Clone(req.Context())
+		req.URL.Path = path
+		req.URL.RawPath = ""
+	}
+	ctx := context.WithValue(req.Context(), ingressKey{}, &ingressTarget{agent: agent, transport: rt})
+	i.proxy.ServeHTTP(w, req.WithContext(ctx))
+}
+
+// resolve 从请求中解析 Agent 名称和转发路径
+func (i *Ingress) resolve(req *http.Request) (agent, path string, ok bool) {
+	if i.opts.Domain != "" {
+		host := strings.ToLower(req.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if name, found := strings.CutSuffix(host, "."+i.opts.Domain); found && name != "" {
+			return name, req.URL.Path, true
+		}
+	}
+
+	if i.opts.PathPrefix != "" {
+		rest, found := strings.CutPrefix(req.URL.Path, i.opts.PathPrefix+"/")
+		if !found {
+			return "", "", false
+		}
+		name, path, _ := strings.Cut(rest, "/")
+		if name == "" {
+			return "", "", false
+		}
+		return name, "/" + path, true
+	}
+
+	return "", "", false
+}
+
+// transport 返回会话对应的 RoundTripper，同一会话复用以保留 HTTP/2 连接池
+// 会话关闭后移除缓存
+func (i *Ingress) transport(agent string, sess transport.MuxSession) (http.RoundTripper, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if rt, ok := i.transports[sess]; ok {
+		return rt, nil
+	}
+
+	// 访问日志中记录请求所选的 Agent
+	var opts HTTPOptions
+	if i.opts.HTTP != nil {
+		opts = *i.opts.HTTP
+	}
+	opts.Agent = agent
+
+	rt, err := GetHTTPDialerWithOptions(sess, "http://"+agent, &opts)
+	if err != nil {
+		return nil, err
+	}
+	i.transports[sess] = rt
+
+	if done := transport.SessionDone(sess); done != nil {
+		go func() {
+			<-done
+			i.mu.Lock()
+			delete(i.transports, sess)
+			i.mu.Unlock()
+		}()
+	}
+	return rt, nil
+}
+
+// writeError 返回错误页
+func (i *Ingress) writeError(w http.ResponseWriter, req *http.Request, status int, err error) {
+	if i.opts.ErrorHandler != nil {
+		i.opts.ErrorHandler(w, req, status, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><title>%d %s</title></head><body><h1>%d %s</h1><p>%s</p></body></html>\n",
+		status, http.StatusText(status), status, http.StatusText(status), html.EscapeString(err.Error()))
+}
+
+// ingressTransport 使用请求所选会话的 RoundTripper 转发
+type ingressTransport struct{}
+
+// RoundTrip 转发请求
+func (ingressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target := req.Context().Value(ingressKey{}).(*ingressTarget)
+	return target.transport.RoundTrip(req)
+}
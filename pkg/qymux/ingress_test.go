@@ -0,0 +1,61 @@
+package qymux
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIngress(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path + " " + r.Header.Get("X-Forwarded-Host") + " " + r.Header.Get("X-Forwarded-Proto")))
+	}))
+	defer target.Close()
+
+	client, server := newSessionPair(t)
+	StartHTTPServer(server, target.URL)
+
+	reg := NewSessionRegistry()
+	reg.Register("edge1", client)
+
+	ingress, err := NewIngress(reg, &IngressOptions{Domain: "tunnel.local", PathPrefix: "/agents"})
+	if err != nil {
+		t.Fatalf("NewIngress() error = %v", err)
+	}
+	front := httptest.NewServer(ingress)
+	defer front.Close()
+
+	get := func(host, path string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, front.URL+path, nil)
+		req.Host = host
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if status, body := get("edge1.tunnel.local", "/api"); status != http.StatusOK || body != "/api edge1.tunnel.local http" {
+		t.Errorf("host routing = %d %q", status, body)
+	}
+	if status, body := get("ingress", "/agents/edge1/api/pods"); status != http.StatusOK || body != "/api/pods ingress http" {
+		t.Errorf("path routing = %d %q", status, body)
+	}
+	if status, body := get("edge2.tunnel.local", "/"); status != http.StatusBadGateway || !strings.Contains(body, "agent offline") {
+		t.Errorf("offline agent = %d %q", status, body)
+	}
+	if status, _ := get("ingress", "/other"); status != http.StatusNotFound {
+		t.Errorf("unknown agent status = %d, want 404", status)
+	}
+
+	// 会话关闭后自动注销
+	client.Close()
+	if status, _ := get("edge1.tunnel.local", "/"); status != http.StatusBadGateway {
+		t.Errorf("closed agent status = %d, want 502", status)
+	}
+}
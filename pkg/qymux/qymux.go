@@ -1,17 +1,13 @@
 package qymux
 
 import (
-	"context"
 	"crypto/tls"
-	"log"
-	"net"
-	"time"
+	"errors"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/grpc/keepalive"
 
 	"github.com/funcx27/qymux/pkg/dialer"
+	"github.com/funcx27/qymux/pkg/grpctunnel"
 	"github.com/funcx27/qymux/pkg/transport"
 )
 
@@ -34,6 +30,12 @@ type Config struct {
 
 	// ListenAddr 监听地址（用于 Server 模式）
 	ListenAddr string
+
+	// Listener 监听器可选配置（用于 Server 模式），如 PROXY 协议解析
+	Listener *transport.ListenerOptions
+
+	// Session 会话可选配置，如入站流并发上限和积压策略
+	Session *transport.SessionOptions
 }
 
 // New 创建新的 Qymux 实例
@@ -47,6 +49,7 @@ func New(config *Config) *Qymux {
 	transportConfig := &transport.Config{
 		Mode:      config.Mode,
 		TLSConfig: config.TLSConfig,
+		Session:   config.Session,
 	}
 
 	return &Qymux{
@@ -65,49 +68,86 @@ func (q *Qymux) Listen() (*dialer.Listener, error) {
 	return dialer.NewListener(q.config.ListenAddr, &transport.Config{
 		Mode:      q.config.Mode,
 		TLSConfig: q.config.TLSConfig,
+		Listener:  q.config.Listener,
+		Session:   q.config.Session,
 	})
 }
 
+// GRPCOptions 定义隧道 gRPC 客户端的可选配置，详见 grpctunnel.Options
+type GRPCOptions = grpctunnel.Options
+
 // DialAgent 在 Server 端连接 Agent（gRPC 隧道）
 // 这个函数实现了 gRPC 隧道适配器，将 MuxSession 转换为 gRPC 客户端连接
+// opts 在默认选项之后应用，例如 grpc.WithKeepaliveParams 可以覆盖默认的保活参数
 func DialAgent(sess transport.MuxSession, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
-	// 配置 gRPC Keepalive，快速检测连接断开
-	kacp := keepalive.ClientParameters{
-		Time:                30 * time.Second, // 每30秒发送一次 ping（避免 too_many_pings）
-		Timeout:             2 * time.Second,  // ping 超时2秒认为连接断开
-		PermitWithoutStream: true,            // 没有活跃 stream 也发送 ping
-	}
+	return DialAgentWithOptions(sess, &GRPCOptions{DialOptions: opts})
+}
 
-	defaultOpts := []grpc.DialOption{
-		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
-			// 每次发起请求时，在多路复用 Session 上打开一个新流
-			return sess.OpenStream()
-		}),
-		grpc.WithTransportCredentials(insecure.NewCredentials()), // 隧道已加密，gRPC 层使用 Insecure
-		grpc.WithKeepaliveParams(kacp),                          // 启用 keepalive
-	}
+// DialAgentWithOptions 使用可选配置在 sess 上创建 gRPC 客户端，连接在第一次 RPC 时建立
+func DialAgentWithOptions(sess transport.MuxSession, opts *GRPCOptions) (*grpc.ClientConn, error) {
+	return grpctunnel.NewClient(grpctunnel.Target("agent"), grpctunnel.Single(sess), opts)
+}
+
+// DialAgentTarget 按目标 qymux:///<agent> 从 sessions 中选择 Agent 会话创建 gRPC 客户端
+// sessions 为 *SessionRegistry 时，Agent 重新接入（注册了新的会话）后，gRPC 重连时使用新的会话；
+// 为 *SessionPool 时，RPC 在同名的所有会话之间轮询，会话关闭后自动移除
+func DialAgentTarget(sessions grpctunnel.SessionLookup, target string, opts *GRPCOptions) (*grpc.ClientConn, error) {
+	return grpctunnel.NewClient(target, sessions, opts)
+}
 
-	opts = append(defaultOpts, opts...)
+// SessionPool 按名称分组的动态会话集合，详见 grpctunnel.Pool
+type SessionPool = grpctunnel.Pool
 
-	return grpc.Dial("", opts...)
+// NewSessionPool 创建会话池
+func NewSessionPool() *SessionPool {
+	return grpctunnel.NewPool()
+}
+
+// GRPCProxy 将本地 gRPC 请求透明转发到 Agent 的代理，详见 grpctunnel.Proxy
+type GRPCProxy = grpctunnel.Proxy
+
+// GRPCProxyOptions 定义 gRPC 透明代理的可选配置
+type GRPCProxyOptions = grpctunnel.ProxyOptions
+
+// NewGRPCProxy 创建 gRPC 透明代理 (Server 端)，默认按请求元数据 x-qymux-agent 从 sessions 中选择 Agent
+func NewGRPCProxy(sessions grpctunnel.SessionLookup, opts *GRPCProxyOptions) *GRPCProxy {
+	return grpctunnel.NewProxy(sessions, opts)
+}
+
+// GRPCServer 管理会话上的 gRPC 服务器的生命周期，详见 grpctunnel.Server
+type GRPCServer = grpctunnel.Server
+
+// GRPCServerOptions 定义隧道 gRPC 服务器的可选配置，如随隧道状态更新的健康检查服务
+type GRPCServerOptions = grpctunnel.ServerOptions
+
+// ServeGRPC 在 Session 上启动 gRPC 服务器并返回管理句柄 (Agent 端)
+// 会话断开后可以在句柄上调用 Serve 使用重新建立的会话继续服务。
+// 与 StartGRPCServer 不同，停止服务器不会关闭会话，会话由调用方关闭
+func ServeGRPC(sess transport.MuxSession, server *grpc.Server, opts *GRPCServerOptions) (*GRPCServer, error) {
+	s := grpctunnel.NewServer(server, opts)
+	if err := s.Serve(sess); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
 // StartGRPCServer 在 Session 上启动 gRPC 服务器
 // 这个函数用于 Agent 端，在 MuxSession 上启动 gRPC 服务
-// 返回一个 channel，当 server.Serve() 退出时会关闭该 channel
+// 返回一个 channel，当 server.Serve() 退出时会关闭该 channel；server 被 Stop 或 GracefulStop 时 sess 随之关闭。
+// 需要退出原因、保留会话的优雅停止或健康检查时使用 ServeGRPC
 func StartGRPCServer(sess transport.MuxSession, server *grpc.Server) (<-chan struct{}, error) {
-	log.Printf("[Qymux] 在 %s 协议上启动 gRPC 服务器", sess.Protocol())
+	s, err := ServeGRPC(sess, server, nil)
+	if err != nil {
+		return nil, err
+	}
 
-	// 创建退出通知 channel
 	stopped := make(chan struct{})
-
-	// MuxSession 已经实现了 net.Listener 接口
-	// 直接在 MuxSession 上启动 gRPC 服务器
 	go func() {
-		server.Serve(sess) // 当连接断开时，Serve() 会返回
-		close(stopped)     // 通知主循环 server 已停止
+		if err := s.Wait(); err == nil || errors.Is(err, grpc.ErrServerStopped) {
+			// 服务器被停止，与 server.Serve(sess) 一样关闭会话
+			sess.Close()
+		}
+		close(stopped) // 通知主循环 server 已停止
 	}()
-
 	return stopped, nil
 }
-
@@ -2,6 +2,9 @@ package qymux
 
 import (
 	"testing"
+	"time"
+
+	"google.golang.org/grpc"
 
 	"github.com/funcx27/qymux/pkg/transport"
 )
@@ -26,3 +29,22 @@ func TestNew(t *testing.T) {
 		})
 	}
 }
+
+func TestStartGRPCServerStop(t *testing.T) {
+	_, sess := newSessionPair(t)
+	server := grpc.NewServer()
+	stopped, err := StartGRPCServer(sess, server)
+	if err != nil {
+		t.Fatalf("StartGRPCServer() error = %v", err)
+	}
+
+	// 停止服务器后会话随之关闭
+	server.Stop()
+	for _, ch := range []<-chan struct{}{stopped, transport.SessionDone(sess)} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatal("session not closed after server Stop()")
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package qymux
+
+import (
+	"github.com/funcx27/qymux/pkg/service"
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// ServiceMux 按服务名分发会话上的流，详见 service.Mux
+type ServiceMux = service.Mux
+
+// NewServiceMux 在会话上创建服务分发器
+// 之后通过 mux.Listen(name) 获取各服务的监听器，不应再直接调用会话的 Accept
+func NewServiceMux(sess transport.MuxSession) *ServiceMux {
+	return service.NewMux(sess)
+}
+
+// OpenServiceStream 在会话上打开一个指向对端 name 服务的流
+func OpenServiceStream(sess transport.MuxSession, name string, metadata map[string]string) (transport.Stream, error) {
+	return service.OpenServiceStream(sess, name, metadata)
+}
@@ -0,0 +1,29 @@
+package qymux
+
+import (
+	"fmt"
+
+	"github.com/funcx27/qymux/pkg/socks5"
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// SOCKS5Server 是出站连接经由 Agent 拨号的 SOCKS5 代理，详见 socks5.Server
+type SOCKS5Server = socks5.Server
+
+// NewSOCKS5Server 创建 SOCKS5 代理，之后调用 Serve(ln) 处理客户端连接
+// 目标由 sess 对端的 ForwardServer 拨号；opts.Session 不为 nil 时按用户名选择会话，sess 可以为 nil
+func NewSOCKS5Server(sess transport.MuxSession, opts *socks5.Options) (*SOCKS5Server, error) {
+	return socks5.NewServer(sess, opts)
+}
+
+// AgentSession 返回按 Agent 名称从 registry 选择会话的函数，可用作 socks5.Options.Session
+// Agent 不在线时返回 ErrAgentOffline
+func AgentSession(registry *SessionRegistry) func(agent string) (transport.MuxSession, error) {
+	return func(agent string) (transport.MuxSession, error) {
+		sess, ok := registry.Get(agent)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrAgentOffline, agent)
+		}
+		return sess, nil
+	}
+}
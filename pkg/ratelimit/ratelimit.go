@@ -0,0 +1,136 @@
+// Package ratelimit 提供令牌桶限速器
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter 实现令牌桶算法，可并发使用
+// rate 为每秒补充的令牌数，burst 为桶容量；rate <= 0 表示不限速
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter 创建令牌桶限速器，初始时桶是满的
+func NewLimiter(rate float64, burst int) *Limiter {
+	l := &Limiter{}
+	l.SetLimit(rate, burst)
+	l.tokens = l.burst
+	return l
+}
+
+// SetLimit 在运行时调整速率和桶容量
+// burst <= 0 时使用 max(1, rate) 作为容量
+func (l *Limiter) SetLimit(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.advance(now)
+
+	l.rate = rate
+	l.burst = float64(burst)
+	if l.burst <= 0 {
+		l.burst = rate
+		if l.burst < 1 {
+			l.burst = 1
+		}
+	}
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// Limit 返回当前速率，<= 0 表示不限速
+func (l *Limiter) Limit() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Burst 返回桶容量
+func (l *Limiter) Burst() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.burst)
+}
+
+// Allow 尝试消耗一个令牌
+func (l *Limiter) Allow() bool {
+	return l.AllowN(time.Now(), 1)
+}
+
+// AllowN 尝试在 now 时刻消耗 n 个令牌，令牌不足时不消耗并返回 false
+func (l *Limiter) AllowN(now time.Time, n int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return true
+	}
+
+	l.advance(now)
+	if l.tokens < float64(n) {
+		return false
+	}
+	l.tokens -= float64(n)
+	return true
+}
+
+// WaitN 阻塞直到可以消耗 n 个令牌，或 ctx 结束
+// 令牌会被预先扣除，因此 n 可以超过桶容量，但等待时间会相应变长
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+
+	l.advance(time.Now())
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// 归还预先扣除的令牌
+		l.mu.Lock()
+		l.tokens += float64(n)
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// advance 按流逝的时间补充令牌，调用方需持有锁
+func (l *Limiter) advance(now time.Time) {
+	if !l.last.IsZero() && now.After(l.last) && l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	if now.After(l.last) {
+		l.last = now
+	}
+}
@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiterAllowN(t *testing.T) {
+	l := NewLimiter(10, 5)
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		if !l.AllowN(now, 1) {
+			t.Fatalf("AllowN() #%d = false, want true within burst", i)
+		}
+	}
+	if l.AllowN(now, 1) {
+		t.Error("AllowN() should fail after burst is exhausted")
+	}
+
+	// 100ms 后应补充 1 个令牌
+	if !l.AllowN(now.Add(100*time.Millisecond), 1) {
+		t.Error("AllowN() should succeed after refill")
+	}
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	l := NewLimiter(0, 0)
+	for i := 0; i < 1000; i++ {
+		if !l.Allow() {
+			t.Fatal("Allow() with zero rate should always succeed")
+		}
+	}
+}
+
+func TestLimiterSetLimit(t *testing.T) {
+	l := NewLimiter(1, 1)
+	now := time.Now()
+	l.AllowN(now, 1)
+
+	l.SetLimit(0, 0)
+	if !l.AllowN(now, 1) {
+		t.Error("AllowN() should succeed after limit removed")
+	}
+	if l.Limit() != 0 {
+		t.Errorf("Limit() = %v, want 0", l.Limit())
+	}
+}
+
+func TestLimiterWaitN(t *testing.T) {
+	l := NewLimiter(1000, 100)
+
+	start := time.Now()
+	if err := l.WaitN(context.Background(), 100); err != nil {
+		t.Fatalf("WaitN() within burst error = %v", err)
+	}
+	if err := l.WaitN(context.Background(), 100); err != nil {
+		t.Fatalf("WaitN() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("WaitN() returned after %v, want about 100ms", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.WaitN(ctx, 1000); err == nil {
+		t.Error("WaitN() should fail when ctx expires first")
+	}
+}
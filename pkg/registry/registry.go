@@ -0,0 +1,72 @@
+// Package registry 按名称管理一组会话
+// Server 端通常为每个接入的 Agent 注册一个会话，HTTP 入口、代理等组件据此找到目标 Agent
+package registry
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// Registry 是名称到会话的映射，并发安全
+// 会话关闭时自动注销（需要会话支持 transport.SessionDone）
+type Registry struct {
+	mu       sync.RWMutex
+	sessions map[string]transport.MuxSession
+}
+
+// New 创建会话注册表
+func New() *Registry {
+	return &Registry{
+		sessions: make(map[string]transport.MuxSession),
+	}
+}
+
+// Register 以 name 注册会话，同名的旧会话被替换但不会被关闭
+func (r *Registry) Register(name string, sess transport.MuxSession) {
+	r.mu.Lock()
+	r.sessions[name] = sess
+	r.mu.Unlock()
+
+	if done := transport.SessionDone(sess); done != nil {
+		go func() {
+			<-done
+			r.Unregister(name, sess)
+		}()
+	}
+}
+
+// Unregister 注销会话，仅当 name 当前对应的仍是 sess 时生效
+func (r *Registry) Unregister(name string, sess transport.MuxSession) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if current, ok := r.sessions[name]; ok && current == sess {
+		delete(r.sessions, name)
+		return true
+	}
+	return false
+}
+
+// Get 返回 name 对应的会话
+func (r *Registry) Get(name string) (transport.MuxSession, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sess, ok := r.sessions[name]
+	return sess, ok
+}
+
+// Names 返回已注册的名称，按字典序排列
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.sessions))
+	for name := range r.sessions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,43 @@
+package registry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/tcp"
+)
+
+func TestRegistry(t *testing.T) {
+	ln, err := tcp.Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+
+	sess, err := tcp.NewDialer(nil).Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+
+	r := New()
+	r.Register("agent", sess)
+	if got, ok := r.Get("agent"); !ok || got != sess {
+		t.Fatalf("Get() = %v, %v", got, ok)
+	}
+	if names := r.Names(); len(names) != 1 || names[0] != "agent" {
+		t.Errorf("Names() = %v", names)
+	}
+
+	// 会话关闭后自动注销
+	sess.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := r.Get("agent"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("session not unregistered after Close")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,155 @@
+// Package service 为会话上的流提供命名服务
+// 流在打开时先发送一个携带服务名和元数据的头部，接收端据此将流分发到对应服务的监听器，
+// 使 gRPC、HTTP 等多个适配器可以共享同一个会话
+package service
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// headerMagic 流头部的魔数，用于尽早识别不支持服务头部的对端
+var headerMagic = [3]byte{'Q', 'Y', 'S'}
+
+// headerVersion 当前头部格式版本
+const headerVersion = 1
+
+const (
+	// MaxServiceNameLength 服务名最大长度
+	MaxServiceNameLength = 255
+
+	// MaxMetadataEntries 元数据最大条目数
+	MaxMetadataEntries = 255
+
+	// maxMetadataKeyLength 元数据键最大长度
+	maxMetadataKeyLength = 255
+
+	// maxMetadataValueLength 元数据值最大长度
+	maxMetadataValueLength = 65535
+)
+
+var (
+	// ErrInvalidHeader 流头部格式错误
+	ErrInvalidHeader = errors.New("service: invalid stream header")
+
+	// ErrHeaderTooLarge 服务名或元数据超出长度限制
+	ErrHeaderTooLarge = errors.New("service: stream header too large")
+)
+
+// Header 流打开时发送的服务头部
+//
+// 编码格式：
+//
+//	magic "QYS" | version (1) | nameLen (1) | name | count (1) | { keyLen (1) | key | valueLen (2) | value }
+type Header struct {
+	// Service 目标服务名
+	Service string
+
+	// Metadata 附加元数据，由接收端的服务自行解释
+	Metadata map[string]string
+}
+
+// WriteHeader 将头部编码写入 w
+func WriteHeader(w io.Writer, h *Header) error {
+	if len(h.Service) == 0 || len(h.Service) > MaxServiceNameLength {
+		return fmt.Errorf("%w: service name length %d", ErrHeaderTooLarge, len(h.Service))
+	}
+	if len(h.Metadata) > MaxMetadataEntries {
+		return fmt.Errorf("%w: %d metadata entries", ErrHeaderTooLarge, len(h.Metadata))
+	}
+
+	buf := make([]byte, 0, 6+len(h.Service))
+	buf = append(buf, headerMagic[:]...)
+	buf = append(buf, headerVersion, byte(len(h.Service)))
+	buf = append(buf, h.Service...)
+	buf = append(buf, byte(len(h.Metadata)))
+
+	// 按键排序，保证编码结果稳定
+	keys := make([]string, 0, len(h.Metadata))
+	for k := range h.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := h.Metadata[k]
+		if len(k) == 0 || len(k) > maxMetadataKeyLength || len(v) > maxMetadataValueLength {
+			return fmt.Errorf("%w: metadata %q", ErrHeaderTooLarge, k)
+		}
+		buf = append(buf, byte(len(k)))
+		buf = append(buf, k...)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(v)))
+		buf = append(buf, v...)
+	}
+
+	// 一次性写入，避免头部被拆分到多个帧
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadHeader 从 r 中读取并解码头部
+// 只读取头部本身的字节，不会预读后续的应用数据
+func ReadHeader(r io.Reader) (*Header, error) {
+	var fixed [5]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, err
+	}
+	if [3]byte(fixed[:3]) != headerMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrInvalidHeader)
+	}
+	if fixed[3] != headerVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidHeader, fixed[3])
+	}
+	if fixed[4] == 0 {
+		return nil, fmt.Errorf("%w: empty service name", ErrInvalidHeader)
+	}
+
+	name, err := readBytes(r, int(fixed[4]))
+	if err != nil {
+		return nil, err
+	}
+
+	var count [1]byte
+	if _, err := io.ReadFull(r, count[:]); err != nil {
+		return nil, err
+	}
+
+	h := &Header{Service: string(name)}
+	if count[0] > 0 {
+		h.Metadata = make(map[string]string, count[0])
+	}
+	for i := 0; i < int(count[0]); i++ {
+		var kl [1]byte
+		if _, err := io.ReadFull(r, kl[:]); err != nil {
+			return nil, err
+		}
+		key, err := readBytes(r, int(kl[0]))
+		if err != nil {
+			return nil, err
+		}
+
+		var vl [2]byte
+		if _, err := io.ReadFull(r, vl[:]); err != nil {
+			return nil, err
+		}
+		value, err := readBytes(r, int(binary.BigEndian.Uint16(vl[:])))
+		if err != nil {
+			return nil, err
+		}
+		h.Metadata[string(key)] = string(value)
+	}
+
+	return h, nil
+}
+
+// readBytes 读取恰好 n 个字节
+func readBytes(r io.Reader, n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
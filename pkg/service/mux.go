@@ -0,0 +1,276 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// DefaultHeaderTimeout 接收端读取流头部的默认超时时间
+const DefaultHeaderTimeout = 10 * time.Second
+
+var (
+	// ErrServiceExists 服务名已被注册
+	ErrServiceExists = errors.New("service: service already registered")
+
+	// ErrMuxClosed 服务分发器已关闭
+	ErrMuxClosed = errors.New("service: mux closed")
+)
+
+// Conn 是携带服务头部的流
+type Conn struct {
+	transport.Stream
+	header *Header
+}
+
+// Header 返回流打开时携带的服务头部
+func (c *Conn) Header() *Header {
+	return c.header
+}
+
+// OpenServiceStream 在会话上打开一个指向 name 服务的流
+// 对端需要使用 Mux 分发流
+func OpenServiceStream(sess transport.MuxSession, name string, metadata map[string]string) (transport.Stream, error) {
+	header := &Header{Service: name, Metadata: metadata}
+
+	stream, err := sess.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteHeader(stream, header); err != nil {
+		stream.Close()
+		return nil, fmt.Errorf("write service header failed: %w", err)
+	}
+	return &Conn{Stream: stream, header: header}, nil
+}
+
+// Mux 从会话接收流，按服务头部分发到各服务的监听器
+// 创建 Mux 后不应再直接调用会话的 Accept
+type Mux struct {
+	sess          transport.MuxSession
+	headerTimeout time.Duration
+
+	mu       sync.Mutex
+	services map[string]*Listener
+	done     chan struct{}
+	err      error
+}
+
+// NewMux 创建服务分发器，并开始从会话接收流
+func NewMux(sess transport.MuxSession) *Mux {
+	m := &Mux{
+		sess:          sess,
+		headerTimeout: DefaultHeaderTimeout,
+		services:      make(map[string]*Listener),
+		done:          make(chan struct{}),
+	}
+
+	go m.acceptLoop()
+
+	return m
+}
+
+// Listen 注册 name 服务并返回它的监听器
+// 返回的监听器同时实现 transport.MuxSession，可直接用于 StartGRPCServer、StartHTTPServer 等适配器，
+// 其 OpenStream 会打开指向对端同名服务的流
+func (m *Mux) Listen(name string) (*Listener, error) {
+	if len(name) == 0 || len(name) > MaxServiceNameLength {
+		return nil, fmt.Errorf("%w: service name length %d", ErrHeaderTooLarge, len(name))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	select {
+	case <-m.done:
+		return nil, ErrMuxClosed
+	default:
+	}
+
+	if _, ok := m.services[name]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrServiceExists, name)
+	}
+
+	l := &Listener{
+		mux:    m,
+		name:   name,
+		conns:  make(chan *Conn, 16),
+		closed: make(chan struct{}),
+	}
+	m.services[name] = l
+	return l, nil
+}
+
+// OpenServiceStream 打开一个指向对端 name 服务的流
+func (m *Mux) OpenServiceStream(name string, metadata map[string]string) (transport.Stream, error) {
+	return OpenServiceStream(m.sess, name, metadata)
+}
+
+// Session 返回底层会话
+func (m *Mux) Session() transport.MuxSession {
+	return m.sess
+}
+
+// Close 关闭所有服务监听器和底层会话
+func (m *Mux) Close() error {
+	return m.sess.Close()
+}
+
+// acceptLoop 持续接收流，在独立 goroutine 中读取头部并分发
+func (m *Mux) acceptLoop() {
+	for {
+		stream, err := m.sess.AcceptStream()
+		if err != nil {
+			m.shutdown(err)
+			return
+		}
+
+		go m.dispatch(stream)
+	}
+}
+
+// dispatch 读取流头部并交给对应服务的监听器
+func (m *Mux) dispatch(stream transport.Stream) {
+	stream.SetReadDeadline(time.Now().Add(m.headerTimeout))
+	header, err := ReadHeader(stream)
+	stream.SetReadDeadline(time.Time{})
+	if err != nil {
+		log.Printf("[Qymux-Service] Read stream header failed: %v", err)
+		stream.Close()
+		return
+	}
+
+	m.mu.Lock()
+	l, ok := m.services[header.Service]
+	m.mu.Unlock()
+	if !ok {
+		log.Printf("[Qymux-Service] Unknown service %q", header.Service)
+		stream.Reset(transport.CodeRefused)
+		stream.Close()
+		return
+	}
+
+	conn := &Conn{Stream: stream, header: header}
+	select {
+	case l.conns <- conn:
+		// 监听器同时关闭时 select 可能仍选择入队，Close 的清理可能已经结束，这里再清理一次
+		select {
+		case <-l.closed:
+			l.refuseQueued()
+		default:
+		}
+	case <-l.closed:
+		stream.Reset(transport.CodeRefused)
+		stream.Close()
+	case <-m.done:
+		stream.Close()
+	}
+}
+
+// shutdown 在会话结束时关闭所有服务监听器
+func (m *Mux) shutdown(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.err = err
+	close(m.done)
+	for name, l := range m.services {
+		l.closeOnce.Do(func() { close(l.closed) })
+		delete(m.services, name)
+	}
+}
+
+// remove 注销服务
+func (m *Mux) remove(l *Listener) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.services[l.name] == l {
+		delete(m.services, l.name)
+	}
+}
+
+// Listener 是单个服务的监听器，实现 net.Listener 和 transport.MuxSession
+type Listener struct {
+	mux       *Mux
+	name      string
+	conns     chan *Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Accept 接收发往该服务的流
+func (l *Listener) Accept() (net.Conn, error) {
+	return l.AcceptStream()
+}
+
+// AcceptStream 接收发往该服务的流
+func (l *Listener) AcceptStream() (transport.Stream, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		select {
+		case <-l.mux.done:
+			return nil, l.mux.err
+		default:
+			return nil, net.ErrClosed
+		}
+	}
+}
+
+// OpenStream 打开一个指向对端同名服务的流
+func (l *Listener) OpenStream() (transport.Stream, error) {
+	return l.mux.OpenServiceStream(l.name, nil)
+}
+
+// Name 返回服务名
+func (l *Listener) Name() string {
+	return l.name
+}
+
+// Protocol 返回底层会话的协议
+func (l *Listener) Protocol() string {
+	return l.mux.sess.Protocol()
+}
+
+// RemoteAddr 返回底层会话的对端地址
+func (l *Listener) RemoteAddr() net.Addr {
+	return l.mux.sess.RemoteAddr()
+}
+
+// Addr 返回底层会话的本地地址
+func (l *Listener) Addr() net.Addr {
+	return l.mux.sess.Addr()
+}
+
+// Done 返回底层会话关闭时关闭的 channel
+func (l *Listener) Done() <-chan struct{} {
+	return l.mux.done
+}
+
+// Close 注销服务，不会关闭底层会话
+// 已分发但尚未被 Accept 的流以 transport.CodeRefused 重置
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	l.mux.remove(l)
+	l.refuseQueued()
+	return nil
+}
+
+// refuseQueued 以 transport.CodeRefused 重置队列中尚未被 Accept 的流
+func (l *Listener) refuseQueued() {
+	for {
+		select {
+		case conn := <-l.conns:
+			conn.Reset(transport.CodeRefused)
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
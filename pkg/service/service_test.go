@@ -0,0 +1,183 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/tcp"
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	want := &Header{
+		Service:  "http",
+		Metadata: map[string]string{"upstream": "api", "trace": "abc"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteHeader(&buf, want); err != nil {
+		t.Fatalf("WriteHeader() error = %v", err)
+	}
+	buf.WriteString("payload")
+
+	got, err := ReadHeader(&buf)
+	if err != nil {
+		t.Fatalf("ReadHeader() error = %v", err)
+	}
+	if got.Service != want.Service || len(got.Metadata) != 2 || got.Metadata["upstream"] != "api" {
+		t.Errorf("ReadHeader() = %+v, want %+v", got, want)
+	}
+	if buf.String() != "payload" {
+		t.Errorf("remaining data = %q, want %q", buf.String(), "payload")
+	}
+}
+
+func TestHeaderInvalid(t *testing.T) {
+	if err := WriteHeader(io.Discard, &Header{}); !errors.Is(err, ErrHeaderTooLarge) {
+		t.Errorf("WriteHeader() with empty name error = %v", err)
+	}
+
+	_, err := ReadHeader(bytes.NewReader([]byte("GET / HTTP/1.1\r\n")))
+	if !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("ReadHeader() error = %v, want ErrInvalidHeader", err)
+	}
+}
+
+// newSessionPair 在本地回环上建立一对 TCP 会话
+func newSessionPair(t *testing.T) (client, server transport.MuxSession) {
+	t.Helper()
+
+	ln, err := tcp.Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	client, err = tcp.NewDialer(nil).Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	server, err = ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	return client, server
+}
+
+func TestMuxDispatch(t *testing.T) {
+	client, server := newSessionPair(t)
+
+	mux := NewMux(server)
+	grpcLn, err := mux.Listen("grpc")
+	if err != nil {
+		t.Fatalf("Listen(grpc) error = %v", err)
+	}
+	httpLn, err := mux.Listen("http")
+	if err != nil {
+		t.Fatalf("Listen(http) error = %v", err)
+	}
+	if _, err := mux.Listen("http"); !errors.Is(err, ErrServiceExists) {
+		t.Errorf("Listen(http) twice error = %v, want ErrServiceExists", err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		ln   *Listener
+	}{
+		{"http", httpLn},
+		{"grpc", grpcLn},
+	} {
+		conn, err := OpenServiceStream(client, tt.name, map[string]string{"k": tt.name})
+		if err != nil {
+			t.Fatalf("OpenServiceStream(%s) error = %v", tt.name, err)
+		}
+		conn.Write([]byte("hello " + tt.name))
+
+		accepted, err := tt.ln.Accept()
+		if err != nil {
+			t.Fatalf("Accept(%s) error = %v", tt.name, err)
+		}
+		if md := accepted.(*Conn).Header().Metadata["k"]; md != tt.name {
+			t.Errorf("metadata = %q, want %q", md, tt.name)
+		}
+
+		buf := make([]byte, len("hello "+tt.name))
+		if _, err := io.ReadFull(accepted, buf); err != nil || string(buf) != "hello "+tt.name {
+			t.Errorf("read %q, %v; want %q", buf, err, "hello "+tt.name)
+		}
+		conn.Close()
+		accepted.Close()
+	}
+}
+
+func TestMuxUnknownService(t *testing.T) {
+	client, server := newSessionPair(t)
+	NewMux(server)
+
+	conn, err := OpenServiceStream(client, "missing", nil)
+	if err != nil {
+		t.Fatalf("OpenServiceStream() error = %v", err)
+	}
+	defer conn.Close()
+
+	// 未注册的服务会被直接关闭
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Read() on stream to unknown service should fail")
+	}
+}
+
+func TestListenerCloseRefusesQueued(t *testing.T) {
+	client, server := newSessionPair(t)
+
+	mux := NewMux(server)
+	ln, _ := mux.Listen("grpc")
+
+	// 已分发但未被 Accept 的流在监听器关闭时被重置，对端不必等到会话结束
+	var conns []transport.Stream
+	for i := 0; i < 3; i++ {
+		conn, err := OpenServiceStream(client, "grpc", nil)
+		if err != nil {
+			t.Fatalf("OpenServiceStream() error = %v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(ln.conns) < len(conns) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ln.Close()
+
+	for i, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var streamErr *transport.StreamError
+		if _, err := conn.Read(make([]byte, 1)); !errors.As(err, &streamErr) || streamErr.Code != transport.CodeRefused {
+			t.Errorf("stream %d Read() error = %v, want reset with CodeRefused", i, err)
+		}
+	}
+}
+
+func TestMuxSessionClosed(t *testing.T) {
+	client, server := newSessionPair(t)
+
+	mux := NewMux(server)
+	ln, _ := mux.Listen("grpc")
+
+	client.Close()
+	if _, err := ln.Accept(); err == nil {
+		t.Error("Accept() after session closed should fail")
+	}
+	if _, err := mux.Listen("http"); !errors.Is(err, ErrMuxClosed) {
+		t.Errorf("Listen() after session closed error = %v, want ErrMuxClosed", err)
+	}
+}
+
+// Listener 可以直接交给基于 MuxSession 的适配器使用
+var _ transport.MuxSession = (*Listener)(nil)
@@ -0,0 +1,99 @@
+package shaper
+
+import (
+	"net"
+	"sync"
+
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// Session 对 MuxSession 上的所有流限速
+// 会话级限速由所有流共享，流级限速对每个流单独生效
+type Session struct {
+	transport.MuxSession
+	bucket *Bucket
+
+	mu      sync.Mutex
+	limits  transport.BandwidthLimits
+	streams map[*Conn]struct{}
+}
+
+// NewSession 创建限速会话
+func NewSession(sess transport.MuxSession, limits transport.BandwidthLimits) *Session {
+	return &Session{
+		MuxSession: sess,
+		bucket:     NewBucket(limits.SessionUp, limits.SessionDown),
+		limits:     limits,
+		streams:    make(map[*Conn]struct{}),
+	}
+}
+
+// Accept 接受来自对端的虚拟流并限速
+func (s *Session) Accept() (net.Conn, error) {
+	return s.AcceptStream()
+}
+
+// AcceptStream 接受来自对端的虚拟流并限速
+func (s *Session) AcceptStream() (transport.Stream, error) {
+	conn, err := s.MuxSession.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	return s.wrap(conn), nil
+}
+
+// OpenStream 发起一个新的虚拟流并限速
+func (s *Session) OpenStream() (transport.Stream, error) {
+	conn, err := s.MuxSession.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	return s.wrap(conn), nil
+}
+
+// SetLimits 在运行时调整限速，流级限速同时作用于已打开的流
+func (s *Session) SetLimits(limits transport.BandwidthLimits) {
+	s.bucket.SetLimits(limits.SessionUp, limits.SessionDown)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits = limits
+	for c := range s.streams {
+		c.SetLimits(limits.StreamUp, limits.StreamDown)
+	}
+}
+
+// Limits 返回当前的限速配置
+func (s *Session) Limits() transport.BandwidthLimits {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limits
+}
+
+// Close 关闭会话并停止跟踪所有流
+func (s *Session) Close() error {
+	s.mu.Lock()
+	s.streams = make(map[*Conn]struct{})
+	s.mu.Unlock()
+	return s.MuxSession.Close()
+}
+
+// Unwrap 返回被限速的底层会话
+func (s *Session) Unwrap() transport.MuxSession {
+	return s.MuxSession
+}
+
+// wrap 为新流创建限速连接，并在流关闭时移除跟踪
+func (s *Session) wrap(conn net.Conn) *Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := NewConn(conn, s.limits.StreamUp, s.limits.StreamDown, s.bucket)
+	c.onClose = func() {
+		s.mu.Lock()
+		delete(s.streams, c)
+		s.mu.Unlock()
+	}
+	s.streams[c] = struct{}{}
+	return c
+}
@@ -0,0 +1,250 @@
+// Package shaper 提供基于令牌桶的带宽整形
+// 可以对单个流或整个会话分别限制上行（写入）与下行（读取）速率，并在运行时调整
+package shaper
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/ratelimit"
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// Bucket 是一对上下行限速器，可在多个流之间共享
+type Bucket struct {
+	up   *ratelimit.Limiter
+	down *ratelimit.Limiter
+}
+
+// NewBucket 创建上下行限速器，速率单位为字节/秒，0 表示不限速
+func NewBucket(up, down float64) *Bucket {
+	return &Bucket{
+		up:   ratelimit.NewLimiter(up, burstFor(up)),
+		down: ratelimit.NewLimiter(down, burstFor(down)),
+	}
+}
+
+// SetLimits 在运行时调整上下行速率
+func (b *Bucket) SetLimits(up, down float64) {
+	b.up.SetLimit(up, burstFor(up))
+	b.down.SetLimit(down, burstFor(down))
+}
+
+// Limits 返回当前的上下行速率
+func (b *Bucket) Limits() (up, down float64) {
+	return b.up.Limit(), b.down.Limit()
+}
+
+// burstFor 返回速率对应的桶容量：一秒的流量，至少 1 字节
+// 容量不超过速率，低速率下也不会有超出限制的突发；读写按容量分块，见 chunkSize
+func burstFor(rate float64) int {
+	return max(int(rate), 1)
+}
+
+// Conn 对 net.Conn 的读写进行限速
+// 每个 Conn 有自己的 Bucket，并可叠加多个共享的 Bucket（如会话级限速）
+type Conn struct {
+	net.Conn
+	own    *Bucket
+	shared []*Bucket
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	onClose func()
+	once    sync.Once
+}
+
+// NewConn 创建限速连接，up/down 为该连接自身的速率限制
+func NewConn(conn net.Conn, up, down float64, shared ...*Bucket) *Conn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Conn{
+		Conn:   conn,
+		own:    NewBucket(up, down),
+		shared: shared,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// SetLimits 在运行时调整该连接自身的速率限制
+func (c *Conn) SetLimits(up, down float64) {
+	c.own.SetLimits(up, down)
+}
+
+// Limits 返回该连接自身的速率限制
+func (c *Conn) Limits() (up, down float64) {
+	return c.own.Limits()
+}
+
+// Unwrap 返回被限速的底层连接
+func (c *Conn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// Read 读取数据，读取后按下行速率等待
+func (c *Conn) Read(b []byte) (int, error) {
+	if size := c.chunkSize(downLimiter); len(b) > size {
+		b = b[:size]
+	}
+
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		// 数据已经读出，等待被取消时仍然返回数据，错误留给下一次读取
+		c.wait(downLimiter, n, c.deadline(true))
+	}
+	return n, err
+}
+
+// Write 按上行速率分块写入数据
+func (c *Conn) Write(b []byte) (int, error) {
+	size := c.chunkSize(upLimiter)
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > size {
+			chunk = chunk[:size]
+		}
+
+		if err := c.wait(upLimiter, len(chunk), c.deadline(false)); err != nil {
+			return written, err
+		}
+
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// Close 关闭连接，并中断正在进行的限速等待
+func (c *Conn) Close() error {
+	c.cancel()
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return err
+}
+
+// CloseWrite 关闭底层流的写方向
+func (c *Conn) CloseWrite() error {
+	return transport.CloseWrite(c.Conn)
+}
+
+// CloseRead 关闭底层流的读方向
+func (c *Conn) CloseRead() error {
+	return transport.CloseRead(c.Conn)
+}
+
+// ID 返回底层流的 ID，底层连接不是 transport.Stream 时返回 0
+func (c *Conn) ID() uint64 {
+	if s, ok := c.Conn.(transport.Stream); ok {
+		return s.ID()
+	}
+	return 0
+}
+
+// Reset 中断限速等待并重置底层流
+func (c *Conn) Reset(code transport.ErrorCode) error {
+	c.cancel()
+	return transport.ResetStream(c.Conn, code)
+}
+
+// Stats 返回底层流的统计信息
+func (c *Conn) Stats() transport.StreamStats {
+	if s, ok := c.Conn.(transport.Stream); ok {
+		return s.Stats()
+	}
+	return transport.StreamStats{}
+}
+
+// SetDeadline 设置读写截止时间，限速等待同样受其约束
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline, c.writeDeadline = t, t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline 设置读截止时间
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline 设置写截止时间
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// deadline 返回读或写截止时间
+func (c *Conn) deadline(read bool) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if read {
+		return c.readDeadline
+	}
+	return c.writeDeadline
+}
+
+// limiterFunc 从 Bucket 中选择一个方向的限速器
+type limiterFunc func(*Bucket) *ratelimit.Limiter
+
+func upLimiter(b *Bucket) *ratelimit.Limiter   { return b.up }
+func downLimiter(b *Bucket) *ratelimit.Limiter { return b.down }
+
+// buckets 返回自身和共享的所有 Bucket
+func (c *Conn) buckets() []*Bucket {
+	return append([]*Bucket{c.own}, c.shared...)
+}
+
+// chunkSize 返回单次读写的最大字节数：所有已限速的桶中最小的容量
+func (c *Conn) chunkSize(dir limiterFunc) int {
+	size := 32 * 1024
+	for _, b := range c.buckets() {
+		l := dir(b)
+		if l.Limit() > 0 && l.Burst() < size {
+			size = l.Burst()
+		}
+	}
+	return size
+}
+
+// wait 在所有桶中等待 n 个令牌
+func (c *Conn) wait(dir limiterFunc, n int, deadline time.Time) error {
+	ctx := c.ctx
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	for _, b := range c.buckets() {
+		if err := dir(b).WaitN(ctx, n); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return os.ErrDeadlineExceeded
+			}
+			return net.ErrClosed
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package shaper
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+func TestConnWriteRate(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	c := NewConn(client, 64*1024, 0)
+	defer c.Close()
+	go io.Copy(io.Discard, server)
+
+	// 初始桶容量为 64KB，再写 32KB 需要约 0.5 秒
+	start := time.Now()
+	if _, err := c.Write(make([]byte, 96*1024)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Write() took %v, want about 500ms", elapsed)
+	}
+}
+
+func TestConnLowRate(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	c := NewConn(client, 4*1024, 0)
+	defer c.Close()
+	go io.Copy(io.Discard, server)
+
+	// 低速率下的突发不超过一秒的流量：初始 4KB，再写 4KB 需要约 1 秒
+	start := time.Now()
+	if _, err := c.Write(make([]byte, 8*1024)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Errorf("Write() of 8KB at 4KB/s took %v, want about 1s", elapsed)
+	}
+}
+
+func TestConnSharedBucket(t *testing.T) {
+	shared := NewBucket(0, 32*1024)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	c := NewConn(server, 0, 0, shared)
+	defer c.Close()
+
+	go client.Write(make([]byte, 64*1024))
+
+	start := time.Now()
+	if _, err := io.ReadFull(c, make([]byte, 64*1024)); err != nil {
+		t.Fatalf("ReadFull() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Errorf("ReadFull() took %v, want about 1s with shared limit", elapsed)
+	}
+}
+
+func TestConnWriteDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(io.Discard, server)
+
+	c := NewConn(client, 1024, 0)
+	defer c.Close()
+	c.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+
+	_, err := c.Write(make([]byte, 64*1024))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Write() error = %v, want os.ErrDeadlineExceeded", err)
+	}
+}
+
+func TestConnSetLimits(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(io.Discard, server)
+
+	c := NewConn(client, 1024, 0)
+	defer c.Close()
+
+	c.SetLimits(0, 0)
+	if up, down := c.Limits(); up != 0 || down != 0 {
+		t.Errorf("Limits() = %v/%v, want 0/0", up, down)
+	}
+
+	// 取消限速后写入不应等待
+	done := make(chan struct{})
+	go func() {
+		c.Write(make([]byte, 256*1024))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Write() should not be throttled after limits removed")
+	}
+}
+
+func TestSessionSetLimits(t *testing.T) {
+	s := NewSession(nil, transport.BandwidthLimits{StreamUp: 1024})
+
+	client, server := net.Pipe()
+	defer server.Close()
+	c := s.wrap(client)
+
+	s.SetLimits(transport.BandwidthLimits{StreamUp: 2048, SessionDown: 4096})
+	if up, _ := c.Limits(); up != 2048 {
+		t.Errorf("stream up limit = %v, want 2048", up)
+	}
+	if got := s.Limits().SessionDown; got != 4096 {
+		t.Errorf("Limits().SessionDown = %v, want 4096", got)
+	}
+
+	c.Close()
+	if len(s.streams) != 0 {
+		t.Errorf("closed stream should no longer be tracked, got %d", len(s.streams))
+	}
+}
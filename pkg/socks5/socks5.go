@@ -0,0 +1,348 @@
+// Package socks5 实现经由会话拨号的 SOCKS5 代理服务端 (RFC 1928)
+//
+// 代理运行在 Server 端，客户端请求的目标由 Agent 拨号：CONNECT 通过 forward.Dial 打开转发流，
+// UDP ASSOCIATE 通过 forward.DialUDP 为每个目标建立 UDP 隧道。Agent 端需要运行 forward.Server，
+// 目标地址受其 ServerOptions.Targets 策略的限制。支持无认证和用户名/密码认证 (RFC 1929)，不支持 BIND
+package socks5
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/forward"
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+const (
+	socksVersion = 0x05
+
+	// 认证方法
+	methodNoAuth       = 0x00
+	methodUserPass     = 0x02
+	methodNoAcceptable = 0xff
+
+	// 用户名/密码认证的子协议版本和结果
+	userPassVersion = 0x01
+	authSucceeded   = 0x00
+	authFailed      = 0x01
+
+	// 命令
+	cmdConnect      = 0x01
+	cmdUDPAssociate = 0x03
+
+	// 地址类型
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+
+	// handshakeTimeout 协商和读取请求的超时时间
+	handshakeTimeout = 10 * time.Second
+)
+
+// 响应码
+const (
+	replySucceeded           = 0x00
+	replyGeneralFailure      = 0x01
+	replyNotAllowed          = 0x02
+	replyHostUnreachable     = 0x04
+	replyConnectionRefused   = 0x05
+	replyCommandNotSupported = 0x07
+	replyAddressNotSupported = 0x08
+)
+
+var (
+	// ErrAuthFailed 用户名或密码错误
+	ErrAuthFailed = errors.New("socks5: authentication failed")
+
+	// errUnsupportedAddr 不支持的地址类型
+	errUnsupportedAddr = errors.New("socks5: unsupported address type")
+)
+
+// Options 定义 SOCKS5 代理的可选配置
+type Options struct {
+	// Authenticate 校验用户名和密码，设置后客户端必须使用用户名/密码认证；为 nil 时不需要认证
+	Authenticate func(username, password string) bool
+
+	// Session 按认证的用户名选择承载出站连接的会话，例如以用户名作为 Agent 名称查找注册表；
+	// 为 nil 时使用 NewServer 的 sess。未认证时 username 为空
+	Session func(username string) (transport.MuxSession, error)
+
+	// UDP 允许 UDP ASSOCIATE，默认只支持 CONNECT
+	UDP bool
+}
+
+// Server 是 SOCKS5 代理服务端
+type Server struct {
+	sess transport.MuxSession
+	opts Options
+}
+
+// NewServer 创建 SOCKS5 代理，出站连接由 sess 的对端拨号
+// opts.Session 不为 nil 时 sess 可以为 nil
+func NewServer(sess transport.MuxSession, opts *Options) (*Server, error) {
+	s := &Server{sess: sess}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.sess == nil && s.opts.Session == nil {
+		return nil, errors.New("socks5: session required")
+	}
+	return s, nil
+}
+
+// Serve 接受 ln 上的连接并处理，ln 关闭时返回
+func (s *Server) Serve(ln net.Listener) error {
+	log.Printf("[Qymux-SOCKS5] SOCKS5 代理监听 %s", ln.Addr())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn 处理一个客户端连接，返回时连接已关闭
+func (s *Server) ServeConn(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	username, err := s.negotiate(conn)
+	if err != nil {
+		log.Printf("[Qymux-SOCKS5] %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	cmd, addr, err := readRequest(conn)
+	if err != nil {
+		if errors.Is(err, errUnsupportedAddr) {
+			writeReply(conn, replyAddressNotSupported, "")
+		}
+		log.Printf("[Qymux-SOCKS5] %s: read request failed: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	if cmd != cmdConnect && !(cmd == cmdUDPAssociate && s.opts.UDP) {
+		writeReply(conn, replyCommandNotSupported, "")
+		conn.Close()
+		return
+	}
+
+	sess := s.sess
+	if s.opts.Session != nil {
+		sess, err = s.opts.Session(username)
+		if err != nil {
+			log.Printf("[Qymux-SOCKS5] %s: select session for %q failed: %v", conn.RemoteAddr(), username, err)
+			writeReply(conn, replyGeneralFailure, "")
+			conn.Close()
+			return
+		}
+	}
+
+	if cmd == cmdUDPAssociate {
+		s.handleUDPAssociate(sess, conn, addr)
+		return
+	}
+	s.handleConnect(sess, conn, addr)
+}
+
+// negotiate 协商认证方法并认证，返回用户名
+func (s *Server) negotiate(conn net.Conn) (string, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("socks5: unsupported version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	want := byte(methodNoAuth)
+	if s.opts.Authenticate != nil {
+		want = methodUserPass
+	}
+	if !hasMethod(methods, want) {
+		conn.Write([]byte{socksVersion, methodNoAcceptable})
+		return "", errors.New("socks5: no acceptable authentication method")
+	}
+	if _, err := conn.Write([]byte{socksVersion, want}); err != nil {
+		return "", err
+	}
+	if want == methodNoAuth {
+		return "", nil
+	}
+
+	// 用户名/密码认证 (RFC 1929)：VER | ULEN | UNAME | PLEN | PASSWD
+	var ver [1]byte
+	if _, err := io.ReadFull(conn, ver[:]); err != nil {
+		return "", err
+	}
+	if ver[0] != userPassVersion {
+		return "", fmt.Errorf("socks5: unsupported auth version %d", ver[0])
+	}
+	username, err := readString(conn)
+	if err != nil {
+		return "", err
+	}
+	password, err := readString(conn)
+	if err != nil {
+		return "", err
+	}
+	if !s.opts.Authenticate(username, password) {
+		conn.Write([]byte{userPassVersion, authFailed})
+		return "", fmt.Errorf("%w for %q", ErrAuthFailed, username)
+	}
+	if _, err := conn.Write([]byte{userPassVersion, authSucceeded}); err != nil {
+		return "", err
+	}
+	return username, nil
+}
+
+// handleConnect 请求对端拨号目标并转发
+func (s *Server) handleConnect(sess transport.MuxSession, conn net.Conn, addr string) {
+	stream, err := forward.Dial(sess, "tcp", addr)
+	if err != nil {
+		log.Printf("[Qymux-SOCKS5] %s -> %s: %v", conn.RemoteAddr(), addr, err)
+		writeReply(conn, replyCode(err), "")
+		conn.Close()
+		return
+	}
+	// 目标连接在对端建立，本端无法得知其地址，BND.ADDR 返回全零地址
+	if err := writeReply(conn, replySucceeded, ""); err != nil {
+		stream.Close()
+		conn.Close()
+		return
+	}
+	forward.Pipe(conn, stream)
+}
+
+// hasMethod 判断客户端是否支持 method
+func hasMethod(methods []byte, method byte) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// readString 读取一个字节长度前缀的字符串
+func readString(r io.Reader) (string, error) {
+	var n [1]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return "", err
+	}
+	b := make([]byte, n[0])
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// readRequest 读取请求：VER | CMD | RSV | ATYP | DST.ADDR | DST.PORT
+func readRequest(r io.Reader) (cmd byte, addr string, err error) {
+	var header [3]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, "", err
+	}
+	if header[0] != socksVersion {
+		return 0, "", fmt.Errorf("socks5: unsupported version %d", header[0])
+	}
+	addr, err = readAddr(r)
+	return header[1], addr, err
+}
+
+// readAddr 读取 ATYP | ADDR | PORT 格式的地址
+func readAddr(r io.Reader) (string, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return "", err
+	}
+
+	var host string
+	switch atyp[0] {
+	case atypIPv4, atypIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if atyp[0] == atypIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case atypDomain:
+		domain, err := readString(r)
+		if err != nil {
+			return "", err
+		}
+		host = domain
+	default:
+		return "", fmt.Errorf("%w %#x", errUnsupportedAddr, atyp[0])
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// appendAddr 以 ATYP | ADDR | PORT 格式追加地址，addr 为空时追加 IPv4 全零地址
+func appendAddr(b []byte, addr string) []byte {
+	host, port := "0.0.0.0", 0
+	if h, p, err := net.SplitHostPort(addr); err == nil {
+		host = h
+		port, _ = strconv.Atoi(p)
+	}
+
+	if ip := net.ParseIP(host); ip == nil {
+		b = append(b, atypDomain, byte(len(host)))
+		b = append(b, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		b = append(b, atypIPv4)
+		b = append(b, ip4...)
+	} else {
+		b = append(b, atypIPv6)
+		b = append(b, ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port))
+}
+
+// writeReply 写入响应：VER | REP | RSV | ATYP | BND.ADDR | BND.PORT
+func writeReply(w io.Writer, code byte, bind string) error {
+	_, err := w.Write(appendAddr([]byte{socksVersion, code, 0}, bind))
+	return err
+}
+
+// replyCode 将对端的拨号错误映射为响应码
+func replyCode(err error) byte {
+	var dialErr *forward.DialError
+	if !errors.As(err, &dialErr) {
+		return replyGeneralFailure
+	}
+	switch dialErr.Code {
+	case forward.ReplyDenied:
+		return replyNotAllowed
+	case forward.ReplyRefused:
+		return replyConnectionRefused
+	case forward.ReplyUnreachable, forward.ReplyTimeout:
+		return replyHostUnreachable
+	case forward.ReplyUnsupported:
+		return replyCommandNotSupported
+	default:
+		return replyGeneralFailure
+	}
+}
@@ -0,0 +1,260 @@
+package socks5
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/forward"
+	"github.com/funcx27/qymux/pkg/tcp"
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// newAgent 建立一对 TCP 会话，Agent 端按 targets 策略处理转发请求，返回 Server 端的会话
+// targets 为 nil 时允许所有目标
+func newAgent(t *testing.T, targets *forward.Policy) transport.MuxSession {
+	t.Helper()
+
+	if targets == nil {
+		targets, _ = forward.NewPolicy(nil, nil)
+	}
+
+	ln, err := tcp.Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	agent, err := tcp.NewDialer(nil).Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { agent.Close() })
+	go forward.NewServer(&forward.ServerOptions{Targets: targets}).Serve(agent)
+
+	sess, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	t.Cleanup(func() { sess.Close() })
+	return sess
+}
+
+// startProxy 启动 SOCKS5 代理，返回监听地址
+func startProxy(t *testing.T, sess transport.MuxSession, opts *Options) string {
+	t.Helper()
+
+	s, err := NewServer(sess, opts)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go s.Serve(ln)
+	return ln.Addr().String()
+}
+
+// dialProxy 连接代理并发送请求，username 不为空时使用用户名/密码认证
+// 返回连接、响应码和 BND 地址；认证失败时响应码为 0xff
+func dialProxy(t *testing.T, proxy, username, password string, cmd byte, addr string) (net.Conn, byte, string) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", proxy)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	method := byte(methodNoAuth)
+	if username != "" {
+		method = methodUserPass
+	}
+	conn.Write([]byte{socksVersion, 1, method})
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		t.Fatalf("read method error = %v", err)
+	}
+	if resp[1] != method {
+		return conn, methodNoAcceptable, ""
+	}
+	if username != "" {
+		auth := []byte{userPassVersion, byte(len(username))}
+		auth = append(auth, username...)
+		auth = append(auth, byte(len(password)))
+		conn.Write(append(auth, password...))
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			t.Fatalf("read auth reply error = %v", err)
+		}
+		if resp[1] != authSucceeded {
+			return conn, methodNoAcceptable, ""
+		}
+	}
+
+	conn.Write(appendAddr([]byte{socksVersion, cmd, 0}, addr))
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatalf("read reply error = %v", err)
+	}
+	bind, err := readAddr(conn)
+	if err != nil {
+		t.Fatalf("read bind address error = %v", err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, header[1], bind
+}
+
+// startEcho 启动 TCP 和 UDP 回显服务，返回相同端口的地址
+func startEcho(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	pc, err := net.ListenPacket("udp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], addr)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestConnect(t *testing.T) {
+	echo := startEcho(t)
+	_, port, _ := net.SplitHostPort(echo)
+	targets, err := forward.NewPolicy([]string{"127.0.0.1:" + port, "localhost:" + port}, nil)
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+	proxy := startProxy(t, newAgent(t, targets), &Options{
+		Authenticate: func(username, password string) bool {
+			return username == "ops" && password == "secret"
+		},
+	})
+
+	for _, addr := range []string{echo, net.JoinHostPort("localhost", port)} {
+		conn, code, _ := dialProxy(t, proxy, "ops", "secret", cmdConnect, addr)
+		if code != replySucceeded {
+			t.Fatalf("CONNECT %s reply = %#x, want success", addr, code)
+		}
+		conn.Write([]byte("hello"))
+		conn.(*net.TCPConn).CloseWrite()
+		got, err := io.ReadAll(conn)
+		if err != nil || string(got) != "hello" {
+			t.Errorf("echo via %s = %q, %v", addr, got, err)
+		}
+	}
+
+	if _, code, _ := dialProxy(t, proxy, "ops", "wrong", cmdConnect, echo); code != methodNoAcceptable {
+		t.Errorf("wrong password reply = %#x, want auth failure", code)
+	}
+	if _, code, _ := dialProxy(t, proxy, "", "", cmdConnect, echo); code != methodNoAcceptable {
+		t.Errorf("no auth reply = %#x, want no acceptable method", code)
+	}
+
+	// Agent 端策略拒绝的目标
+	if _, code, _ := dialProxy(t, proxy, "ops", "secret", cmdConnect, "127.0.0.1:1"); code != replyNotAllowed {
+		t.Errorf("denied target reply = %#x, want %#x", code, replyNotAllowed)
+	}
+	// 未启用 UDP
+	if _, code, _ := dialProxy(t, proxy, "ops", "secret", cmdUDPAssociate, "0.0.0.0:0"); code != replyCommandNotSupported {
+		t.Errorf("UDP ASSOCIATE reply = %#x, want %#x", code, replyCommandNotSupported)
+	}
+}
+
+func TestSessionSelect(t *testing.T) {
+	echo := startEcho(t)
+	sessions := map[string]transport.MuxSession{"edge1": newAgent(t, nil)}
+	proxy := startProxy(t, nil, &Options{
+		Authenticate: func(username, password string) bool { return true },
+		Session: func(username string) (transport.MuxSession, error) {
+			if sess, ok := sessions[username]; ok {
+				return sess, nil
+			}
+			return nil, io.ErrUnexpectedEOF
+		},
+	})
+
+	if _, code, _ := dialProxy(t, proxy, "edge1", "x", cmdConnect, echo); code != replySucceeded {
+		t.Errorf("edge1 reply = %#x, want success", code)
+	}
+	if _, code, _ := dialProxy(t, proxy, "edge2", "x", cmdConnect, echo); code != replyGeneralFailure {
+		t.Errorf("unknown agent reply = %#x, want %#x", code, replyGeneralFailure)
+	}
+}
+
+func TestUDPAssociate(t *testing.T) {
+	echo := startEcho(t)
+	proxy := startProxy(t, newAgent(t, nil), &Options{UDP: true})
+
+	ctrl, code, relay := dialProxy(t, proxy, "", "", cmdUDPAssociate, "0.0.0.0:0")
+	if code != replySucceeded {
+		t.Fatalf("UDP ASSOCIATE reply = %#x, want success", code)
+	}
+
+	conn, err := net.Dial("udp", relay)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	for _, msg := range []string{"ping", "pong"} {
+		packet := append(appendAddr([]byte{0, 0, 0}, echo), msg...)
+
+		buf := make([]byte, 1024)
+		var n int
+		for i := 0; i < 20; i++ {
+			conn.Write(packet)
+			conn.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+			if n, err = conn.Read(buf); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			t.Fatalf("no UDP echo for %q: %v", msg, err)
+		}
+		// 返回的包头中 DST.ADDR 为目标地址
+		if !bytes.Equal(buf[:n], packet) {
+			t.Errorf("UDP echo = %q, want %q", buf[:n], packet)
+		}
+	}
+
+	// 关闭控制连接后中继停止
+	ctrl.Close()
+	time.Sleep(100 * time.Millisecond)
+	conn.Write(append(appendAddr([]byte{0, 0, 0}, echo), "late"...))
+	conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 64)); err == nil {
+		t.Error("UDP relay still forwarding after control connection closed")
+	}
+}
@@ -0,0 +1,221 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/forward"
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+const (
+	// maxUDPTargets 单个 UDP 关联最多同时转发的目标数
+	maxUDPTargets = 256
+
+	// udpTargetBacklog 每个目标在隧道建立期间最多缓存的包数，超出时丢弃
+	udpTargetBacklog = 64
+)
+
+// handleUDPAssociate 在与控制连接相同的本地 IP 上打开 UDP 中继，控制连接关闭时结束
+// 中继只接受来自控制连接客户端 IP 的包，第一个包的来源地址之后固定为该关联的客户端地址
+func (s *Server) handleUDPAssociate(sess transport.MuxSession, conn net.Conn, addr string) {
+	defer conn.Close()
+
+	host, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+	pc, err := net.ListenPacket("udp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		log.Printf("[Qymux-SOCKS5] %s: listen UDP failed: %v", conn.RemoteAddr(), err)
+		writeReply(conn, replyGeneralFailure, "")
+		return
+	}
+
+	a := &udpAssociation{
+		sess:    sess,
+		pc:      pc,
+		targets: make(map[string]*udpTarget),
+		done:    make(chan struct{}),
+	}
+	a.clientIP, _, _ = net.SplitHostPort(conn.RemoteAddr().String())
+	if _, port, err := net.SplitHostPort(addr); err == nil && port != "0" {
+		// 客户端声明了发送包的端口
+		a.clientPort = port
+	}
+	defer a.close()
+
+	if err := writeReply(conn, replySucceeded, pc.LocalAddr().String()); err != nil {
+		return
+	}
+	log.Printf("[Qymux-SOCKS5] %s: UDP 关联 %s (%s)", conn.RemoteAddr(), pc.LocalAddr(), sess.Protocol())
+
+	go a.readLoop()
+	// 控制连接上没有后续数据，读取返回即表示客户端结束关联
+	io.Copy(io.Discard, conn)
+}
+
+// udpAssociation 是一个 UDP ASSOCIATE 关联
+type udpAssociation struct {
+	sess       transport.MuxSession
+	pc         net.PacketConn
+	clientIP   string
+	clientPort string
+
+	mu        sync.Mutex
+	client    net.Addr
+	targets   map[string]*udpTarget
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// readLoop 读取客户端的包并分发到目标
+func (a *udpAssociation) readLoop() {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := a.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if !a.accept(from) {
+			continue
+		}
+
+		// RSV (2) | FRAG (1) | ATYP | DST.ADDR | DST.PORT | DATA，不支持分片
+		if n < 4 || buf[2] != 0 {
+			continue
+		}
+		r := bytes.NewReader(buf[3:n])
+		target, err := readAddr(r)
+		if err != nil {
+			continue
+		}
+		packet := make([]byte, r.Len())
+		r.Read(packet)
+
+		if t := a.target(target); t != nil {
+			select {
+			case t.out <- packet:
+			default:
+				// 隧道尚未建立或发送过慢，按 UDP 语义丢弃
+			}
+		}
+	}
+}
+
+// accept 检查包的来源是否为关联的客户端
+func (a *udpAssociation) accept(from net.Addr) bool {
+	host, port, err := net.SplitHostPort(from.String())
+	if err != nil || host != a.clientIP || (a.clientPort != "" && port != a.clientPort) {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.client == nil {
+		a.client = from
+	}
+	return a.client.String() == from.String()
+}
+
+// target 返回目标对应的隧道，不存在时创建，超过数量上限时返回 nil
+func (a *udpAssociation) target(addr string) *udpTarget {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if t, ok := a.targets[addr]; ok {
+		return t
+	}
+	select {
+	case <-a.done:
+		return nil
+	default:
+	}
+	if len(a.targets) >= maxUDPTargets {
+		return nil
+	}
+
+	t := &udpTarget{
+		a:      a,
+		addr:   addr,
+		header: appendAddr([]byte{0, 0, 0}, addr),
+		out:    make(chan []byte, udpTargetBacklog),
+	}
+	a.targets[addr] = t
+	go t.run()
+	return t
+}
+
+// close 关闭中继和所有隧道，可重复调用
+func (a *udpAssociation) close() {
+	a.closeOnce.Do(func() {
+		a.mu.Lock()
+		close(a.done)
+		a.mu.Unlock()
+		a.pc.Close()
+	})
+}
+
+// remove 注销目标
+func (a *udpAssociation) remove(t *udpTarget) {
+	a.mu.Lock()
+	if a.targets[t.addr] == t {
+		delete(a.targets, t.addr)
+	}
+	a.mu.Unlock()
+}
+
+// udpTarget 是关联中的一个目标
+type udpTarget struct {
+	a      *udpAssociation
+	addr   string
+	header []byte // 返回给客户端的包头，DST.ADDR 为请求的目标地址
+	out    chan []byte
+}
+
+// run 请求对端拨号目标，然后发送缓存的包，关联结束或隧道关闭时返回
+func (t *udpTarget) run() {
+	defer t.a.remove(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), forward.DefaultDialTimeout+time.Second)
+	tunnel, err := forward.DialUDP(ctx, t.a.sess, t.addr, t.deliver)
+	cancel()
+	if err != nil {
+		log.Printf("[Qymux-SOCKS5] UDP %s: %v", t.addr, err)
+		return
+	}
+	defer tunnel.Close()
+
+	for {
+		select {
+		case packet := <-t.out:
+			if err := tunnel.Send(packet); err != nil {
+				return
+			}
+		case <-tunnel.Done():
+			return
+		case <-t.a.done:
+			return
+		}
+	}
+}
+
+// deliver 将目标返回的包加上包头发送给客户端
+func (t *udpTarget) deliver(packet []byte) {
+	t.a.mu.Lock()
+	client := t.a.client
+	t.a.mu.Unlock()
+	if client == nil {
+		return
+	}
+
+	b := make([]byte, 0, len(t.header)+len(packet))
+	b = append(b, t.header...)
+	b = append(b, packet...)
+	if _, err := t.a.pc.WriteTo(b, client); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("[Qymux-SOCKS5] UDP %s: write to client failed: %v", t.addr, err)
+	}
+}
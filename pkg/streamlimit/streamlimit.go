@@ -0,0 +1,169 @@
+// Package streamlimit 为多路复用会话提供入站流的并发限制与接收积压控制
+// QUIC 与 TCP+Yamux 会话共用同一套实现，保证两种传输的行为一致
+package streamlimit
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// DefaultAcceptBacklog 默认的接收积压上限，与 yamux 的默认值一致
+const DefaultAcceptBacklog = 256
+
+// Acceptor 在底层 Accept 之上执行 transport.SessionOptions 中的限制
+//
+// BacklogBlock 模式下，达到 MaxIncomingStreams 时 Accept 会等待已有的流关闭，
+// 未被接收的流留在底层传输的队列中，对端打开新流时因流控而阻塞。
+// BacklogReject 模式下，后台 goroutine 持续接收新流，超出并发上限或积压已满的流被立即拒绝。
+type Acceptor struct {
+	accept func() (net.Conn, error)
+	reject func(net.Conn)
+	closed <-chan struct{}
+	policy transport.BacklogPolicy
+
+	slots chan struct{} // 并发流名额，为 nil 时不限制
+	queue chan net.Conn // 仅 BacklogReject 模式使用
+	done  chan struct{}
+	err   error
+
+	rejected atomic.Uint64
+}
+
+// NewAcceptor 创建入站流接收器
+// accept 为底层接收函数，reject 用于拒绝超出限制的流，closed 在会话关闭时关闭
+func NewAcceptor(opts *transport.SessionOptions, accept func() (net.Conn, error), reject func(net.Conn), closed <-chan struct{}) *Acceptor {
+	a := &Acceptor{
+		accept: accept,
+		reject: reject,
+		closed: closed,
+		policy: Policy(opts),
+	}
+	if opts != nil && opts.MaxIncomingStreams > 0 {
+		a.slots = make(chan struct{}, opts.MaxIncomingStreams)
+	}
+
+	if a.policy == transport.BacklogReject {
+		a.queue = make(chan net.Conn, AcceptBacklog(opts))
+		a.done = make(chan struct{})
+		go a.acceptLoop()
+	}
+
+	return a
+}
+
+// Policy 返回配置的积压策略，未配置时为 BacklogBlock
+func Policy(opts *transport.SessionOptions) transport.BacklogPolicy {
+	if opts == nil || opts.BacklogPolicy == "" {
+		return transport.BacklogBlock
+	}
+	return opts.BacklogPolicy
+}
+
+// AcceptBacklog 返回配置的接收积压上限，未配置时为 DefaultAcceptBacklog
+func AcceptBacklog(opts *transport.SessionOptions) int {
+	if opts == nil || opts.AcceptBacklog <= 0 {
+		return DefaultAcceptBacklog
+	}
+	return opts.AcceptBacklog
+}
+
+// Accept 接收下一个入站流
+// 返回的 release 必须在流关闭时调用以归还并发名额，可重复调用
+func (a *Acceptor) Accept() (net.Conn, func(), error) {
+	if a.policy == transport.BacklogReject {
+		return a.acceptQueued()
+	}
+
+	if a.slots != nil {
+		select {
+		case a.slots <- struct{}{}:
+		case <-a.closed:
+			return nil, nil, net.ErrClosed
+		}
+	}
+
+	conn, err := a.accept()
+	if err != nil {
+		a.releaseSlot()
+		return nil, nil, err
+	}
+	return conn, a.releaseFunc(), nil
+}
+
+// Rejected 返回被拒绝的入站流总数
+func (a *Acceptor) Rejected() uint64 {
+	return a.rejected.Load()
+}
+
+// acceptQueued 从积压队列中取出已通过检查的流
+func (a *Acceptor) acceptQueued() (net.Conn, func(), error) {
+	select {
+	case conn := <-a.queue:
+		return conn, a.releaseFunc(), nil
+	case <-a.done:
+		// 优先返回已排队的流
+		select {
+		case conn := <-a.queue:
+			return conn, a.releaseFunc(), nil
+		default:
+			return nil, nil, a.err
+		}
+	}
+}
+
+// acceptLoop 持续接收入站流，拒绝超出限制的流
+func (a *Acceptor) acceptLoop() {
+	for {
+		conn, err := a.accept()
+		if err != nil {
+			a.err = err
+			close(a.done)
+			return
+		}
+
+		if a.slots != nil {
+			select {
+			case a.slots <- struct{}{}:
+			default:
+				a.rejectConn(conn)
+				continue
+			}
+		}
+
+		select {
+		case a.queue <- conn:
+		default:
+			a.releaseSlot()
+			a.rejectConn(conn)
+		}
+	}
+}
+
+// rejectConn 拒绝一个入站流并计数
+func (a *Acceptor) rejectConn(conn net.Conn) {
+	a.rejected.Add(1)
+	a.reject(conn)
+}
+
+// releaseFunc 返回只会归还一次名额的函数
+func (a *Acceptor) releaseFunc() func() {
+	if a.slots == nil {
+		return func() {}
+	}
+	var once sync.Once
+	return func() { once.Do(a.releaseSlot) }
+}
+
+// releaseSlot 归还一个并发名额
+func (a *Acceptor) releaseSlot() {
+	if a.slots == nil {
+		return
+	}
+	select {
+	case <-a.slots:
+	default:
+	}
+}
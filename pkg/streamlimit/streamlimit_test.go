@@ -0,0 +1,138 @@
+package streamlimit
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/transport"
+)
+
+// fakeSession 通过 channel 模拟底层会话的 Accept
+type fakeSession struct {
+	streams  chan net.Conn
+	closed   chan struct{}
+	rejected chan net.Conn
+}
+
+func newFakeSession() *fakeSession {
+	return &fakeSession{
+		streams:  make(chan net.Conn, 16),
+		closed:   make(chan struct{}),
+		rejected: make(chan net.Conn, 16),
+	}
+}
+
+func (f *fakeSession) accept() (net.Conn, error) {
+	select {
+	case c := <-f.streams:
+		return c, nil
+	case <-f.closed:
+		return nil, io.EOF
+	}
+}
+
+func (f *fakeSession) reject(c net.Conn) {
+	f.rejected <- c
+}
+
+func (f *fakeSession) push() {
+	c, _ := net.Pipe()
+	f.streams <- c
+}
+
+func TestAcceptorBlock(t *testing.T) {
+	f := newFakeSession()
+	a := NewAcceptor(&transport.SessionOptions{MaxIncomingStreams: 1}, f.accept, f.reject, f.closed)
+	f.push()
+	f.push()
+
+	_, release, err := a.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+
+	accepted := make(chan struct{})
+	go func() {
+		a.Accept()
+		close(accepted)
+	}()
+
+	select {
+	case <-accepted:
+		t.Fatal("Accept() should block while MaxIncomingStreams is reached")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	release() // 重复调用不应多次归还名额
+
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("Accept() should proceed after release")
+	}
+	if a.Rejected() != 0 {
+		t.Errorf("Rejected() = %d, want 0 in block mode", a.Rejected())
+	}
+}
+
+func TestAcceptorBlockSessionClosed(t *testing.T) {
+	f := newFakeSession()
+	a := NewAcceptor(&transport.SessionOptions{MaxIncomingStreams: 1}, f.accept, f.reject, f.closed)
+	f.push()
+	a.Accept()
+
+	close(f.closed)
+	if _, _, err := a.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept() error = %v, want net.ErrClosed", err)
+	}
+}
+
+func TestAcceptorReject(t *testing.T) {
+	f := newFakeSession()
+	a := NewAcceptor(&transport.SessionOptions{
+		MaxIncomingStreams: 2,
+		AcceptBacklog:      1,
+		BacklogPolicy:      transport.BacklogReject,
+	}, f.accept, f.reject, f.closed)
+
+	// 第一个进入积压队列，第二个因积压已满被拒绝
+	f.push()
+	f.push()
+	<-f.rejected
+
+	_, release, err := a.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer release()
+
+	// 第三个进入积压队列，第四个因并发上限被拒绝
+	f.push()
+	f.push()
+	<-f.rejected
+
+	if a.Rejected() != 2 {
+		t.Errorf("Rejected() = %d, want 2", a.Rejected())
+	}
+
+	close(f.closed)
+	if _, _, err := a.Accept(); err != nil {
+		t.Errorf("Accept() of queued stream error = %v", err)
+	}
+	if _, _, err := a.Accept(); !errors.Is(err, io.EOF) {
+		t.Errorf("Accept() after close error = %v, want io.EOF", err)
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	if Policy(nil) != transport.BacklogBlock {
+		t.Errorf("Policy(nil) = %v, want %v", Policy(nil), transport.BacklogBlock)
+	}
+	if AcceptBacklog(nil) != DefaultAcceptBacklog {
+		t.Errorf("AcceptBacklog(nil) = %d, want %d", AcceptBacklog(nil), DefaultAcceptBacklog)
+	}
+}
@@ -0,0 +1,174 @@
+package tcp
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/transport"
+	"github.com/funcx27/qymux/pkg/utils"
+	"github.com/hashicorp/yamux"
+)
+
+// Conn 将 yamux.Stream 封装为 transport.Stream
+type Conn struct {
+	*yamux.Stream
+	session   *Session // 所属会话，为 nil 时 Reset 只在本端生效
+	release   func()
+	once      sync.Once
+	readClose utils.ReadShutdown
+
+	reset        atomic.Pointer[transport.StreamError]
+	openedAt     time.Time
+	bytesRead    atomic.Uint64
+	bytesWritten atomic.Uint64
+}
+
+// NewConn 创建新的 Yamux 流封装
+// release 在流首次关闭时调用，用于归还会话的并发流名额，可为 nil
+func NewConn(stream *yamux.Stream, release func()) *Conn {
+	return &Conn{
+		Stream:   stream,
+		release:  release,
+		openedAt: time.Now(),
+	}
+}
+
+// newConn 创建属于 session 的流封装，并登记以便接收对端的重置
+func newConn(session *Session, stream *yamux.Stream) *Conn {
+	c := NewConn(stream, nil)
+	if session != nil && session.control != nil {
+		c.session = session
+		session.register(c)
+	}
+	return c
+}
+
+// ID 返回 Yamux 流 ID
+func (c *Conn) ID() uint64 {
+	return uint64(c.StreamID())
+}
+
+// Read 从流中读取数据，读方向关闭后返回 net.ErrClosed，流被重置后返回 *transport.StreamError
+func (c *Conn) Read(b []byte) (int, error) {
+	if err := c.reset.Load(); err != nil {
+		return 0, err
+	}
+
+	n, err := c.readClose.Read(c.Stream, b)
+	c.bytesRead.Add(uint64(n))
+	if err != nil {
+		if resetErr := c.reset.Load(); resetErr != nil {
+			return n, resetErr
+		}
+	}
+	return n, err
+}
+
+// Write 向流中写入数据，流被重置后返回 *transport.StreamError
+func (c *Conn) Write(b []byte) (int, error) {
+	if err := c.reset.Load(); err != nil {
+		return 0, err
+	}
+
+	n, err := c.Stream.Write(b)
+	c.bytesWritten.Add(uint64(n))
+	if err != nil {
+		if resetErr := c.reset.Load(); resetErr != nil {
+			return n, resetErr
+		}
+	}
+	return n, err
+}
+
+// Close 关闭流的读写两个方向，语义见 transport.Stream
+func (c *Conn) Close() error {
+	var err error
+	if c.reset.Load() == nil {
+		err = c.CloseWrite()
+		c.CloseRead()
+	}
+	c.once.Do(func() {
+		if c.session != nil {
+			c.session.unregister(c)
+		}
+		if c.release != nil {
+			c.release()
+		}
+	})
+	return err
+}
+
+// CloseWrite 关闭写方向（发送 FIN），对端读取到 EOF，本端仍可继续读取
+// Yamux 的 Close 本身只关闭写方向，重复调用是安全的
+func (c *Conn) CloseWrite() error {
+	if c.reset.Load() != nil {
+		return nil
+	}
+	return c.Stream.Close()
+}
+
+// CloseRead 关闭读方向，本端后续读取返回 net.ErrClosed，对端发送的数据被丢弃
+// 对端继续发送的数据超出丢弃上限时以 transport.CodeCancelled 重置流，对端的写入随之失败
+func (c *Conn) CloseRead() error {
+	c.readClose.ShutdownFunc(c.Stream, func(err error) {
+		if err != nil {
+			c.Reset(transport.CodeCancelled)
+		}
+	})
+	return nil
+}
+
+// Reset 以 code 中止流，模拟方式见 control.go
+// 会话没有控制流时（如通过 NewSession 创建）对端只会读取到 EOF
+func (c *Conn) Reset(code transport.ErrorCode) error {
+	if !c.reset.CompareAndSwap(nil, &transport.StreamError{Code: code}) {
+		return nil
+	}
+
+	var err error
+	if c.session != nil {
+		err = c.session.sendReset(c.StreamID(), code)
+	}
+	if err != nil || c.session == nil {
+		// 无法通知对端，直接关闭
+		c.abort(nil)
+		c.Stream.Close()
+		return err
+	}
+
+	// 等对端关闭后再关闭本端，保证对端先看到错误码
+	c.abort(func() { c.Stream.Close() })
+	return nil
+}
+
+// resetRemote 处理对端的重置：中断本端读写并关闭流
+func (c *Conn) resetRemote(code transport.ErrorCode) {
+	if !c.reset.CompareAndSwap(nil, &transport.StreamError{Code: code, Remote: true}) {
+		return
+	}
+	c.abort(nil)
+	c.Stream.Close()
+}
+
+// abort 中断正在进行的写入，并在后台丢弃对端的数据，读取完毕后调用 done
+func (c *Conn) abort(done func()) {
+	c.Stream.SetWriteDeadline(time.Now())
+	started := c.readClose.ShutdownFunc(c.Stream, func(error) {
+		if done != nil {
+			done()
+		}
+	})
+	if !started && done != nil {
+		done()
+	}
+}
+
+// Stats 返回流的统计信息
+func (c *Conn) Stats() transport.StreamStats {
+	return transport.StreamStats{
+		OpenedAt:     c.openedAt,
+		BytesRead:    c.bytesRead.Load(),
+		BytesWritten: c.bytesWritten.Load(),
+	}
+}
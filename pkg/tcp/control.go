@@ -0,0 +1,292 @@
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/transport"
+	"github.com/hashicorp/yamux"
+)
+
+// Yamux 没有携带错误码的流重置，这里通过每个会话上的一个控制流模拟：
+// 拨号端在会话建立后立即打开控制流并发送 controlMagic，监听端在握手阶段接收它，
+// 之后两端都通过控制流发送 RESET 帧（流 ID + 错误码）和 DATAGRAM 帧（模拟的数据报）。
+//
+// 控制流是可选的：两端在 TLS 握手时通过 ALPN 协商 controlALPN，只有协商成功时才建立控制流。
+// 任一端是没有控制流的旧版本时，会话退化为 NewSession 的行为（Reset 只在本端生效，不支持数据报），
+// 旧版本的监听端不会收到控制流，新版本的监听端也不会等待旧版本的拨号端打开控制流。
+//
+// 重置流程：
+//  1. 重置方发送 RESET 帧，之后停止读写，丢弃收到的数据，但暂不发送 FIN
+//  2. 接收方将流标记为已重置，中断正在进行的读写，然后关闭流（发送 FIN）
+//  3. 重置方收到 FIN 后关闭流，流在两端都被释放
+//
+// 重置方推迟发送 FIN，保证接收方一定先看到错误码，而不是普通的 EOF。
+// 对端长时间不响应时，由 Yamux 的 StreamCloseTimeout 强制释放流。
+
+// controlALPN 支持控制流的 ALPN 协议标识，与 controlMagic 的版本一致
+const controlALPN = "qymux-ctl1"
+
+// controlMagic 控制流的标识，协商成功但控制流的版本不一致时握手失败
+var controlMagic = []byte("QYMUXCTL1")
+
+const (
+	// frameReset RESET 帧类型：type (1) | streamID (4) | code (4)
+	frameReset byte = 0x1
+
+	// frameDatagram DATAGRAM 帧类型：type (1) | length (2) | payload
+	frameDatagram byte = 0x2
+
+	// resetFrameSize RESET 帧长度
+	resetFrameSize = 9
+
+	// maxDatagramSize 模拟数据报的最大长度
+	maxDatagramSize = 1<<16 - 1
+
+	// datagramQueueSize 接收队列的长度，队列已满时新到达的数据报被丢弃
+	datagramQueueSize = 128
+
+	// maxPendingResets 尚未被接收的流上最多暂存的重置数
+	maxPendingResets = 1024
+)
+
+// errInvalidControl 控制流格式错误
+var errInvalidControl = errors.New("tcp: invalid control stream")
+
+// clientControlConfig 返回在 ALPN 中优先提供 controlALPN 的拨号端 TLS 配置
+func clientControlConfig(config *tls.Config) *tls.Config {
+	config = config.Clone()
+	config.NextProtos = withControlALPN(config.NextProtos)
+	return config
+}
+
+// serverControlConfig 返回监听端 TLS 配置，只在拨号端提供 controlALPN 时将其加入 ALPN，
+// 不提供的旧版本拨号端按原有配置协商
+func serverControlConfig(config *tls.Config) *tls.Config {
+	base := config
+	config = config.Clone()
+	getConfig := base.GetConfigForClient
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		selected := base
+		if getConfig != nil {
+			c, err := getConfig(hello)
+			if err != nil {
+				return nil, err
+			}
+			if c != nil {
+				selected = c
+			}
+		}
+		if !slices.Contains(hello.SupportedProtos, controlALPN) {
+			if selected == base {
+				return nil, nil
+			}
+			return selected, nil
+		}
+
+		selected = selected.Clone()
+		selected.GetConfigForClient = nil
+		selected.NextProtos = withControlALPN(selected.NextProtos)
+		return selected, nil
+	}
+	return config
+}
+
+// withControlALPN 返回以 controlALPN 开头的 ALPN 列表
+func withControlALPN(protos []string) []string {
+	out := make([]string, 0, len(protos)+1)
+	out = append(out, controlALPN)
+	for _, p := range protos {
+		if p != controlALPN {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// controlNegotiated 判断 TLS 握手是否协商了控制流
+func controlNegotiated(conn *tls.Conn) bool {
+	return conn.ConnectionState().NegotiatedProtocol == controlALPN
+}
+
+// openControl 在拨号端打开控制流
+func openControl(session *yamux.Session) (*yamux.Stream, error) {
+	stream, err := session.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stream.Write(controlMagic); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+// acceptControl 在监听端接收控制流，协商了控制流时它必须是对端打开的第一个流
+func acceptControl(ctx context.Context, session *yamux.Session) (*yamux.Stream, error) {
+	stream, err := session.AcceptStreamWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetReadDeadline(deadline)
+	}
+	magic := make([]byte, len(controlMagic))
+	if _, err := io.ReadFull(stream, magic); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	stream.SetReadDeadline(time.Time{})
+
+	if string(magic) != string(controlMagic) {
+		stream.Close()
+		return nil, errInvalidControl
+	}
+	return stream, nil
+}
+
+// controlLoop 读取对端发送的控制帧，控制流出错时关闭整个会话
+func (s *Session) controlLoop() {
+	r := bufio.NewReader(s.control)
+	buf := make([]byte, maxDatagramSize)
+	for {
+		if err := s.readFrame(r, buf); err != nil {
+			if !s.session.IsClosed() {
+				log.Printf("[Qymux-TCP] 控制流读取失败: %v", err)
+			}
+			s.session.Close()
+			return
+		}
+	}
+}
+
+// readFrame 读取并处理一个控制帧
+func (s *Session) readFrame(r *bufio.Reader, buf []byte) error {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch typ {
+	case frameReset:
+		body := buf[:resetFrameSize-1]
+		if _, err := io.ReadFull(r, body); err != nil {
+			return err
+		}
+		id := binary.BigEndian.Uint32(body[0:4])
+		code := transport.ErrorCode(binary.BigEndian.Uint32(body[4:8]))
+		s.handleReset(id, code)
+	case frameDatagram:
+		if _, err := io.ReadFull(r, buf[:2]); err != nil {
+			return err
+		}
+		payload := buf[:binary.BigEndian.Uint16(buf[:2])]
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return err
+		}
+		s.handleDatagram(payload)
+	default:
+		return fmt.Errorf("%w: unknown frame type %#x", errInvalidControl, typ)
+	}
+	return nil
+}
+
+// sendReset 通知对端流已被重置
+func (s *Session) sendReset(id uint32, code transport.ErrorCode) error {
+	var frame [resetFrameSize]byte
+	frame[0] = frameReset
+	binary.BigEndian.PutUint32(frame[1:5], id)
+	binary.BigEndian.PutUint32(frame[5:9], uint32(code))
+
+	s.controlMu.Lock()
+	defer s.controlMu.Unlock()
+	if _, err := s.control.Write(frame[:]); err != nil {
+		return fmt.Errorf("send reset frame failed: %w", err)
+	}
+	return nil
+}
+
+// handleReset 处理对端发来的重置
+// 尚未登记的流上的重置暂存到登记时再应用：对端打开的流在被接收之前，
+// 本端打开的流在 OpenStream 返回之前（对端只能在 OpenStream 发出 SYN 之后得知流 ID）
+func (s *Session) handleReset(id uint32, code transport.ErrorCode) {
+	s.mu.Lock()
+	c, ok := s.streams[id]
+	if !ok && len(s.pending) < maxPendingResets {
+		if s.isRemoteID(id) && id > s.lastAccepted || !s.isRemoteID(id) && s.opening > 0 {
+			s.pending[id] = code
+		}
+	}
+	s.mu.Unlock()
+
+	if ok {
+		c.resetRemote(code)
+	}
+}
+
+// isRemoteID 判断流是否由对端打开：Yamux 的客户端使用奇数 ID，服务端使用偶数 ID
+func (s *Session) isRemoteID(id uint32) bool {
+	return (id%2 == 1) != s.client
+}
+
+// register 登记流，并应用在接收前已到达的重置
+func (s *Session) register(c *Conn) {
+	id := c.StreamID()
+
+	s.mu.Lock()
+	s.streams[id] = c
+	code, pending := s.pending[id]
+	delete(s.pending, id)
+	if s.isRemoteID(id) && id > s.lastAccepted {
+		s.lastAccepted = id
+	}
+	s.mu.Unlock()
+
+	if pending {
+		c.resetRemote(code)
+	}
+}
+
+// beginOpen 标记一次 OpenStream 开始
+func (s *Session) beginOpen() {
+	s.mu.Lock()
+	s.opening++
+	s.mu.Unlock()
+}
+
+// endOpen 标记一次 OpenStream 结束
+// 没有进行中的 OpenStream 时，剩余的本端流重置都属于已经关闭的流，将其丢弃
+func (s *Session) endOpen() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.opening--
+	if s.opening > 0 {
+		return
+	}
+	for id := range s.pending {
+		if !s.isRemoteID(id) {
+			delete(s.pending, id)
+		}
+	}
+}
+
+// unregister 注销流
+func (s *Session) unregister(c *Conn) {
+	id := c.StreamID()
+
+	s.mu.Lock()
+	if s.streams[id] == c {
+		delete(s.streams, id)
+	}
+	s.mu.Unlock()
+}
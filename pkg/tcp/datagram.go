@@ -0,0 +1,81 @@
+package tcp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Yamux 没有数据报，这里在控制流上发送 DATAGRAM 帧模拟，与 QUIC 的数据报有以下不同：
+//   - 数据报经由 TCP 传输，传输过程中不会丢失、不会乱序，但与所有流共享连接，仍受队头阻塞影响
+//   - 对端的接收队列已满时，新到达的数据报被丢弃，不会阻塞控制流
+//   - 发送与 RESET 帧共用控制流，大量发送会推迟流重置的送达
+//
+// 没有控制流的会话（NewSession 创建，或对端不支持控制流）不支持数据报，MaxDatagramSize 返回 0
+
+var (
+	// errDatagramUnsupported 会话没有控制流
+	errDatagramUnsupported = errors.New("tcp: datagrams not supported without control stream")
+
+	// errDatagramTooLarge 数据报超过 maxDatagramSize
+	errDatagramTooLarge = errors.New("tcp: datagram too large")
+)
+
+// SendDatagram 在控制流上发送一个数据报，实现 transport.DatagramSession
+// 写入受 Yamux 流控限制，对端接收缓慢时阻塞
+func (s *Session) SendDatagram(b []byte) error {
+	if s.control == nil {
+		return errDatagramUnsupported
+	}
+	if len(b) > maxDatagramSize {
+		return fmt.Errorf("%w: %d > %d bytes", errDatagramTooLarge, len(b), maxDatagramSize)
+	}
+
+	frame := make([]byte, 3+len(b))
+	frame[0] = frameDatagram
+	binary.BigEndian.PutUint16(frame[1:3], uint16(len(b)))
+	copy(frame[3:], b)
+
+	s.controlMu.Lock()
+	defer s.controlMu.Unlock()
+	if _, err := s.control.Write(frame); err != nil {
+		return fmt.Errorf("send datagram frame failed: %w", err)
+	}
+	return nil
+}
+
+// ReceiveDatagram 接收对端发送的数据报，会话关闭或 ctx 结束时返回错误
+func (s *Session) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	if s.control == nil {
+		return nil, errDatagramUnsupported
+	}
+
+	select {
+	case b := <-s.datagrams:
+		return b, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.session.CloseChan():
+		return nil, io.EOF
+	}
+}
+
+// MaxDatagramSize 返回可发送的最大数据报长度，没有控制流时返回 0
+func (s *Session) MaxDatagramSize() int {
+	if s.control == nil {
+		return 0
+	}
+	return maxDatagramSize
+}
+
+// handleDatagram 将收到的数据报放入接收队列，队列已满时丢弃
+func (s *Session) handleDatagram(payload []byte) {
+	b := make([]byte, len(payload))
+	copy(b, payload)
+	select {
+	case s.datagrams <- b:
+	default:
+	}
+}
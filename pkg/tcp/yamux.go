@@ -3,42 +3,121 @@ package tcp
 import (
 	"context"
 	"crypto/tls"
+	"log"
 	"net"
+	"sync"
 	"time"
 
-	"github.com/hashicorp/yamux"
+	"github.com/funcx27/qymux/pkg/admission"
+	"github.com/funcx27/qymux/pkg/proxyproto"
+	"github.com/funcx27/qymux/pkg/streamlimit"
 	tlsconfig "github.com/funcx27/qymux/pkg/tls"
 	"github.com/funcx27/qymux/pkg/transport"
+	"github.com/hashicorp/yamux"
 )
 
 // Session 实现 transport.MuxSession 接口
+// 带有控制流的会话同时实现 transport.DatagramSession；对端不支持控制流时 Reset 只在本端生效
 type Session struct {
 	session   *yamux.Session
 	tlsConn   *tls.Conn
 	localAddr net.Addr
+	acceptor  *streamlimit.Acceptor
+
+	// 模拟流重置的控制流，见 control.go
+	client       bool
+	control      *yamux.Stream
+	controlMu    sync.Mutex // 串行化控制帧的写入
+	mu           sync.Mutex
+	streams      map[uint32]*Conn
+	pending      map[uint32]transport.ErrorCode
+	lastAccepted uint32
+	opening      int // 正在进行的 OpenStream 数量
+
+	// 控制流上模拟的数据报，见 datagram.go
+	datagrams chan []byte
 }
 
 // NewSession 创建新的 TCP+Yamux 会话适配器
+// 这样创建的会话没有控制流，流的 Reset 只在本端生效
 func NewSession(session *yamux.Session, tlsConn *tls.Conn, localAddr net.Addr) *Session {
-	return &Session{
+	return newSession(session, tlsConn, localAddr, nil, nil, false)
+}
+
+// newSession 创建会话适配器，并按 opts 限制入站流
+// control 为握手时建立的控制流，client 表示本端是否为拨号端
+func newSession(session *yamux.Session, tlsConn *tls.Conn, localAddr net.Addr, opts *transport.SessionOptions, control *yamux.Stream, client bool) *Session {
+	s := &Session{
 		session:   session,
 		tlsConn:   tlsConn,
 		localAddr: localAddr,
+		client:    client,
+		control:   control,
+		streams:   make(map[uint32]*Conn),
+		pending:   make(map[uint32]transport.ErrorCode),
+	}
+	if session != nil {
+		s.acceptor = streamlimit.NewAcceptor(opts, s.acceptStream, s.rejectStream, session.CloseChan())
+	}
+	if control != nil {
+		s.datagrams = make(chan []byte, datagramQueueSize)
+		go s.controlLoop()
 	}
+	return s
 }
 
 // Accept 接受来自对端的虚拟流
 func (s *Session) Accept() (net.Conn, error) {
-	conn, err := s.session.Accept()
+	return s.AcceptStream()
+}
+
+// AcceptStream 接受来自对端的虚拟流
+func (s *Session) AcceptStream() (transport.Stream, error) {
+	stream, release, err := s.acceptor.Accept()
 	if err != nil {
 		return nil, err
 	}
-	return conn, nil
+
+	c := stream.(*Conn)
+	c.release = release
+	return c, nil
+}
+
+// acceptStream 从 Yamux 会话接收下一个流
+func (s *Session) acceptStream() (net.Conn, error) {
+	stream, err := s.session.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	return newConn(s, stream), nil
+}
+
+// rejectStream 以 transport.CodeRejected 重置超出限制的流
+func (s *Session) rejectStream(conn net.Conn) {
+	c := conn.(*Conn)
+	c.Reset(transport.CodeRejected)
+	c.Close()
 }
 
 // OpenStream 发起一个新的虚拟流
-func (s *Session) OpenStream() (net.Conn, error) {
-	return s.session.OpenStream()
+func (s *Session) OpenStream() (transport.Stream, error) {
+	// 对端的重置可能先于流的登记到达，登记完成前暂存，见 handleReset
+	s.beginOpen()
+	defer s.endOpen()
+
+	stream, err := s.session.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	return newConn(s, stream), nil
+}
+
+// RejectedStreams 返回因超出限制而被拒绝的入站流数量
+func (s *Session) RejectedStreams() uint64 {
+	if s.acceptor == nil {
+		return 0
+	}
+	return s.acceptor.Rejected()
 }
 
 // Protocol 返回协议类型
@@ -46,6 +125,14 @@ func (s *Session) Protocol() string {
 	return "TCP"
 }
 
+// Done 返回会话关闭时关闭的 channel
+func (s *Session) Done() <-chan struct{} {
+	if s.session == nil {
+		return nil
+	}
+	return s.session.CloseChan()
+}
+
 // Close 关闭会话
 func (s *Session) Close() error {
 	// 关闭 Yamux 会话
@@ -64,19 +151,35 @@ func (s *Session) Addr() net.Addr {
 	return s.localAddr
 }
 
+// RemoteAddr 返回对端地址
+// 监听端启用 PROXY 协议时为真实的客户端地址
+func (s *Session) RemoteAddr() net.Addr {
+	if s.tlsConn == nil {
+		return nil
+	}
+	return s.tlsConn.RemoteAddr()
+}
+
 // Dialer 实现 TCP+Yamux 拨号器
 type Dialer struct {
-	tlsConfig *tls.Config
-	timeout   time.Duration
+	tlsConfig   *tls.Config
+	timeout     time.Duration
+	sessionOpts *transport.SessionOptions
 }
 
 // NewDialer 创建新的 TCP+Yamux 拨号器
 func NewDialer(tlsConfig *tls.Config) *Dialer {
+	return NewDialerWithOptions(tlsConfig, nil)
+}
+
+// NewDialerWithOptions 使用会话配置创建 TCP+Yamux 拨号器
+func NewDialerWithOptions(tlsConfig *tls.Config, sessionOpts *transport.SessionOptions) *Dialer {
 	tlsConfig = tlsconfig.EnsureClientTLSConfig(tlsConfig)
 
 	return &Dialer{
-		tlsConfig: tlsConfig,
-		timeout:   10 * time.Second,
+		tlsConfig:   clientControlConfig(tlsConfig),
+		timeout:     10 * time.Second,
+		sessionOpts: sessionOpts,
 	}
 }
 
@@ -99,54 +202,154 @@ func (d *Dialer) Dial(target string) (transport.MuxSession, error) {
 	}
 
 	// 在 TLS 上创建 Yamux 会话
-	session, err := yamux.Client(tlsConn, defaultYamuxConfig())
+	session, err := yamux.Client(tlsConn, yamuxConfig(d.sessionOpts))
 	if err != nil {
 		tlsConn.Close()
 		return nil, err
 	}
 
-	return NewSession(session, tlsConn, tlsConn.LocalAddr()), nil
+	// 对端支持时打开用于模拟流重置的控制流，见 control.go
+	var control *yamux.Stream
+	if controlNegotiated(tlsConn) {
+		if control, err = openControl(session); err != nil {
+			session.Close()
+			tlsConn.Close()
+			return nil, err
+		}
+	}
+
+	return newSession(session, tlsConn, tlsConn.LocalAddr(), d.sessionOpts, control, true), nil
 }
 
 // Listener 实现 TCP+Yamux 监听器
 type Listener struct {
-	ln        net.Listener
-	tlsConfig *tls.Config
-	localAddr net.Addr
+	ln          net.Listener
+	tlsConfig   *tls.Config
+	localAddr   net.Addr
+	admission   *admission.Controller
+	sessionOpts *transport.SessionOptions
+	acceptChan  chan *Session
+	done        chan struct{}
+	err         error
 }
 
+// handshakeTimeout 服务端 TLS 握手超时时间
+const handshakeTimeout = 10 * time.Second
+
 // NewListener 创建新的 TCP+Yamux 监听器
 func NewListener(ln net.Listener, tlsConfig *tls.Config, localAddr net.Addr) *Listener {
-	return &Listener{
-		ln:        ln,
-		tlsConfig: tlsConfig,
-		localAddr: localAddr,
+	return newListener(ln, tlsConfig, localAddr, nil, nil)
+}
+
+// newListener 创建监听器并启动接受 goroutine
+func newListener(ln net.Listener, tlsConfig *tls.Config, localAddr net.Addr, opts *transport.ListenerOptions, sessionOpts *transport.SessionOptions) *Listener {
+	if tlsConfig != nil {
+		tlsConfig = serverControlConfig(tlsConfig)
+	}
+	l := &Listener{
+		ln:          ln,
+		tlsConfig:   tlsConfig,
+		localAddr:   localAddr,
+		sessionOpts: sessionOpts,
+		acceptChan:  make(chan *Session, 10),
+		done:        make(chan struct{}),
+	}
+	if opts != nil {
+		l.admission = opts.Admission
+	}
+
+	// 启动接受 goroutine
+	go l.acceptLoop()
+
+	return l
+}
+
+// acceptLoop 持续接受新连接，每个连接在独立的 goroutine 中握手
+// 单个连接的握手失败只会关闭该连接，不会中断监听
+func (l *Listener) acceptLoop() {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			l.err = err
+			close(l.done)
+			return
+		}
+
+		go l.handleConn(conn)
+	}
+}
+
+// handleConn 执行准入检查和握手，成功后将会话交给 Accept
+func (l *Listener) handleConn(conn net.Conn) {
+	// 准入检查在 TLS 握手之前执行，避免被拒绝的连接消耗 CPU
+	ticket, err := l.admission.Admit(conn.RemoteAddr())
+	if err != nil {
+		log.Printf("[Qymux-TCP] 拒绝来自 %s 的连接: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	session, err := l.handshake(conn)
+	if err != nil {
+		ticket.Release()
+		log.Printf("[Qymux-TCP] 来自 %s 的连接握手失败: %v", conn.RemoteAddr(), err)
+		return
+	}
+	ticket.HandshakeDone()
+
+	// 会话结束时归还会话名额
+	go func() {
+		<-session.session.CloseChan()
+		ticket.Release()
+	}()
+
+	select {
+	case l.acceptChan <- session:
+	case <-l.done:
+		session.Close()
 	}
 }
 
 // Accept 接受新连接
 func (l *Listener) Accept() (transport.MuxSession, error) {
-	// 接受 TCP 连接
-	conn, err := l.ln.Accept()
-	if err != nil {
-		return nil, err
+	select {
+	case session := <-l.acceptChan:
+		return session, nil
+	case <-l.done:
+		return nil, l.err
 	}
+}
+
+// handshake 在新连接上完成 TLS 握手并创建 Yamux 会话
+func (l *Listener) handshake(conn net.Conn) (*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
 
 	// 建立 TLS 连接
 	tlsConn := tls.Server(conn, l.tlsConfig)
-	if err := tlsConn.Handshake(); err != nil {
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
 		conn.Close()
 		return nil, err
 	}
 
 	// 在 TLS 上创建 Yamux 会话
-	session, err := yamux.Server(tlsConn, defaultYamuxConfig())
+	session, err := yamux.Server(tlsConn, yamuxConfig(l.sessionOpts))
 	if err != nil {
 		tlsConn.Close()
 		return nil, err
 	}
 
-	return NewSession(session, tlsConn, l.localAddr), nil
+	// 对端支持时接收其打开的控制流，见 control.go
+	var control *yamux.Stream
+	if controlNegotiated(tlsConn) {
+		if control, err = acceptControl(ctx, session); err != nil {
+			session.Close()
+			tlsConn.Close()
+			return nil, err
+		}
+	}
+
+	return newSession(session, tlsConn, l.localAddr, l.sessionOpts, control, false), nil
 }
 
 // Close 关闭监听器
@@ -161,6 +364,11 @@ func (l *Listener) Addr() net.Addr {
 
 // Listen 创建 TCP+Yamux 监听器
 func Listen(addr string, tlsConfig *tls.Config) (*Listener, error) {
+	return ListenWithOptions(addr, tlsConfig, nil, nil)
+}
+
+// ListenWithOptions 使用可选配置创建 TCP+Yamux 监听器
+func ListenWithOptions(addr string, tlsConfig *tls.Config, opts *transport.ListenerOptions, sessionOpts *transport.SessionOptions) (*Listener, error) {
 	var err error
 	tlsConfig, err = tlsconfig.EnsureServerTLSConfig(tlsConfig)
 	if err != nil {
@@ -171,8 +379,19 @@ func Listen(addr string, tlsConfig *tls.Config) (*Listener, error) {
 	if err != nil {
 		return nil, err
 	}
+	localAddr := ln.Addr()
 
-	return NewListener(ln, tlsConfig, ln.Addr()), nil
+	// 在 TLS 握手之前解析 PROXY 协议头
+	if opts != nil && opts.ProxyProtocol != nil {
+		proxyLn, err := proxyproto.NewListener(ln, opts.ProxyProtocol)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+		ln = proxyLn
+	}
+
+	return newListener(ln, tlsConfig, localAddr, opts, sessionOpts), nil
 }
 
 // defaultYamuxConfig 返回默认的 Yamux 配置
@@ -181,3 +400,11 @@ func defaultYamuxConfig() *yamux.Config {
 	config.Logger = nil // 禁用 yamux 的日志
 	return config
 }
+
+// yamuxConfig 根据会话配置返回 Yamux 配置
+// Yamux 假设两端的 AcceptBacklog 一致，本端据此限制未被对端确认的新流数量
+func yamuxConfig(opts *transport.SessionOptions) *yamux.Config {
+	config := defaultYamuxConfig()
+	config.AcceptBacklog = streamlimit.AcceptBacklog(opts)
+	return config
+}
@@ -1,8 +1,20 @@
 package tcp
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
+	"io"
+	"net"
 	"testing"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/admission"
+	"github.com/funcx27/qymux/pkg/cert"
+	"github.com/funcx27/qymux/pkg/proxyproto"
+	tlsconfig "github.com/funcx27/qymux/pkg/tls"
+	"github.com/funcx27/qymux/pkg/transport"
+	"github.com/hashicorp/yamux"
 )
 
 func TestNewDialer(t *testing.T) {
@@ -40,3 +52,416 @@ func TestSessionAddr(t *testing.T) {
 		t.Error("Session with nil localAddr should have nil Addr()")
 	}
 }
+
+func TestListenWithProxyProtocol(t *testing.T) {
+	ln, err := ListenWithOptions("127.0.0.1:0", nil, &transport.ListenerOptions{
+		ProxyProtocol: &proxyproto.Config{TrustedCIDRs: []string{"127.0.0.1"}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("ListenWithOptions() error = %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("PROXY TCP4 198.51.100.9 10.0.0.1 4321 9090\r\n"))
+
+		tlsConn := tls.Client(conn, tlsconfig.EnsureClientTLSConfig(nil))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return
+		}
+		session, err := yamux.Client(tlsConn, defaultYamuxConfig())
+		if err != nil {
+			conn.Close()
+			return
+		}
+		defer session.Close()
+		if _, err := openControl(session); err != nil {
+			return
+		}
+		time.Sleep(time.Second)
+	}()
+
+	session, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer session.Close()
+
+	if got := session.RemoteAddr().String(); got != "198.51.100.9:4321" {
+		t.Errorf("RemoteAddr() = %v, want 198.51.100.9:4321", got)
+	}
+}
+
+func TestListenWithAdmission(t *testing.T) {
+	controller, err := admission.NewController(&admission.Config{DenyCIDRs: []string{"127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("NewController() error = %v", err)
+	}
+
+	ln, err := ListenWithOptions("127.0.0.1:0", nil, &transport.ListenerOptions{Admission: controller}, nil)
+	if err != nil {
+		t.Fatalf("ListenWithOptions() error = %v", err)
+	}
+	defer ln.Close()
+
+	// 被拒绝的连接在 TLS 握手之前关闭
+	if _, err := NewDialer(nil).Dial(ln.Addr().String()); err == nil {
+		t.Error("Dial() from denied source should fail")
+	}
+	if stats := controller.Stats(); stats.Denied != 1 {
+		t.Errorf("Stats().Denied = %d, want 1", stats.Denied)
+	}
+}
+
+// newSessionPair 在本地回环上建立一对会话
+func newSessionPair(t *testing.T) (client, server transport.MuxSession) {
+	t.Helper()
+
+	ln, err := Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	client, err = NewDialer(nil).Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	server, err = ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return client, server
+}
+
+func TestConnHalfClose(t *testing.T) {
+	client, server := newSessionPair(t)
+
+	conn, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("request"))
+
+	// 关闭写方向后对端读到 EOF，本端仍可读取响应
+	if err := conn.(transport.Stream).CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite() error = %v", err)
+	}
+
+	peer, err := server.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer peer.Close()
+
+	data, err := io.ReadAll(peer)
+	if err != nil || string(data) != "request" {
+		t.Fatalf("ReadAll() = %q, %v; want %q", data, err, "request")
+	}
+	peer.Write([]byte("response"))
+	peer.(transport.Stream).CloseWrite()
+
+	data, err = io.ReadAll(conn)
+	if err != nil || string(data) != "response" {
+		t.Errorf("ReadAll() = %q, %v; want %q", data, err, "response")
+	}
+}
+
+func TestConnCloseRead(t *testing.T) {
+	client, server := newSessionPair(t)
+
+	conn, _ := client.OpenStream()
+	defer conn.Close()
+	conn.Write([]byte("x"))
+
+	peer, err := server.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer peer.Close()
+
+	conn.(transport.Stream).CloseRead()
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Read() after CloseRead error = %v, want net.ErrClosed", err)
+	}
+
+	// 对端写入的数据被丢弃，不会因窗口耗尽而阻塞
+	if _, err := peer.Write(make([]byte, 1<<20)); err != nil {
+		t.Errorf("peer Write() error = %v", err)
+	}
+
+	// 超出丢弃上限后流被重置，对端的写入失败
+	var streamErr *transport.StreamError
+	buf := make([]byte, 64<<10)
+	for i := 0; i < 64; i++ {
+		if _, err := peer.Write(buf); err != nil {
+			if !errors.As(err, &streamErr) || streamErr.Code != transport.CodeCancelled || !streamErr.Remote {
+				t.Errorf("peer Write() error = %v, want remote reset with CodeCancelled", err)
+			}
+			return
+		}
+	}
+	t.Error("peer Write() beyond drain limit should fail")
+}
+
+func TestConnReset(t *testing.T) {
+	client, server := newSessionPair(t)
+
+	conn, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("request"))
+
+	peer, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("AcceptStream() error = %v", err)
+	}
+	defer peer.Close()
+	if peer.ID() != conn.ID() {
+		t.Errorf("ID() = %d, want %d", peer.ID(), conn.ID())
+	}
+
+	// 阻塞中的读取被对端的重置中断，并携带错误码
+	buf := make([]byte, 7)
+	io.ReadFull(peer, buf)
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := peer.Read(buf)
+		errCh <- err
+	}()
+
+	const code = transport.CodeApplication + 7
+	if err := conn.Reset(code); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+
+	var streamErr *transport.StreamError
+	select {
+	case err := <-errCh:
+		if !errors.As(err, &streamErr) || streamErr.Code != code || !streamErr.Remote {
+			t.Errorf("peer Read() error = %v, want remote reset with code %#x", err, code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer Read() not interrupted by Reset")
+	}
+	if _, err := peer.Write([]byte("x")); !errors.As(err, &streamErr) {
+		t.Errorf("peer Write() error = %v, want *transport.StreamError", err)
+	}
+	if _, err := conn.Write([]byte("x")); !errors.As(err, &streamErr) || streamErr.Remote {
+		t.Errorf("Write() after Reset error = %v, want local reset", err)
+	}
+
+	if stats := conn.Stats(); stats.BytesWritten != 7 || stats.OpenedAt.IsZero() {
+		t.Errorf("Stats() = %+v, want 7 bytes written", stats)
+	}
+	if stats := peer.Stats(); stats.BytesRead != 7 {
+		t.Errorf("peer Stats().BytesRead = %d, want 7", stats.BytesRead)
+	}
+}
+
+func TestResetBeforeRegister(t *testing.T) {
+	client, _ := newSessionPair(t)
+	s := client.(*Session)
+
+	// 重置先于本端 OpenStream 的登记到达时不阻塞控制流，登记时再应用
+	s.beginOpen()
+	const code = transport.CodeApplication + 3
+	done := make(chan struct{})
+	go func() {
+		s.handleReset(3, code) // 控制流的 ID 为 1，下一个本端流为 3
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handleReset() blocked by in-flight OpenStream")
+	}
+
+	conn, err := s.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer conn.Close()
+	var streamErr *transport.StreamError
+	if _, err := conn.Read(make([]byte, 1)); conn.ID() != 3 || !errors.As(err, &streamErr) || streamErr.Code != code {
+		t.Errorf("stream %d Read() error = %v, want remote reset with code %#x", conn.ID(), err, code)
+	}
+
+	// 没有进行中的 OpenStream 后，已关闭流的重置不再暂存
+	s.endOpen()
+	s.handleReset(5, code)
+	if n := len(s.pending); n != 0 {
+		t.Errorf("len(pending) = %d, want 0", n)
+	}
+}
+
+func TestSessionDatagram(t *testing.T) {
+	c, s := newSessionPair(t)
+	client, server := c.(*Session), s.(*Session)
+
+	if max := client.MaxDatagramSize(); max != maxDatagramSize {
+		t.Fatalf("MaxDatagramSize() = %d, want %d", max, maxDatagramSize)
+	}
+	if err := client.SendDatagram(make([]byte, maxDatagramSize+1)); !errors.Is(err, errDatagramTooLarge) {
+		t.Errorf("SendDatagram() oversized error = %v, want errDatagramTooLarge", err)
+	}
+
+	// 数据报与流上的重置共用控制流，互不干扰
+	for _, msg := range []string{"first", "", "third"} {
+		if err := client.SendDatagram([]byte(msg)); err != nil {
+			t.Fatalf("SendDatagram() error = %v", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, want := range []string{"first", "", "third"} {
+		got, err := server.ReceiveDatagram(ctx)
+		if err != nil || string(got) != want {
+			t.Fatalf("ReceiveDatagram() = %q, %v; want %q", got, err, want)
+		}
+	}
+
+	// 接收队列已满时丢弃，不阻塞控制流
+	for i := 0; i < datagramQueueSize+10; i++ {
+		server.SendDatagram([]byte{byte(i)})
+	}
+	conn, _ := server.OpenStream()
+	defer conn.Close()
+	conn.Reset(transport.CodeCancelled)
+	peer, err := client.AcceptStream()
+	if err != nil {
+		t.Fatalf("AcceptStream() error = %v", err)
+	}
+	defer peer.Close()
+	var streamErr *transport.StreamError
+	if _, err := peer.Read(make([]byte, 1)); !errors.As(err, &streamErr) {
+		t.Errorf("Read() after Reset error = %v, want *transport.StreamError", err)
+	}
+	if n := len(client.datagrams); n != datagramQueueSize {
+		t.Errorf("queued datagrams = %d, want %d", n, datagramQueueSize)
+	}
+
+	if NewSession(nil, nil, nil).MaxDatagramSize() != 0 {
+		t.Error("MaxDatagramSize() without control stream != 0")
+	}
+}
+
+// readString 从流中读取 len(want) 字节
+func readString(t *testing.T, r io.Reader, want string) {
+	t.Helper()
+
+	buf := make([]byte, len(want))
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != want {
+		t.Fatalf("Read() = %q, %v; want %q", buf, err, want)
+	}
+}
+
+func TestControlLegacyDialer(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+
+	// 没有控制流的旧版本拨号端：不提供 controlALPN，也不打开控制流
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: []string{cert.ALPN}})
+	if err != nil {
+		t.Fatalf("tls.Dial() error = %v", err)
+	}
+	defer conn.Close()
+	legacy, err := yamux.Client(conn, defaultYamuxConfig())
+	if err != nil {
+		t.Fatalf("yamux.Client() error = %v", err)
+	}
+	defer legacy.Close()
+
+	// 监听端不等待控制流，立即返回会话
+	start := time.Now()
+	sess, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer sess.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Accept() took %v for a legacy dialer", elapsed)
+	}
+	if max := sess.(*Session).MaxDatagramSize(); max != 0 {
+		t.Errorf("MaxDatagramSize() = %d, want 0 without control stream", max)
+	}
+
+	stream, err := legacy.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer stream.Close()
+	stream.Write([]byte("hello"))
+	accepted, err := sess.AcceptStream()
+	if err != nil {
+		t.Fatalf("AcceptStream() error = %v", err)
+	}
+	defer accepted.Close()
+	readString(t, accepted, "hello")
+}
+
+func TestControlLegacyListener(t *testing.T) {
+	config, err := cert.GenerateSelfSignedConfig()
+	if err != nil {
+		t.Fatalf("GenerateSelfSignedConfig() error = %v", err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("tls.Listen() error = %v", err)
+	}
+	defer ln.Close()
+
+	// 没有控制流的旧版本监听端：应用收到的第一个流是拨号端打开的普通流，而不是控制流
+	accepted := make(chan *yamux.Stream, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		legacy, err := yamux.Server(conn, defaultYamuxConfig())
+		if err != nil {
+			return
+		}
+		if stream, err := legacy.AcceptStream(); err == nil {
+			accepted <- stream
+		}
+	}()
+
+	sess, err := NewDialer(nil).Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer sess.Close()
+	if max := sess.(*Session).MaxDatagramSize(); max != 0 {
+		t.Errorf("MaxDatagramSize() = %d, want 0 without control stream", max)
+	}
+
+	stream, err := sess.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer stream.Close()
+	stream.Write([]byte("hello"))
+
+	select {
+	case s := <-accepted:
+		defer s.Close()
+		readString(t, s, "hello")
+	case <-time.After(5 * time.Second):
+		t.Fatal("legacy listener accepted no stream")
+	}
+}
@@ -1,6 +1,15 @@
 package transport
 
-import "net"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/funcx27/qymux/pkg/admission"
+	"github.com/funcx27/qymux/pkg/proxyproto"
+)
 
 // TransportMode 定义传输模式
 type TransportMode string
@@ -14,18 +23,172 @@ const (
 // MuxSession 定义多路复用会话接口
 // 所有传输层实现（QUIC、TCP+Yamux）都必须实现此接口
 type MuxSession interface {
-	net.Listener // 实现 Accept() 以接收来自对端的虚拟流 (Stream)
+	net.Listener // 实现 Accept() 以接收来自对端的虚拟流，返回值同样实现 Stream
 
-	// OpenStream 发起一个新的虚拟流 (Stream)
-	OpenStream() (net.Conn, error)
+	// AcceptStream 接收来自对端的虚拟流
+	AcceptStream() (Stream, error)
+
+	// OpenStream 发起一个新的虚拟流
+	OpenStream() (Stream, error)
 
 	// Protocol 返回实际使用的协议 ("QUIC" 或 "TCP")
 	Protocol() string
 
+	// RemoteAddr 返回对端地址
+	// 监听端启用 PROXY 协议时为负载均衡器之后的真实客户端地址
+	RemoteAddr() net.Addr
+
 	// Close 关闭会话
 	Close() error
 }
 
+// Stream 是与传输无关的虚拟流
+// MuxSession 的 OpenStream、AcceptStream 和 Accept 返回的流均实现此接口，QUIC 与 Yamux 的语义一致：
+//
+//   - CloseWrite 发送 FIN，对端读完已发送的数据后读取到 io.EOF，本端仍可继续读取
+//   - CloseRead 后本端读取返回 net.ErrClosed，对端发送的数据被丢弃；丢弃的数据超过 1 MiB 或 10 秒时
+//     以 CodeCancelled 中止，对端的写入返回 *StreamError，避免后台无限读取
+//   - Close 等同于 CloseWrite 加 CloseRead
+//   - Reset 立即中止两个方向，未发送和未读取的数据被丢弃，
+//     两端后续的读写都返回携带错误码的 *StreamError
+type Stream interface {
+	net.Conn
+
+	// ID 返回流 ID，同一会话内唯一，两端看到的值相同
+	ID() uint64
+
+	// CloseWrite 关闭写方向
+	CloseWrite() error
+
+	// CloseRead 关闭读方向
+	CloseRead() error
+
+	// Reset 以 code 中止流
+	Reset(code ErrorCode) error
+
+	// Stats 返回流的统计信息
+	Stats() StreamStats
+}
+
+// ErrorCode 是流重置时携带的错误码
+// 0x00-0xff 为保留值，应用自定义的错误码应从 CodeApplication 开始
+type ErrorCode uint32
+
+const (
+	CodeNoError     ErrorCode = 0x0   // 正常中止
+	CodeRejected    ErrorCode = 0x1   // 超出会话的流数限制而被拒绝
+	CodeCancelled   ErrorCode = 0x2   // 请求已取消
+	CodeInternal    ErrorCode = 0x3   // 内部错误
+	CodeRefused     ErrorCode = 0x4   // 对端拒绝处理该流
+	CodeTimeout     ErrorCode = 0x5   // 处理超时
+	CodeApplication ErrorCode = 0x100 // 应用自定义错误码的起始值
+)
+
+// StreamError 是流被重置后读写返回的错误
+type StreamError struct {
+	Code   ErrorCode
+	Remote bool // 是否由对端重置
+}
+
+// Error 实现 error 接口
+func (e *StreamError) Error() string {
+	if e.Remote {
+		return fmt.Sprintf("transport: stream reset by peer with code %#x", uint32(e.Code))
+	}
+	return fmt.Sprintf("transport: stream reset with code %#x", uint32(e.Code))
+}
+
+// StreamStats 是单个流的统计信息
+type StreamStats struct {
+	OpenedAt     time.Time // 流打开或被接收的时间
+	BytesRead    uint64    // 已读取的应用数据字节数
+	BytesWritten uint64    // 已写入的应用数据字节数
+}
+
+// ResetStream 以 code 中止 conn，conn 不是 Stream 时直接关闭
+// 用于包装了流的 net.Conn，以便将重置传递到底层
+func ResetStream(conn net.Conn, code ErrorCode) error {
+	if s, ok := conn.(interface{ Reset(ErrorCode) error }); ok {
+		return s.Reset(code)
+	}
+	return conn.Close()
+}
+
+// ErrHalfCloseUnsupported 连接不支持半关闭
+var ErrHalfCloseUnsupported = errors.New("transport: half-close not supported")
+
+// CloseWrite 关闭 conn 的写方向，conn 不支持时返回 ErrHalfCloseUnsupported
+// 用于包装了流的 net.Conn（如限速、服务流），以便将半关闭传递到底层
+func CloseWrite(conn net.Conn) error {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		return c.CloseWrite()
+	}
+	return ErrHalfCloseUnsupported
+}
+
+// CloseRead 关闭 conn 的读方向，conn 不支持时返回 ErrHalfCloseUnsupported
+func CloseRead(conn net.Conn) error {
+	if c, ok := conn.(interface{ CloseRead() error }); ok {
+		return c.CloseRead()
+	}
+	return ErrHalfCloseUnsupported
+}
+
+// DatagramSession 是支持不可靠数据报的会话
+// 数据报与流相互独立，适合遥测、实时音视频等宁可丢弃也不愿等待重传的数据：
+//   - QUIC 会话使用 RFC 9221 数据报，不保证送达和顺序，不受流的队头阻塞影响
+//   - TCP+Yamux 会话通过控制流模拟：传输过程中不会丢失或乱序，但与所有流共享同一个 TCP 连接，
+//     仍会被丢包重传阻塞；对端接收队列已满时数据报被丢弃
+//
+// 数据报的接收是会话级的，同一会话上只应有一个使用者调用 ReceiveDatagram
+type DatagramSession interface {
+	MuxSession
+
+	// SendDatagram 发送一个数据报，发送队列已满时阻塞
+	// 超过 MaxDatagramSize 时返回错误
+	SendDatagram(b []byte) error
+
+	// ReceiveDatagram 接收一个数据报，会话关闭或 ctx 结束时返回错误
+	ReceiveDatagram(ctx context.Context) ([]byte, error)
+
+	// MaxDatagramSize 返回当前可发送的最大数据报长度，对端不支持数据报时返回 0
+	// QUIC 的上限随路径 MTU 变化
+	MaxDatagramSize() int
+}
+
+// Datagrams 返回会话的数据报能力，对端不支持时返回 false
+// 会话包装了其他会话（实现 Unwrap() MuxSession）时查找底层会话，数据报不经过包装层（例如带宽整形）
+func Datagrams(sess MuxSession) (DatagramSession, bool) {
+	for sess != nil {
+		if d, ok := sess.(DatagramSession); ok {
+			return d, d.MaxDatagramSize() > 0
+		}
+		u, ok := sess.(interface{ Unwrap() MuxSession })
+		if !ok {
+			return nil, false
+		}
+		sess = u.Unwrap()
+	}
+	return nil, false
+}
+
+// SessionDone 返回会话关闭时关闭的 channel
+// 会话实现了 Done() 时直接使用；包装了其他会话（实现 Unwrap() MuxSession）时查找底层会话；
+// 都不支持时返回 nil
+func SessionDone(sess MuxSession) <-chan struct{} {
+	for sess != nil {
+		if d, ok := sess.(interface{ Done() <-chan struct{} }); ok {
+			return d.Done()
+		}
+		u, ok := sess.(interface{ Unwrap() MuxSession })
+		if !ok {
+			return nil
+		}
+		sess = u.Unwrap()
+	}
+	return nil
+}
+
 // Config 定义拨号器配置
 type Config struct {
 	// Mode 传输模式：auto/quic/tcp
@@ -33,6 +196,22 @@ type Config struct {
 
 	// TLSConfig TLS 配置，为 nil 时会自动生成自签名证书
 	TLSConfig interface{} // 实际使用时转换为 *tls.Config
+
+	// Listener 监听器可选配置，仅对监听端生效
+	Listener *ListenerOptions
+
+	// Session 会话可选配置，对拨号端和监听端均生效
+	Session *SessionOptions
+}
+
+// ListenerOptions 定义监听器的可选配置
+type ListenerOptions struct {
+	// ProxyProtocol PROXY 协议配置，仅对 TCP 监听器生效，为 nil 时不解析
+	ProxyProtocol *proxyproto.Config
+
+	// Admission 准入控制器，在握手之前执行限流和访问控制，为 nil 时不限制
+	// 同一个控制器可在 QUIC 与 TCP 监听器之间共享，使限制对两者合并生效
+	Admission *admission.Controller
 }
 
 // Dialer 定义拨号器接口
@@ -40,3 +219,42 @@ type Dialer interface {
 	// Dial 根据配置建立多路复用会话
 	Dial(target string) (MuxSession, error)
 }
+
+// BacklogPolicy 定义应用层 Accept 过慢、接收积压已满时的行为
+type BacklogPolicy string
+
+const (
+	// BacklogBlock 暂停接收新流，对端打开新流时阻塞，直到应用层 Accept 或有流关闭
+	BacklogBlock BacklogPolicy = "block"
+
+	// BacklogReject 立即拒绝超出限制的新流，对端在读写时收到错误
+	BacklogReject BacklogPolicy = "reject"
+)
+
+// SessionOptions 定义会话的可选配置
+type SessionOptions struct {
+	// MaxIncomingStreams 对端可同时打开的最大流数，流在本端 Close 后归还名额
+	// 为 0 时 TCP 不限制，QUIC 使用 quic-go 的默认值
+	MaxIncomingStreams int
+
+	// AcceptBacklog 已被对端打开但尚未被 Accept 的最大流数，为 0 时默认为 256
+	AcceptBacklog int
+
+	// BacklogPolicy 达到上述限制时的行为，默认为 BacklogBlock
+	BacklogPolicy BacklogPolicy
+
+	// Bandwidth 会话建立时应用的带宽限制，为 nil 时不限速
+	Bandwidth *BandwidthLimits
+}
+
+// BandwidthLimits 定义带宽限制，单位为字节/秒，0 表示不限速
+// Up 对应本端写入（发送）方向，Down 对应本端读取（接收）方向
+type BandwidthLimits struct {
+	// SessionUp / SessionDown 会话内所有流共享的限速
+	SessionUp   float64
+	SessionDown float64
+
+	// StreamUp / StreamDown 每个流单独的限速
+	StreamUp   float64
+	StreamDown float64
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := ParseCIDRs([]string{"10.0.0.0/8", "192.168.1.5", " ", "::1"})
+	if err != nil {
+		t.Fatalf("ParseCIDRs() error = %v", err)
+	}
+	if len(nets) != 3 {
+		t.Fatalf("ParseCIDRs() returned %d nets, want 3", len(nets))
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"192.168.1.5", true},
+		{"192.168.1.6", false},
+		{"::1", true},
+	}
+	for _, tt := range tests {
+		if got := ContainsIP(nets, net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("ContainsIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	if _, err := ParseCIDRs([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("ParseCIDRs() with invalid CIDR should fail")
+	}
+}
+
+func TestAddrIP(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 80}
+	if ip := AddrIP(addr); !ip.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("AddrIP() = %v, want 127.0.0.1", ip)
+	}
+	if ip := AddrIP(nil); ip != nil {
+		t.Errorf("AddrIP(nil) = %v, want nil", ip)
+	}
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DeadlineReader 是可以设置读截止时间的 io.Reader
+type DeadlineReader interface {
+	io.Reader
+	SetReadDeadline(t time.Time) error
+}
+
+const (
+	// maxDrainBytes 读方向关闭后最多丢弃的字节数
+	maxDrainBytes = 1 << 20
+
+	// maxDrainTime 读方向关闭后等待对端结束发送的最长时间
+	maxDrainTime = 10 * time.Second
+)
+
+// ErrDrainLimit 读方向关闭后对端继续发送的数据超出了丢弃上限
+var ErrDrainLimit = errors.New("utils: drain limit exceeded")
+
+// ReadShutdown 实现流读方向的本地关闭，语义与 shutdown(SHUT_RD) 一致：
+// 关闭后本端读取立即返回 net.ErrClosed，对端后续发送的数据在后台读取并丢弃，
+// 不会让对端因流控窗口耗尽而阻塞。丢弃的数据量和时间都有上限，
+// 超出后停止读取，由调用方中止读方向通知对端
+type ReadShutdown struct {
+	mu     sync.Mutex // 保证同一时刻只有一个读取者
+	closed atomic.Bool
+}
+
+// Read 在读方向未关闭时从 src 读取数据
+func (r *ReadShutdown) Read(src io.Reader, b []byte) (int, error) {
+	if r.closed.Load() {
+		return 0, net.ErrClosed
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed.Load() {
+		return 0, net.ErrClosed
+	}
+
+	n, err := src.Read(b)
+	if err != nil && r.closed.Load() {
+		// 读取被 Shutdown 中断
+		return n, net.ErrClosed
+	}
+	return n, err
+}
+
+// Shutdown 关闭读方向，可重复调用；超出丢弃上限时只停止读取，不通知对端
+func (r *ReadShutdown) Shutdown(src DeadlineReader) {
+	r.ShutdownFunc(src, nil)
+}
+
+// ShutdownFunc 关闭读方向，并在后台丢弃对端的数据，结束时调用 done：
+// 收到 EOF 或出错时参数为 nil，丢弃超过 maxDrainBytes 字节或 maxDrainTime 时为 ErrDrainLimit
+// 读方向已经关闭时返回 false，done 不会被调用
+func (r *ReadShutdown) ShutdownFunc(src DeadlineReader, done func(error)) bool {
+	if r.closed.Swap(true) {
+		return false
+	}
+
+	// 中断正在进行的读取，然后在后台丢弃剩余数据
+	src.SetReadDeadline(time.Now())
+	go func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		src.SetReadDeadline(time.Now().Add(maxDrainTime))
+		_, err := io.CopyN(io.Discard, src, maxDrainBytes+1)
+		if done == nil {
+			return
+		}
+		var netErr net.Error
+		if err == nil || errors.As(err, &netErr) && netErr.Timeout() {
+			done(ErrDrainLimit)
+			return
+		}
+		done(nil)
+	}()
+	return true
+}
+
+// IsShutdown 返回读方向是否已关闭
+func (r *ReadShutdown) IsShutdown() bool {
+	return r.closed.Load()
+}